@@ -51,6 +51,9 @@ func runServer(cmd *cobra.Command, args []string) error {
 	)
 
 	// Create and start server
-	srv := server.New(cfg, log)
+	srv, err := server.New(cfg, log)
+	if err != nil {
+		return err
+	}
 	return srv.Start()
 }
\ No newline at end of file
@@ -1,66 +1,285 @@
 package cache
 
 import (
-	"sync"
+	"errors"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+var ErrOutOfMemory = errors.New("out of memory")
+
+const (
+	DefaultShards = 256
+	
+	// expireSampleSize is how many volatile keys an active expire pass
+	// inspects per lock acquisition.
+	expireSampleSize = 20
+	// expireRepeatRatio keeps a cycle sampling while more than one in
+	// expireRepeatRatio sampled keys turned out to be expired.
+	expireRepeatRatio = 4
+	
+	// entryOverhead approximates what a key costs beyond its name and value:
+	// the Entry itself and its slot in the shard's map.
+	entryOverhead = 128
+)
+
+// Entry is shared between readers holding a shard read lock, so LastUsed and
+// UseCount must only be touched through sync/atomic. String values live in
+// Value; every other type is held in Object.
 type Entry struct {
 	Key       string
 	Value     []byte
+	Object    Object
 	ExpiresAt int64
 	CreatedAt int64
 	LastUsed  int64
-	UseCount  int
+	UseCount  int64
+	
+	// size is what the entry was accounted as when last stored, so that
+	// values mutated in place by Update can be re-accounted.
+	size int64
+	// shared is set once Value has been handed to a caller that may keep
+	// reading it after the shard lock is released.
+	shared int32
+}
+
+func (e *Entry) Type() ValueType {
+	if e.Object == nil {
+		return TypeString
+	}
+	return e.Object.Type()
+}
+
+// Size is what the entry is accounted as against the memory limit: its key,
+// its value and a fixed per-entry overhead.
+func (e *Entry) Size() int64 {
+	size := int64(len(e.Key)+len(e.Value)) + entryOverhead
+	if e.Object != nil {
+		size += e.Object.Size()
+	}
+	return size
+}
+
+// MutableValue returns Value grown to at least n bytes, zero-filled, for
+// writing in place from within Update. If Value was handed out by Get,
+// GetMulti or SetWithOptions it is copied first, so those callers keep
+// seeing the old bytes.
+func (e *Entry) MutableValue(n int) []byte {
+	if n < len(e.Value) {
+		n = len(e.Value)
+	}
+	
+	if atomic.LoadInt32(&e.shared) != 0 || n > cap(e.Value) {
+		value := make([]byte, n, growCap(len(e.Value), n))
+		copy(value, e.Value)
+		e.Value = value
+		atomic.StoreInt32(&e.shared, 0)
+		return e.Value
+	}
+	
+	old := len(e.Value)
+	e.Value = e.Value[:n]
+	clear(e.Value[old:])
+	return e.Value
+}
+
+// growCap picks the capacity for a value growing from old to n bytes,
+// leaving headroom when it grows so repeated growth stays amortized.
+func growCap(old, n int) int {
+	if n > old && n < 2*old {
+		return 2 * old
+	}
+	return n
+}
+
+func (e *Entry) share() {
+	atomic.StoreInt32(&e.shared, 1)
+}
+
+func (e *Entry) touch(now int64) {
+	atomic.StoreInt64(&e.LastUsed, now)
+	atomic.AddInt64(&e.UseCount, 1)
+}
+
+// EvictionPolicy tracks entry accesses and picks keys to drop once the
+// cache grows past maxSize. Every shard owns its own policy and serializes
+// calls into it, so implementations need no locking of their own.
+type EvictionPolicy interface {
+	OnGet(key string, entry *Entry)
+	OnSet(key string, entry *Entry)
+	OnDelete(key string)
+	// GetVictims returns keys whose removal frees at least excess bytes,
+	// or as many as it has if that is not possible.
+	GetVictims(excess int64) []string
 }
 
 type Cache struct {
-	entries map[string]*Entry
-	mu      sync.RWMutex
-	maxSize int64
-	size    int64
+	shards      []*shard
+	mask        uint64
+	maxSize     int64
+	size        int64
+	expired     int64
+	evictCursor uint32
+	// expireCursor is the shard the next active expire cycle starts at, so
+	// a cycle cut short by its budget is picked up where it stopped.
+	expireCursor uint32
 }
 
-func New(maxSize int64) *Cache {
-	return &Cache{
-		entries: make(map[string]*Entry),
+func New(maxSize int64, newPolicy func() EvictionPolicy) *Cache {
+	return NewSharded(maxSize, DefaultShards, newPolicy)
+}
+
+// NewSharded creates a cache split into the given number of shards, rounded
+// up to a power of two. newPolicy is called once per shard and may be nil to
+// disable eviction entirely.
+func NewSharded(maxSize int64, shards int, newPolicy func() EvictionPolicy) *Cache {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	
+	c := &Cache{
+		shards:  make([]*shard, n),
+		mask:    uint64(n - 1),
 		maxSize: maxSize,
 	}
+	for i := range c.shards {
+		var policy EvictionPolicy
+		if newPolicy != nil {
+			policy = newPolicy()
+		}
+		c.shards[i] = newShard(policy)
+	}
+	return c
 }
 
+// Get returns the value of a string key. Keys holding any other type are
+// reported as missing; use GetString to tell the two apart.
 func (c *Cache) Get(key string) ([]byte, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	value, exists, err := c.GetString(key)
+	return value, exists && err == nil
+}
+
+// GetString returns the value of a string key, failing with ErrWrongType if
+// the key holds another type. The value is shared copy-on-write, so it stays
+// unchanged after the call even if the key is written to.
+func (c *Cache) GetString(key string) ([]byte, bool, error) {
+	s := c.shard(key)
+	now := time.Now().UnixNano()
 	
-	entry, exists := c.entries[key]
+	s.mu.RLock()
+	entry, exists := s.get(key)
 	if !exists {
-		return nil, false
+		s.mu.RUnlock()
+		atomic.AddInt64(&s.misses, 1)
+		return nil, false, nil
 	}
 	
-	if entry.ExpiresAt > 0 && time.Now().UnixNano() > entry.ExpiresAt {
-		delete(c.entries, key)
-		c.size -= int64(len(entry.Value))
-		return nil, false
+	if entry.ExpiresAt > 0 && now > entry.ExpiresAt {
+		s.mu.RUnlock()
+		c.expire(s, key)
+		atomic.AddInt64(&s.misses, 1)
+		return nil, false, nil
 	}
 	
-	entry.LastUsed = time.Now().UnixNano()
-	entry.UseCount++
+	entry.touch(now)
+	s.onGet(entry.Key, entry)
+	if entry.Object != nil {
+		s.mu.RUnlock()
+		atomic.AddInt64(&s.hits, 1)
+		return nil, true, ErrWrongType
+	}
+	entry.share()
+	value := entry.Value
+	s.mu.RUnlock()
 	
-	return entry.Value, true
+	atomic.AddInt64(&s.hits, 1)
+	return value, true, nil
 }
 
-func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	
+// View runs fn with the live entry for key, or nil if it does not exist,
+// while holding the shard read lock. fn must not modify the entry, nor keep
+// Value past its return, since Update may write to it in place.
+func (c *Cache) View(key string, fn func(entry *Entry) error) error {
+	s := c.shard(key)
 	now := time.Now().UnixNano()
-	var expiresAt int64
+	
+	s.mu.RLock()
+	entry, exists := s.get(key)
+	if exists && entry.ExpiresAt > 0 && now > entry.ExpiresAt {
+		s.mu.RUnlock()
+		c.expire(s, key)
+		exists = false
+	} else if !exists {
+		s.mu.RUnlock()
+	}
+	
+	if !exists {
+		atomic.AddInt64(&s.misses, 1)
+		return fn(nil)
+	}
+	defer s.mu.RUnlock()
+	
+	entry.touch(now)
+	s.onGet(entry.Key, entry)
+	atomic.AddInt64(&s.hits, 1)
+	return fn(entry)
+}
+
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) error {
+	var opts SetOptions
 	if ttl > 0 {
-		expiresAt = now + int64(ttl.Nanoseconds())
+		opts.ExpiresAt = time.Now().UnixNano() + int64(ttl.Nanoseconds())
 	}
+	_, _, err := c.SetWithOptions(key, value, opts)
+	return err
+}
+
+type SetOptions struct {
+	// ExpiresAt is the absolute expiry in Unix nanoseconds, 0 for none.
+	ExpiresAt int64
+	// KeepTTL retains the expiry of the value being replaced.
+	KeepTTL bool
+	// Get makes the write fail with ErrWrongType instead of replacing a
+	// value that is not a string, since the old value is to be returned.
+	Get bool
+	// OnlyIfMissing and OnlyIfExists make the write conditional, like the
+	// NX and XX options of SET.
+	OnlyIfMissing bool
+	OnlyIfExists  bool
+}
+
+// SetWithOptions atomically checks the write conditions in opts and stores
+// value under key if they hold. It returns the previous value and whether
+// the key existed, so callers can tell if a conditional write was skipped.
+func (c *Cache) SetWithOptions(key string, value []byte, opts SetOptions) ([]byte, bool, error) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	if existing, exists := c.entries[key]; exists {
-		c.size -= int64(len(existing.Value))
+	now := time.Now().UnixNano()
+	existing := c.lookup(s, key, now)
+	var prev []byte
+	if existing != nil {
+		if opts.Get && existing.Object != nil {
+			return nil, true, ErrWrongType
+		}
+		existing.share()
+		prev = existing.Value
+	}
+	
+	if (opts.OnlyIfMissing && existing != nil) || (opts.OnlyIfExists && existing == nil) {
+		return prev, existing != nil, nil
+	}
+	
+	if !c.reserve(s, key, (&Entry{Key: key, Value: value}).Size()) {
+		return prev, existing != nil, ErrOutOfMemory
+	}
+	
+	expiresAt := opts.ExpiresAt
+	if opts.KeepTTL && existing != nil {
+		expiresAt = existing.ExpiresAt
 	}
 	
 	entry := &Entry{
@@ -72,61 +291,364 @@ func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
 		UseCount:  1,
 	}
 	
-	c.entries[key] = entry
-	c.size += int64(len(value))
+	c.store(s, entry)
+	return prev, existing != nil, nil
+}
+
+// Update atomically reads and rewrites key. fn receives the live entry, or
+// nil if the key does not exist, and returns the entry to store or nil to
+// delete the key. It may modify the entry in place, but byte slices already
+// handed out by Get must never be written to; build a new Value or use
+// MutableValue instead.
+// Returning an error leaves the key as fn found it.
+//
+// Update is never refused for lack of memory, since it also serves writes
+// that free memory, such as pops; writes that may grow the cache call
+// CheckMemory first. Any growth caused by fn is reclaimed afterwards on a
+// best-effort basis.
+func (c *Cache) Update(key string, fn func(entry *Entry) (*Entry, error)) error {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	now := time.Now().UnixNano()
+	existing := c.lookup(s, key, now)
+	next, err := fn(existing)
+	if err != nil {
+		return err
+	}
+	
+	c.apply(s, key, existing, next, now)
+	c.reclaim(s, c.overLimit)
+	return nil
+}
+
+// CheckMemory evicts entries while the cache is over maxSize, starting from
+// the shard of key, and fails with ErrOutOfMemory if it stays over. Like
+// Redis, commands that may grow the cache run it first, so they are refused
+// once nothing can be evicted, while commands that only read or free memory
+// still run.
+func (c *Cache) CheckMemory(key string) error {
+	if c.overLimit() <= 0 {
+		return nil
+	}
+	
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !c.reclaim(s, c.overLimit) {
+		return ErrOutOfMemory
+	}
+	return nil
+}
+
+// apply stores the result of an update function for key, where existing is
+// the entry the function was given. The caller must hold s.mu.
+func (c *Cache) apply(s *shard, key string, existing, next *Entry, now int64) {
+	if next == nil {
+		if existing != nil {
+			c.remove(s, key)
+		}
+		return
+	}
+	
+	if next != existing {
+		next.Key = key
+		next.CreatedAt = now
+		next.UseCount = 1
+	}
+	next.LastUsed = now
+	c.store(s, next)
 }
 
 func (c *Cache) Delete(key string) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
-	entry, exists := c.entries[key]
-	if !exists {
+	if c.lookup(s, key, time.Now().UnixNano()) == nil {
 		return false
 	}
-	
-	delete(c.entries, key)
-	c.size -= int64(len(entry.Value))
-	return true
+	return c.remove(s, key)
 }
 
 func (c *Cache) Size() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.size
+	return atomic.LoadInt64(&c.size)
+}
+
+func (c *Cache) MaxSize() int64 {
+	return c.maxSize
 }
 
 func (c *Cache) Count() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return len(c.entries)
+	count := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		count += s.len()
+		s.mu.RUnlock()
+	}
+	return count
+}
+
+func (c *Cache) Hits() int64 {
+	var hits int64
+	for _, s := range c.shards {
+		hits += atomic.LoadInt64(&s.hits)
+	}
+	return hits
+}
+
+func (c *Cache) Misses() int64 {
+	var misses int64
+	for _, s := range c.shards {
+		misses += atomic.LoadInt64(&s.misses)
+	}
+	return misses
 }
 
 func (c *Cache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.entries = make(map[string]*Entry)
-	c.size = 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, bucket := range s.buckets {
+			for key := range bucket {
+				c.remove(s, key)
+			}
+		}
+		s.mu.Unlock()
+	}
 }
 
 func (c *Cache) Keys() []string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	
-	keys := make([]string, 0, len(c.entries))
-	for key := range c.entries {
-		keys = append(keys, key)
+	keys := make([]string, 0, c.Count())
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for _, bucket := range s.buckets {
+			for key := range bucket {
+				keys = append(keys, key)
+			}
+		}
+		s.mu.RUnlock()
 	}
 	return keys
 }
 
 func (c *Cache) GetEntries() map[string]*Entry {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	
-	entries := make(map[string]*Entry, len(c.entries))
-	for k, v := range c.entries {
-		entries[k] = v
+	entries := make(map[string]*Entry, c.Count())
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for _, bucket := range s.buckets {
+			for k, v := range bucket {
+				entries[k] = v
+			}
+		}
+		s.mu.RUnlock()
 	}
 	return entries
-}
\ No newline at end of file
+}
+
+// Scan calls fn for the live keys of the buckets from cursor on, visiting
+// whole buckets until at least count keys have been seen, and returns the
+// cursor to resume from, or 0 once every bucket has been visited. A full
+// scan reports each key present throughout it exactly once, however the
+// cache changes in between. fn runs under the shard read lock and must not
+// modify the entry.
+func (c *Cache) Scan(cursor uint64, count int, fn func(key string, entry *Entry)) uint64 {
+	total := uint64(len(c.shards)) * shardBuckets
+	now := time.Now().UnixNano()
+	seen := 0
+	for cursor < total && seen < count {
+		s := c.shards[cursor/shardBuckets]
+		s.mu.RLock()
+		// The lock is held across consecutive buckets of the same shard.
+		for {
+			for key, entry := range s.buckets[cursor%shardBuckets] {
+				seen++
+				if entry.ExpiresAt == 0 || now <= entry.ExpiresAt {
+					fn(key, entry)
+				}
+			}
+			cursor++
+			if seen >= count || cursor%shardBuckets == 0 {
+				break
+			}
+		}
+		s.mu.RUnlock()
+	}
+	
+	if cursor >= total {
+		return 0
+	}
+	return cursor
+}
+
+// ExpiredKeys returns how many keys have been removed because their TTL
+// elapsed, whether found lazily on access or by DeleteExpired.
+func (c *Cache) ExpiredKeys() int64 {
+	return atomic.LoadInt64(&c.expired)
+}
+
+// DeleteExpired actively reclaims expired keys that are never read. Like
+// Redis it samples a handful of volatile keys at a time, holding only one
+// shard lock per sample, and keeps going on a shard while a large share of
+// the sample was expired and the time budget allows. It returns the number
+// of keys removed. Each cycle starts on the shard after the last one the
+// previous cycle visited, so a tight budget still reaches every shard.
+func (c *Cache) DeleteExpired(budget time.Duration) int {
+	start := time.Now()
+	total := 0
+	
+	first := int(atomic.LoadUint32(&c.expireCursor))
+	visited := 0
+	for visited < len(c.shards) {
+		s := c.shards[(first+visited)&int(c.mask)]
+		visited++
+		for {
+			sampled, expired := c.expireSample(s)
+			total += expired
+			if expired*expireRepeatRatio <= sampled || time.Since(start) >= budget {
+				break
+			}
+		}
+		if time.Since(start) >= budget {
+			break
+		}
+	}
+	atomic.StoreUint32(&c.expireCursor, uint32((first+visited)&int(c.mask)))
+	
+	atomic.AddInt64(&c.expired, int64(total))
+	return total
+}
+
+func (c *Cache) expireSample(s *shard) (sampled, expired int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	now := time.Now().UnixNano()
+	for key, entry := range s.expires {
+		if sampled == expireSampleSize {
+			break
+		}
+		sampled++
+		if now > entry.ExpiresAt {
+			c.remove(s, key)
+			expired++
+		}
+	}
+	return sampled, expired
+}
+
+// expire removes key once a reader holding only the read lock found it
+// expired.
+func (c *Cache) expire(s *shard, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	c.lookup(s, key, time.Now().UnixNano())
+}
+
+func (c *Cache) shard(key string) *shard {
+	return c.shards[hashKey(key)&c.mask]
+}
+
+// store inserts entry into s, replacing any previous entry for its key.
+// The caller must hold s.mu.
+func (c *Cache) store(s *shard, entry *Entry) {
+	// Keys may point into a buffer the caller reuses, such as a connection's
+	// request buffer, so new keys are copied. Overwrites keep the copy
+	// already held, since assigning to a map replaces a string key too.
+	key := entry.Key
+	if existing, exists := s.get(key); exists {
+		atomic.AddInt64(&c.size, -existing.size)
+		key = existing.Key
+	} else {
+		key = strings.Clone(key)
+	}
+	entry.Key = key
+	
+	entry.size = entry.Size()
+	s.put(key, entry)
+	atomic.AddInt64(&c.size, entry.size)
+	if entry.ExpiresAt > 0 {
+		s.expires[key] = entry
+	} else {
+		delete(s.expires, key)
+	}
+	s.onSet(key, entry)
+}
+
+// remove deletes key from s. The caller must hold s.mu.
+func (c *Cache) remove(s *shard, key string) bool {
+	entry, exists := s.get(key)
+	if !exists {
+		return false
+	}
+	
+	s.del(key)
+	delete(s.expires, key)
+	atomic.AddInt64(&c.size, -entry.size)
+	s.onDelete(key)
+	return true
+}
+
+// reserve evicts entries until size bytes can be stored under key without
+// exceeding maxSize. Shards are visited round-robin so no single one is
+// drained first. The caller holds s.mu; other shards are only try-locked so
+// that two writers evicting from each other's shards cannot deadlock. It
+// reports false if not enough victims could be found.
+func (c *Cache) reserve(s *shard, key string, size int64) bool {
+	return c.reclaim(s, func() int64 { return c.excess(s, key, size) })
+}
+
+// reclaim evicts entries while excess reports bytes over the limit. Shards
+// are visited round-robin so no single one is drained first. The caller
+// holds s.mu; other shards are only try-locked so that two writers evicting
+// from each other's shards cannot deadlock. It reports false if not enough
+// victims could be found.
+func (c *Cache) reclaim(s *shard, excess func() int64) bool {
+	over := excess()
+	if over <= 0 {
+		return true
+	}
+	
+	start := int(atomic.AddUint32(&c.evictCursor, 1))
+	for i := 0; i < len(c.shards) && over > 0; i++ {
+		victim := c.shards[(start+i)%len(c.shards)]
+		if victim != s && !victim.mu.TryLock() {
+			continue
+		}
+		c.evict(victim, over)
+		if victim != s {
+			victim.mu.Unlock()
+		}
+		over = excess()
+	}
+	return over <= 0
+}
+
+func (c *Cache) evict(s *shard, excess int64) {
+	for _, key := range s.victims(excess) {
+		c.remove(s, key)
+	}
+}
+
+func (c *Cache) excess(s *shard, key string, size int64) int64 {
+	excess := c.overLimit() + size
+	if existing, exists := s.get(key); exists {
+		excess -= existing.size
+	}
+	return excess
+}
+
+func (c *Cache) overLimit() int64 {
+	return atomic.LoadInt64(&c.size) - c.maxSize
+}
+
+// hashKey is an inlined FNV-1a so that picking a shard does not allocate.
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -1,13 +1,16 @@
 package cache
 
 import (
+	"bytes"
+	"math"
+	"math/rand"
 	"strconv"
 	"testing"
 	"time"
 )
 
 func BenchmarkCacheGet(b *testing.B) {
-	cache := New(1024 * 1024 * 1024) // 1GB
+	cache := New(1024*1024*1024, nil) // 1GB
 	
 	for i := 0; i < 10000; i++ {
 		key := "key" + strconv.Itoa(i)
@@ -27,7 +30,7 @@ func BenchmarkCacheGet(b *testing.B) {
 }
 
 func BenchmarkCacheSet(b *testing.B) {
-	cache := New(1024 * 1024 * 1024) // 1GB
+	cache := New(1024*1024*1024, nil) // 1GB
 	
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
@@ -42,7 +45,7 @@ func BenchmarkCacheSet(b *testing.B) {
 }
 
 func BenchmarkCacheMixed(b *testing.B) {
-	cache := New(1024 * 1024 * 1024) // 1GB
+	cache := New(1024*1024*1024, nil) // 1GB
 	
 	for i := 0; i < 1000; i++ {
 		key := "key" + strconv.Itoa(i)
@@ -68,7 +71,7 @@ func BenchmarkCacheMixed(b *testing.B) {
 }
 
 func BenchmarkCacheSetWithTTL(b *testing.B) {
-	cache := New(1024 * 1024 * 1024) // 1GB
+	cache := New(1024*1024*1024, nil) // 1GB
 	
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
@@ -83,7 +86,7 @@ func BenchmarkCacheSetWithTTL(b *testing.B) {
 }
 
 func TestCacheBasicOperations(t *testing.T) {
-	cache := New(1024)
+	cache := New(1024, nil)
 	
 	cache.Set("key1", []byte("value1"), 0)
 	value, exists := cache.Get("key1")
@@ -103,7 +106,7 @@ func TestCacheBasicOperations(t *testing.T) {
 }
 
 func TestCacheTTL(t *testing.T) {
-	cache := New(1024)
+	cache := New(1024, nil)
 	
 	cache.Set("expiring", []byte("value"), 100*time.Millisecond)
 	
@@ -121,7 +124,7 @@ func TestCacheTTL(t *testing.T) {
 }
 
 func TestCacheConcurrency(t *testing.T) {
-	cache := New(1024 * 1024)
+	cache := New(16*1024*1024, nil)
 	
 	done := make(chan bool, 100)
 	
@@ -145,4 +148,780 @@ func TestCacheConcurrency(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		<-done
 	}
-}
\ No newline at end of file
+}
+
+func TestCacheDeleteExpired(t *testing.T) {
+	cache := New(1024*1024, nil)
+	
+	for i := 0; i < 100; i++ {
+		cache.Set("expiring"+strconv.Itoa(i), []byte("value"), 10*time.Millisecond)
+	}
+	cache.Set("persistent", []byte("value"), 0)
+	
+	time.Sleep(20 * time.Millisecond)
+	
+	removed := cache.DeleteExpired(time.Second)
+	if removed != 100 {
+		t.Errorf("Expected 100 expired keys removed, got %d", removed)
+	}
+	if cache.Count() != 1 {
+		t.Errorf("Expected only the persistent key to remain, got %d", cache.Count())
+	}
+	if want := int64(len("persistent") + len("value") + entryOverhead); cache.Size() != want {
+		t.Errorf("Expected size %d, got %d", want, cache.Size())
+	}
+	if cache.ExpiredKeys() != 100 {
+		t.Errorf("Expected 100 expired keys reported, got %d", cache.ExpiredKeys())
+	}
+}
+
+func TestCacheExpireConditions(t *testing.T) {
+	cache := New(1024, nil)
+	cache.Set("key", []byte("value"), 0)
+	
+	later := time.Now().Add(time.Hour).UnixNano()
+	if cache.Expire("key", later, ExpireXX) {
+		t.Error("XX should not apply to a key without expiry")
+	}
+	if cache.Expire("key", later, ExpireGT) {
+		t.Error("GT should not apply to a key without expiry")
+	}
+	if !cache.Expire("key", later, ExpireNX) {
+		t.Error("NX should apply to a key without expiry")
+	}
+	if cache.Expire("key", later-1, ExpireGT) {
+		t.Error("GT should reject an earlier expiry")
+	}
+	if !cache.Expire("key", later-1, ExpireLT) {
+		t.Error("LT should accept an earlier expiry")
+	}
+	
+	if expiresAt, exists := cache.ExpiresAt("key"); !exists || expiresAt != later-1 {
+		t.Errorf("Expected expiry %d, got %d", later-1, expiresAt)
+	}
+	if !cache.Persist("key") {
+		t.Error("Persist should remove the expiry")
+	}
+	if expiresAt, _ := cache.ExpiresAt("key"); expiresAt != 0 {
+		t.Errorf("Expected no expiry after Persist, got %d", expiresAt)
+	}
+	
+	if !cache.Expire("key", time.Now().Add(-time.Second).UnixNano(), 0) {
+		t.Error("Expire in the past should succeed")
+	}
+	if _, exists := cache.Get("key"); exists {
+		t.Error("Expire in the past should delete the key")
+	}
+}
+
+func TestCacheSetWithOptions(t *testing.T) {
+	cache := New(1024, nil)
+	
+	if _, existed, _ := cache.SetWithOptions("lock", []byte("a"), SetOptions{OnlyIfMissing: true}); existed {
+		t.Error("Key should not have existed")
+	}
+	prev, existed, _ := cache.SetWithOptions("lock", []byte("b"), SetOptions{OnlyIfMissing: true})
+	if !existed || string(prev) != "a" {
+		t.Errorf("Expected existing value a, got %q", prev)
+	}
+	if value, _ := cache.Get("lock"); string(value) != "a" {
+		t.Errorf("NX must not overwrite, got %q", value)
+	}
+	
+	cache.SetWithOptions("missing", []byte("x"), SetOptions{OnlyIfExists: true})
+	if _, exists := cache.Get("missing"); exists {
+		t.Error("XX must not create a key")
+	}
+	
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	cache.SetWithOptions("lock", []byte("c"), SetOptions{ExpiresAt: expiresAt})
+	cache.SetWithOptions("lock", []byte("d"), SetOptions{KeepTTL: true})
+	if got, _ := cache.ExpiresAt("lock"); got != expiresAt {
+		t.Errorf("KEEPTTL should retain expiry %d, got %d", expiresAt, got)
+	}
+}
+
+func TestCacheDeleteExpiredRotatesShards(t *testing.T) {
+	cache := NewSharded(1024*1024, 4, nil)
+	
+	perShard := make(map[*shard]int)
+	for i := 0; len(perShard) < 4 || i < 100; i++ {
+		key := "expiring" + strconv.Itoa(i)
+		if s := cache.shard(key); perShard[s] < 5 {
+			perShard[s]++
+			cache.Set(key, []byte("value"), time.Millisecond)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	
+	// A zero budget stops each cycle after its first shard, so only moving
+	// on between cycles reaches them all.
+	for i := 0; i < 4; i++ {
+		if removed := cache.DeleteExpired(0); removed != 5 {
+			t.Errorf("Cycle %d: expected the 5 keys of one shard removed, got %d", i, removed)
+		}
+	}
+	if cache.Count() != 0 {
+		t.Errorf("Expected every shard visited, %d keys left", cache.Count())
+	}
+}
+
+func TestCacheUpdateIsAtomic(t *testing.T) {
+	cache := New(1024, nil)
+	
+	done := make(chan bool, 50)
+	for i := 0; i < 50; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				cache.Update("counter", func(entry *Entry) (*Entry, error) {
+					n := 0
+					if entry != nil {
+						n, _ = strconv.Atoi(string(entry.Value))
+					}
+					return &Entry{Value: []byte(strconv.Itoa(n + 1))}, nil
+				})
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		<-done
+	}
+	
+	value, _ := cache.Get("counter")
+	if string(value) != "5000" {
+		t.Errorf("Expected 5000, got %s", value)
+	}
+	if want := int64(len("counter") + len("5000") + entryOverhead); cache.Size() != want {
+		t.Errorf("Expected size %d, got %d", want, cache.Size())
+	}
+}
+
+func TestCacheMultiKeyOperations(t *testing.T) {
+	cache := New(1024, nil)
+	
+	keys := []string{"a", "b", "c"}
+	values := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+	if !cache.SetMulti(keys, values, false) {
+		t.Fatal("SetMulti failed")
+	}
+	
+	if ok := cache.SetMulti([]string{"c", "d"}, [][]byte{[]byte("x"), []byte("y")}, true); ok {
+		t.Error("SetMulti with onlyIfNoneExist must fail when a key exists")
+	}
+	
+	got, found := cache.GetMulti([]string{"a", "d", "c"})
+	if !found[0] || string(got[0]) != "1" || found[1] || !found[2] || string(got[2]) != "3" {
+		t.Errorf("Unexpected GetMulti result: %q %v", got, found)
+	}
+}
+
+func TestCacheHashSizeAccounting(t *testing.T) {
+	cache := New(1024, nil)
+	
+	cache.Update("user", func(entry *Entry) (*Entry, error) {
+		hash := NewHash()
+		hash.Set("name", []byte("alice"))
+		hash.Set("age", []byte("30"))
+		return &Entry{Object: hash}, nil
+	})
+	if want := int64(len("user") + 14 + entryOverhead); cache.Size() != want {
+		t.Errorf("Expected size %d, got %d", want, cache.Size())
+	}
+	
+	cache.Update("user", func(entry *Entry) (*Entry, error) {
+		hash := entry.Object.(*Hash)
+		hash.Set("name", []byte("bob"))
+		hash.Delete("age")
+		return entry, nil
+	})
+	if want := int64(len("user") + 7 + entryOverhead); cache.Size() != want {
+		t.Errorf("Expected size %d after in-place update, got %d", want, cache.Size())
+	}
+	
+	if _, exists := cache.Get("user"); exists {
+		t.Error("Get must not return non-string values")
+	}
+	cache.Delete("user")
+	if cache.Size() != 0 {
+		t.Errorf("Expected size 0, got %d", cache.Size())
+	}
+}
+
+func TestCacheHashScan(t *testing.T) {
+	hash := NewHash()
+	for i := 0; i < 1000; i++ {
+		hash.Set("stable:"+strconv.Itoa(i), []byte("x"))
+	}
+	
+	// Fields churning during the scan may or may not be reported, but those
+	// present throughout must come back at least once.
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		cursor = hash.Scan(cursor, 10, func(field string, value []byte) {
+			seen[field]++
+		})
+		calls++
+		for i := 0; i < 20; i++ {
+			field := "churn:" + strconv.Itoa(calls*20+i)
+			hash.Set(field, []byte("y"))
+			if i%2 == 0 {
+				hash.Delete(field)
+			}
+		}
+		hash.Delete("churn:" + strconv.Itoa(calls*10+1))
+		if cursor == 0 {
+			break
+		}
+	}
+	
+	for i := 0; i < 1000; i++ {
+		if seen["stable:"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("Expected stable:%d to be reported", i)
+		}
+	}
+	if calls < 100 {
+		t.Errorf("Expected the scan to honor count, finished in %d calls", calls)
+	}
+}
+
+func TestCacheMutableValue(t *testing.T) {
+	cache := New(1024, nil)
+	cache.Set("bits", []byte("ab"), 0)
+	
+	before, _ := cache.Get("bits")
+	cache.Update("bits", func(entry *Entry) (*Entry, error) {
+		value := entry.MutableValue(4)
+		value[0], value[3] = 'x', 'y'
+		return entry, nil
+	})
+	if string(before) != "ab" {
+		t.Errorf("Expected a value returned by Get to stay %q, got %q", "ab", before)
+	}
+	
+	after, _ := cache.Get("bits")
+	if string(after) != "xb\x00y" {
+		t.Errorf("Expected %q, got %q", "xb\x00y", after)
+	}
+	if want := int64(len("bits") + 4 + entryOverhead); cache.Size() != want {
+		t.Errorf("Expected size %d after growth, got %d", want, cache.Size())
+	}
+	
+	var inPlace bool
+	cache.Update("bits", func(entry *Entry) (*Entry, error) {
+		entry.MutableValue(0)[1] = 'z'
+		return entry, nil
+	})
+	cache.Update("bits", func(entry *Entry) (*Entry, error) {
+		first := &entry.MutableValue(0)[0]
+		inPlace = first == &entry.MutableValue(0)[0]
+		return entry, nil
+	})
+	if !inPlace {
+		t.Error("Expected an unshared value to be written in place")
+	}
+	if string(after) != "xb\x00y" {
+		t.Errorf("Expected the value returned by Get to stay %q, got %q", "xb\x00y", after)
+	}
+}
+
+func TestCacheListOperations(t *testing.T) {
+	list := NewList()
+	for i := 0; i < 300; i++ {
+		list.PushBack([]byte{byte(i)})
+	}
+	list.PushFront([]byte("head"))
+	
+	if list.Len() != 301 || list.Size() != 304 {
+		t.Errorf("Expected 301 elements of 304 bytes, got %d of %d", list.Len(), list.Size())
+	}
+	if value, _ := list.Index(0); string(value) != "head" {
+		t.Errorf("Expected head at index 0, got %q", value)
+	}
+	if value, _ := list.Index(-1); value[0] != byte(299%256) {
+		t.Errorf("Expected 299 at index -1, got %v", value)
+	}
+	if value, _ := list.Index(200); value[0] != byte(199) {
+		t.Errorf("Expected 199 at index 200, got %v", value)
+	}
+	
+	list.Trim(1, 150)
+	if list.Len() != 150 || list.Size() != 150 {
+		t.Errorf("Expected 150 elements after trim, got %d of %d bytes", list.Len(), list.Size())
+	}
+	
+	var got []byte
+	list.Range(10, 12, func(value []byte) bool {
+		got = append(got, value...)
+		return true
+	})
+	if string(got) != string([]byte{10, 11, 12}) {
+		t.Errorf("Expected range 10..12, got %v", got)
+	}
+	
+	for i := 0; i < 150; i++ {
+		value, ok := list.PopFront()
+		if !ok || value[0] != byte(i) {
+			t.Fatalf("Expected to pop %d, got %v", i, value)
+		}
+	}
+	if _, ok := list.PopBack(); ok || list.Size() != 0 {
+		t.Error("Expected list to be empty")
+	}
+}
+
+func TestCacheScanStable(t *testing.T) {
+	cache := NewSharded(1024*1024*1024, 4, nil)
+	for i := 0; i < 5000; i++ {
+		cache.Set("stable:"+strconv.Itoa(i), []byte("x"), 0)
+	}
+	
+	// Keys churning during the scan may or may not be reported, but those
+	// present throughout must each come back exactly once.
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		cursor = cache.Scan(cursor, 50, func(key string, entry *Entry) {
+			seen[key]++
+		})
+		calls++
+		for i := 0; i < 200; i++ {
+			key := "churn:" + strconv.Itoa(calls*200+i)
+			cache.Set(key, []byte("y"), 0)
+			if i%2 == 0 {
+				cache.Delete(key)
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	
+	for i := 0; i < 5000; i++ {
+		if n := seen["stable:"+strconv.Itoa(i)]; n != 1 {
+			t.Fatalf("Expected stable:%d once, seen %d times", i, n)
+		}
+	}
+	if calls < 50 {
+		t.Errorf("Expected the scan to be incremental, finished in %d calls", calls)
+	}
+	
+	cache.Set("volatile", []byte("z"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	cursor = cache.Scan(0, 1<<20, func(key string, entry *Entry) {
+		if key == "volatile" {
+			t.Error("Expected expired keys to be skipped")
+		}
+	})
+	if cursor != 0 {
+		t.Errorf("Expected a full scan to finish, got cursor %d", cursor)
+	}
+}
+
+func TestCacheSetEncoding(t *testing.T) {
+	set := NewSet()
+	for i := 0; i < intsetMaxEntries; i++ {
+		set.Add(strconv.Itoa(intsetMaxEntries - i))
+	}
+	if set.index != nil {
+		t.Fatal("Expected a set of small integers to stay an intset")
+	}
+	if set.Size() != 8*intsetMaxEntries {
+		t.Errorf("Expected size %d, got %d", 8*intsetMaxEntries, set.Size())
+	}
+	if set.Add("1") || !set.Contains("512") || set.Contains("01") {
+		t.Error("Intset membership is wrong")
+	}
+	
+	prev := 0
+	set.Range(func(member string) bool {
+		n, _ := strconv.Atoi(member)
+		if n <= prev {
+			t.Fatalf("Expected ascending members, got %d after %d", n, prev)
+		}
+		prev = n
+		return true
+	})
+	
+	set.Add("member")
+	if set.index == nil {
+		t.Fatal("Expected a non-integer member to convert the set")
+	}
+	if set.Len() != intsetMaxEntries+1 || !set.Contains("512") {
+		t.Errorf("Conversion lost members, %d left", set.Len())
+	}
+	
+	var expected int64
+	set.Range(func(member string) bool {
+		expected += int64(len(member))
+		return true
+	})
+	if set.Size() != expected {
+		t.Errorf("Expected size %d after conversion, got %d", expected, set.Size())
+	}
+	
+	for set.Len() > 0 {
+		set.Pop()
+	}
+	if set.Size() != 0 {
+		t.Errorf("Expected size 0 once emptied, got %d", set.Size())
+	}
+}
+
+func TestCacheSetScan(t *testing.T) {
+	set := NewSet()
+	for i := 0; i < 100; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	n := 0
+	if cursor := set.Scan(0, 10, func(member string) { n++ }); cursor != 0 || n != 100 {
+		t.Errorf("Expected an intset to be scanned whole, got %d members and cursor %d", n, cursor)
+	}
+	
+	for i := 0; i < 1000; i++ {
+		set.Add("stable:" + strconv.Itoa(i))
+	}
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		cursor = set.Scan(cursor, 10, func(member string) {
+			seen[member]++
+		})
+		calls++
+		for i := 0; i < 20; i++ {
+			member := "churn:" + strconv.Itoa(calls*20+i)
+			set.Add(member)
+			if i%2 == 0 {
+				set.Remove(member)
+			}
+		}
+		set.Remove("churn:" + strconv.Itoa(calls*10+1))
+		set.Pop()
+		if cursor == 0 {
+			break
+		}
+	}
+	
+	for i := 0; i < 1000; i++ {
+		member := "stable:" + strconv.Itoa(i)
+		if set.Contains(member) && seen[member] == 0 {
+			t.Fatalf("Expected %s to be reported", member)
+		}
+	}
+	if calls < 100 {
+		t.Errorf("Expected the scan to honor count, finished in %d calls", calls)
+	}
+}
+
+func TestCacheSortedSetOrdering(t *testing.T) {
+	zset := NewSortedSet()
+	scores := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		member := "m" + strconv.Itoa(i%500)
+		score := float64((i * 7919) % 101)
+		if i%3 == 0 {
+			zset.Remove(member)
+			delete(scores, member)
+			continue
+		}
+		zset.Add(member, score)
+		scores[member] = score
+	}
+	
+	if zset.Len() != len(scores) {
+		t.Fatalf("Expected %d members, got %d", len(scores), zset.Len())
+	}
+	
+	var expectedSize int64
+	for member := range scores {
+		expectedSize += int64(len(member)) + 8
+	}
+	if zset.Size() != expectedSize {
+		t.Errorf("Expected size %d, got %d", expectedSize, zset.Size())
+	}
+	
+	rank := 0
+	var prevScore float64
+	prevMember := ""
+	zset.RangeByRank(0, zset.Len()-1, false, func(member string, score float64) bool {
+		if score != scores[member] {
+			t.Fatalf("Member %s has score %v, expected %v", member, score, scores[member])
+		}
+		if rank > 0 && (score < prevScore || (score == prevScore && member <= prevMember)) {
+			t.Fatalf("Members out of order at rank %d", rank)
+		}
+		if got, _ := zset.Rank(member); got != rank {
+			t.Fatalf("Expected rank %d for %s, got %d", rank, member, got)
+		}
+		rank++
+		prevScore, prevMember = score, member
+		return true
+	})
+	if rank != len(scores) {
+		t.Errorf("Expected to visit %d members, visited %d", len(scores), rank)
+	}
+	
+	count := 0
+	zset.RangeByScore(ScoreBound{Score: 10}, ScoreBound{Score: 20, Exclusive: true}, true, func(member string, score float64) bool {
+		if score < 10 || score >= 20 {
+			t.Fatalf("Score %v outside [10, 20)", score)
+		}
+		count++
+		return true
+	})
+	expected := 0
+	for _, score := range scores {
+		if score >= 10 && score < 20 {
+			expected++
+		}
+	}
+	if count != expected {
+		t.Errorf("Expected %d members in [10, 20), got %d", expected, count)
+	}
+}
+
+func TestCacheStreamOperations(t *testing.T) {
+	stream := NewStream()
+	for i := 1; i <= 10; i++ {
+		if !stream.Add(StreamID{Ms: uint64(i)}, []string{"field", "value"}) {
+			t.Fatalf("Expected entry %d to be added", i)
+		}
+	}
+	if stream.Add(StreamID{Ms: 5}, []string{"field", "value"}) {
+		t.Error("Add must reject IDs not greater than the last one")
+	}
+	if next := stream.NextID(3); next != (StreamID{Ms: 10, Seq: 1}) {
+		t.Errorf("Expected next ID 10-1, got %s", next)
+	}
+	if stream.Size() != 10*26 {
+		t.Errorf("Expected size %d, got %d", 10*26, stream.Size())
+	}
+	
+	var ids []uint64
+	stream.Range(StreamID{Ms: 3}, StreamID{Ms: 6}, true, func(entry StreamEntry) bool {
+		ids = append(ids, entry.ID.Ms)
+		return true
+	})
+	if len(ids) != 4 || ids[0] != 6 || ids[3] != 3 {
+		t.Errorf("Expected IDs 6 to 3, got %v", ids)
+	}
+	
+	if removed := stream.TrimMaxLen(7, 0); removed != 3 {
+		t.Errorf("Expected 3 entries trimmed, got %d", removed)
+	}
+	if removed := stream.TrimMinID(StreamID{Ms: 9}, 1); removed != 1 {
+		t.Errorf("Expected LIMIT to cap trimming at 1, got %d", removed)
+	}
+	if stream.Len() != 6 || stream.Size() != 6*26 {
+		t.Errorf("Expected 6 entries of %d bytes, got %d of %d", 6*26, stream.Len(), stream.Size())
+	}
+	if _, exists := stream.Get(StreamID{Ms: 4}); exists {
+		t.Error("Trimmed entry should be gone")
+	}
+	
+	stream.CreateGroup("workers", StreamID{})
+	group := stream.Group("workers")
+	group.Deliver(StreamID{Ms: 5}, "alice", 100)
+	group.Deliver(StreamID{Ms: 6}, "bob", 100)
+	if removed := group.DeleteConsumer("alice"); removed != 0 {
+		t.Errorf("Expected no pending entries for an unknown consumer, got %d", removed)
+	}
+	group.Consumer("bob", 100)
+	if removed := group.DeleteConsumer("bob"); removed != 1 || group.PendingLen() != 1 {
+		t.Errorf("Expected bob's entry removed, got %d with %d left", removed, group.PendingLen())
+	}
+	if !group.Ack(StreamID{Ms: 5}) || group.PendingLen() != 0 {
+		t.Error("Expected the remaining entry to be acknowledged")
+	}
+	
+	stream.DestroyGroup("workers")
+	if stream.Size() != 6*26 {
+		t.Errorf("Expected group bookkeeping to be released, size %d", stream.Size())
+	}
+}
+
+func TestCacheHyperLogLogErrorBounds(t *testing.T) {
+	entry := NewHyperLogLog()
+	hll, err := AsHyperLogLog(entry)
+	if err != nil {
+		t.Fatalf("Expected a valid HyperLogLog, got %v", err)
+	}
+	
+	// The standard error with 16384 registers is 0.81%; allow about three
+	// standard deviations, plus a little slack for tiny cardinalities.
+	added := 0
+	for _, n := range []int{10, 100, 1000, 10000, 100000, 1000000} {
+		for ; added < n; added++ {
+			hll.Add([]byte("element:" + strconv.Itoa(added)))
+		}
+		count := hll.Count()
+		if diff := float64(count) - float64(n); diff > 0.025*float64(n)+1 || -diff > 0.025*float64(n)+1 {
+			t.Errorf("Estimate %d for %d elements is outside the error bound", count, n)
+		}
+		if n == 100 && entry.Value[4] != hllSparse {
+			t.Error("Expected a small HyperLogLog to stay sparse")
+		}
+	}
+	if len(entry.Value) != hllDenseSize {
+		t.Errorf("Expected the dense encoding at %d bytes, got %d", hllDenseSize, len(entry.Value))
+	}
+	
+	copied, err := AsHyperLogLog(&Entry{Value: append([]byte(nil), entry.Value...)})
+	if err != nil || copied.Count() != hll.Count() {
+		t.Error("Expected a copied value to round-trip with the same estimate")
+	}
+	if hll.Add([]byte("element:0")) {
+		t.Error("Re-adding an element must not change any register")
+	}
+	
+	other := NewHyperLogLog()
+	half, _ := AsHyperLogLog(other)
+	for i := 500000; i < 1500000; i++ {
+		half.Add([]byte("element:" + strconv.Itoa(i)))
+	}
+	union := CountUnion(hll, half)
+	if union < 1450000 || union > 1550000 {
+		t.Errorf("Expected a union estimate near 1500000, got %d", union)
+	}
+	half.Merge(hll)
+	if half.Count() != union {
+		t.Errorf("Expected the merged estimate %d to match the union, got %d", union, half.Count())
+	}
+	
+	if _, err := AsHyperLogLog(&Entry{Value: []byte("HYLL not really")}); err != ErrInvalidHyperLogLog {
+		t.Errorf("Expected ErrInvalidHyperLogLog for a corrupt value, got %v", err)
+	}
+	
+	// Registers past what any element can produce are still well formed
+	// and must not break the estimate.
+	saturated := bytes.Repeat([]byte{0xff}, hllDenseSize)
+	copy(saturated, hllHeader(hllDense))
+	saturated[15] = 0x80
+	hll, err = AsHyperLogLog(&Entry{Value: saturated})
+	if err != nil {
+		t.Fatalf("Expected a dense value with saturated registers to be valid, got %v", err)
+	}
+	hll.Count()
+}
+
+func TestCacheGeoSearch(t *testing.T) {
+	hash, ok := GeoEncode(13.361389, 38.115556)
+	if !ok || hash != 3479099956230698 {
+		t.Errorf("Expected Palermo to encode to 3479099956230698, got %d", hash)
+	}
+	lon, lat := GeoDecode(hash)
+	if math.Abs(lon-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Errorf("Decoded position %f,%f is too far from the original", lon, lat)
+	}
+	if _, ok := GeoEncode(0, 86); ok {
+		t.Error("Expected latitudes beyond the Mercator limit to be rejected")
+	}
+	
+	// Points scattered around a center must be found exactly as a full scan
+	// finds them, including those in neighbouring cells.
+	zset := NewSortedSet()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		hash, _ := GeoEncode(2+rng.Float64()*2-1, 48+rng.Float64()*2-1)
+		zset.Add(strconv.Itoa(i), float64(hash))
+	}
+	
+	for _, shape := range []GeoShape{
+		{Lon: 2, Lat: 48, Radius: 25000},
+		{Lon: 2.3, Lat: 48.7, Radius: 60000},
+		{Lon: 1.8, Lat: 47.6, Width: 40000, Height: 10000},
+	} {
+		expected := make(map[string]bool)
+		zset.RangeByRank(0, zset.Len()-1, false, func(member string, score float64) bool {
+			lon, lat := GeoDecode(uint64(score))
+			if _, ok := shape.distance(lon, lat); ok {
+				expected[member] = true
+			}
+			return true
+		})
+		
+		found := 0
+		zset.GeoSearch(shape, func(member string, score, distance float64) bool {
+			if !expected[member] {
+				t.Errorf("Member %s is outside %+v", member, shape)
+			}
+			found++
+			return true
+		})
+		if found != len(expected) || found == 0 {
+			t.Errorf("Expected %d members within %+v, found %d", len(expected), shape, found)
+		}
+	}
+}
+
+func TestCacheJSONPaths(t *testing.T) {
+	root, err := ParseJSON(`{"a":1,"b":[1,2.5,"x"],"c":{"a":{"a":true}}}`)
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	doc := NewJSON(root)
+	size := doc.Size()
+	
+	path := func(s string) *JSONPath {
+		p, err := ParseJSONPath(s)
+		if err != nil {
+			t.Fatalf("Failed to parse path %q: %v", s, err)
+		}
+		return p
+	}
+	get := func(s string) string {
+		return MarshalJSON(JSONList(doc.Get(path(s))), JSONFormat{})
+	}
+	
+	tests := map[string]string{
+		"$":         `[{"a":1,"b":[1,2.5,"x"],"c":{"a":{"a":true}}}]`,
+		"$..a":      `[1,{"a":true},true]`,
+		"$.b[-1]":   `["x"]`,
+		"$.b[*]":    `[1,2.5,"x"]`,
+		"$['c'].a":  `[{"a":true}]`,
+		".c.a.a":    `[true]`,
+		"$.missing": `[]`,
+	}
+	for p, expected := range tests {
+		if got := get(p); got != expected {
+			t.Errorf("Path %s: expected %s, got %s", p, expected, got)
+		}
+	}
+	for _, p := range []string{"$.", "$[", "$.b[x]"} {
+		if _, err := ParseJSONPath(p); err != ErrInvalidJSONPath {
+			t.Errorf("Expected path %q to be rejected, got %v", p, err)
+		}
+	}
+	
+	// Values set at several locations must not share state.
+	value, _ := ParseJSON(`[0]`)
+	if !doc.Set(path("$..a"), value, false, false) {
+		t.Fatal("Expected set to succeed")
+	}
+	doc.ArrAppend(path("$.a"), []any{int64(1)})
+	if got := get("$.a"); got != `[[0,1]]` {
+		t.Errorf("Expected appended array, got %s", got)
+	}
+	if got := get("$.c.a"); got != `[[0]]` {
+		t.Errorf("Expected untouched clone, got %s", got)
+	}
+	if doc.Set(path("$.a"), value, true, false) || doc.Set(path("$.new"), value, false, true) {
+		t.Error("Expected NX and XX to be honoured")
+	}
+	
+	results, err := doc.IncrBy(path("$.b[*]"), int64(2))
+	if err != nil {
+		t.Fatalf("Failed to increment: %v", err)
+	}
+	if got := MarshalJSON(JSONList(results), JSONFormat{}); got != `[3,4.5,null]` {
+		t.Errorf("Expected incremented numbers, got %s", got)
+	}
+	
+	if n := doc.Delete(path("$..a")); n != 2 {
+		t.Errorf("Expected 2 deletions, got %d", n)
+	}
+	if doc.Size() >= size {
+		t.Errorf("Expected size below %d after deletions, got %d", size, doc.Size())
+	}
+}
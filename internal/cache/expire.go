@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ExpireCondition is a set of flags restricting when Expire applies; the
+// zero value applies unconditionally.
+type ExpireCondition int
+
+const (
+	// ExpireNX only sets an expiry on keys that have none.
+	ExpireNX ExpireCondition = 1 << iota
+	// ExpireXX only sets an expiry on keys that already have one.
+	ExpireXX
+	// ExpireGT only sets an expiry later than the current one.
+	ExpireGT
+	// ExpireLT only sets an expiry earlier than the current one.
+	ExpireLT
+)
+
+// Expire sets the absolute expiry of key in Unix nanoseconds if cond holds.
+// An expiry that is already in the past deletes the key. It reports whether
+// the key exists and the expiry was applied.
+func (c *Cache) Expire(key string, expiresAt int64, cond ExpireCondition) bool {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	now := time.Now().UnixNano()
+	entry := c.lookup(s, key, now)
+	if entry == nil {
+		return false
+	}
+	
+	if cond&ExpireNX != 0 && entry.ExpiresAt > 0 {
+		return false
+	}
+	if cond&ExpireXX != 0 && entry.ExpiresAt == 0 {
+		return false
+	}
+	// A key without expiry counts as living forever: nothing is greater and
+	// everything is less.
+	if cond&ExpireGT != 0 && (entry.ExpiresAt == 0 || expiresAt <= entry.ExpiresAt) {
+		return false
+	}
+	if cond&ExpireLT != 0 && entry.ExpiresAt > 0 && expiresAt >= entry.ExpiresAt {
+		return false
+	}
+	
+	if expiresAt <= now {
+		c.remove(s, key)
+		return true
+	}
+	
+	c.setExpiry(s, entry, expiresAt)
+	return true
+}
+
+// ExpiresAt returns the expiry of key in Unix nanoseconds, or 0 if it has
+// none. The second result is false if the key does not exist.
+func (c *Cache) ExpiresAt(key string) (int64, bool) {
+	s := c.shard(key)
+	now := time.Now().UnixNano()
+	
+	s.mu.RLock()
+	entry, exists := s.get(key)
+	if !exists {
+		s.mu.RUnlock()
+		return 0, false
+	}
+	expiresAt := entry.ExpiresAt
+	s.mu.RUnlock()
+	
+	if expiresAt > 0 && now > expiresAt {
+		c.expire(s, key)
+		return 0, false
+	}
+	return expiresAt, true
+}
+
+// Persist removes the expiry of key. It reports whether an expiry was
+// removed.
+func (c *Cache) Persist(key string) bool {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	entry := c.lookup(s, key, time.Now().UnixNano())
+	if entry == nil || entry.ExpiresAt == 0 {
+		return false
+	}
+	
+	c.setExpiry(s, entry, 0)
+	return true
+}
+
+// lookup returns the live entry for key, removing it first if it has
+// expired. The caller must hold s.mu for writing.
+func (c *Cache) lookup(s *shard, key string, now int64) *Entry {
+	entry, exists := s.get(key)
+	if !exists {
+		return nil
+	}
+	if entry.ExpiresAt > 0 && now > entry.ExpiresAt {
+		c.remove(s, key)
+		atomic.AddInt64(&c.expired, 1)
+		return nil
+	}
+	return entry
+}
+
+// setExpiry changes the expiry of an entry already stored in s. The caller
+// must hold s.mu.
+func (c *Cache) setExpiry(s *shard, entry *Entry, expiresAt int64) {
+	entry.ExpiresAt = expiresAt
+	if expiresAt > 0 {
+		s.expires[entry.Key] = entry
+	} else {
+		delete(s.expires, entry.Key)
+	}
+	s.onSet(entry.Key, entry)
+}
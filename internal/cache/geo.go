@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"math"
+)
+
+// Positions are stored in sorted sets as 52-bit geohashes, interleaving 26
+// bits of latitude and longitude, the same encoding Redis uses so that
+// nearby points get nearby scores.
+const (
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+	
+	geoStepMax      = 26
+	earthRadius     = 6372797.560856
+	mercatorMaxSpan = 20037726.37
+)
+
+// GeoShape is a search area around a center: a circle of Radius meters or,
+// when Radius is zero, a box of Width by Height meters.
+type GeoShape struct {
+	Lon, Lat      float64
+	Radius        float64
+	Width, Height float64
+}
+
+// GeoEncode returns the geohash of a position, or false if it lies outside
+// the latitudes and longitudes the encoding can represent.
+func GeoEncode(lon, lat float64) (uint64, bool) {
+	if lon < GeoLonMin || lon > GeoLonMax || lat < GeoLatMin || lat > GeoLatMax {
+		return 0, false
+	}
+	return geoEncode(lon, lat, geoStepMax), true
+}
+
+func geoEncode(lon, lat float64, step uint) uint64 {
+	cells := float64(uint64(1) << step)
+	latCell := uint32(min((lat-GeoLatMin)/(GeoLatMax-GeoLatMin)*cells, cells-1))
+	lonCell := uint32(min((lon-GeoLonMin)/(GeoLonMax-GeoLonMin)*cells, cells-1))
+	return interleave(latCell, lonCell)
+}
+
+// GeoDecode returns the center of the cell a geohash describes.
+func GeoDecode(hash uint64) (lon, lat float64) {
+	cells := float64(uint64(1) << geoStepMax)
+	latCell, lonCell := float64(squash(hash)), float64(squash(hash>>1))
+	// Computed as the midpoint of the cell's edges to match Redis' output
+	// digit for digit.
+	latLow := GeoLatMin + latCell/cells*(GeoLatMax-GeoLatMin)
+	latHigh := GeoLatMin + (latCell+1)/cells*(GeoLatMax-GeoLatMin)
+	lonLow := GeoLonMin + lonCell/cells*(GeoLonMax-GeoLonMin)
+	lonHigh := GeoLonMin + (lonCell+1)/cells*(GeoLonMax-GeoLonMin)
+	lon, lat = (lonLow+lonHigh)/2, (latLow+latHigh)/2
+	return math.Max(GeoLonMin, math.Min(lon, GeoLonMax)), math.Max(GeoLatMin, math.Min(lat, GeoLatMax))
+}
+
+// GeoDistance returns the haversine distance in meters between two points.
+func GeoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin(radians(lon2-lon1) / 2)
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	u := math.Sin(radians(lat2-lat1) / 2)
+	a := u*u + math.Cos(radians(lat1))*math.Cos(radians(lat2))*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(radians(lat2)-radians(lat1))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// distance returns how far lon, lat is from the center of s, and whether it
+// lies within s.
+func (s GeoShape) distance(lon, lat float64) (float64, bool) {
+	if s.Radius > 0 {
+		d := GeoDistance(s.Lon, s.Lat, lon, lat)
+		return d, d <= s.Radius
+	}
+	if geoLatDistance(s.Lat, lat) > s.Height/2 || GeoDistance(s.Lon, lat, lon, lat) > s.Width/2 {
+		return 0, false
+	}
+	return GeoDistance(s.Lon, s.Lat, lon, lat), true
+}
+
+// GeoSearch calls fn for each member of z within shape, with its distance
+// from the center in meters, until fn returns false. Members come out cell
+// by cell, not in distance order.
+func (z *SortedSet) GeoSearch(shape GeoShape, fn func(member string, score, distance float64) bool) {
+	step := geoSearchStep(shape)
+	shift := 2 * (geoStepMax - step)
+	latCell, lonCell := geoCell(shape.Lon, shape.Lat, step)
+	cells := int64(1) << step
+	
+	// The center cell and its eight neighbours cover the whole shape.
+	seen := make(map[uint64]bool, 9)
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		for dLon := int64(-1); dLon <= 1; dLon++ {
+			lat, lon := latCell+dLat, (lonCell+dLon+cells)%cells
+			if lat < 0 || lat >= cells {
+				continue
+			}
+			hash := interleave(uint32(lat), uint32(lon))
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			
+			done := false
+			from := ScoreBound{Score: float64(hash << shift)}
+			to := ScoreBound{Score: float64((hash + 1) << shift), Exclusive: true}
+			z.RangeByScore(from, to, false, func(member string, score float64) bool {
+				lon, lat := GeoDecode(uint64(score))
+				if d, ok := shape.distance(lon, lat); ok && !fn(member, score, d) {
+					done = true
+				}
+				return !done
+			})
+			if done {
+				return
+			}
+		}
+	}
+}
+
+func geoCell(lon, lat float64, step uint) (int64, int64) {
+	hash := geoEncode(lon, lat, step)
+	return int64(squash(hash)), int64(squash(hash >> 1))
+}
+
+// geoSearchStep picks the finest precision whose cells, with their
+// neighbours, still cover the bounding box of shape.
+func geoSearchStep(shape GeoShape) uint {
+	radius, width, height := shape.Radius, shape.Radius, shape.Radius
+	if radius == 0 {
+		width, height = shape.Width/2, shape.Height/2
+		radius = math.Sqrt(width*width + height*height)
+	}
+	
+	step := 26
+	if radius > 0 {
+		step = 1
+		for r := radius; r < mercatorMaxSpan; r *= 2 {
+			step++
+		}
+		step -= 2
+		if shape.Lat > 66 || shape.Lat < -66 {
+			step--
+			if shape.Lat > 80 || shape.Lat < -80 {
+				step--
+			}
+		}
+		step = max(1, min(step, geoStepMax))
+	}
+	
+	// The shape is widest in longitude on its edge nearest the pole, and
+	// spans every longitude once it reaches the pole. A box measures its
+	// width as the distance between points on the same parallel, which
+	// covers more longitude than the arc along that parallel.
+	latDelta := degrees(height / earthRadius)
+	poleward := math.Abs(shape.Lat) + latDelta
+	lonDelta := 360.0
+	if poleward < 90 && shape.Radius > 0 {
+		lonDelta = degrees(width / earthRadius / math.Cos(radians(poleward)))
+	} else if x := math.Sin(width/earthRadius/2) / math.Cos(radians(poleward)); poleward < 90 && x < 1 {
+		lonDelta = degrees(2 * math.Asin(x))
+	}
+	
+	// Near the edges of a cell the neighbours may fall short of the box, in
+	// which case coarser cells are used.
+	for ; step > 1; step-- {
+		cells := float64(uint64(1) << step)
+		latSize := (GeoLatMax - GeoLatMin) / cells
+		lonSize := (GeoLonMax - GeoLonMin) / cells
+		latCell, lonCell := geoCell(shape.Lon, shape.Lat, uint(step))
+		if GeoLatMin+float64(latCell+2)*latSize >= shape.Lat+latDelta &&
+			GeoLatMin+float64(latCell-1)*latSize <= shape.Lat-latDelta &&
+			GeoLonMin+float64(lonCell+2)*lonSize >= shape.Lon+lonDelta &&
+			GeoLonMin+float64(lonCell-1)*lonSize <= shape.Lon-lonDelta {
+			break
+		}
+	}
+	return uint(step)
+}
+
+// interleave spreads the bits of lat over the even bits of the result and
+// those of lon over the odd bits.
+func interleave(lat, lon uint32) uint64 {
+	return spread(lat) | spread(lon)<<1
+}
+
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squash gathers the even bits of x, undoing spread.
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
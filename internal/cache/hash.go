@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"strings"
+)
+
+// Hash maps fields to values. Like the table encoding of Set, fields live
+// in a slice with an index into it, which gives Scan stable positions to
+// resume from.
+type Hash struct {
+	fields []hashField
+	index  map[string]int
+	size   int64
+}
+
+type hashField struct {
+	name  string
+	value []byte
+}
+
+func NewHash() *Hash {
+	return &Hash{index: make(map[string]int)}
+}
+
+func (h *Hash) Type() ValueType {
+	return TypeHash
+}
+
+func (h *Hash) Size() int64 {
+	return h.size
+}
+
+func (h *Hash) Len() int {
+	return len(h.fields)
+}
+
+func (h *Hash) Get(field string) ([]byte, bool) {
+	i, exists := h.index[field]
+	if !exists {
+		return nil, false
+	}
+	return h.fields[i].value, true
+}
+
+// Set stores value under field and reports whether the field is new. The
+// previous value slice is replaced, never overwritten, since readers may
+// still hold it. field is copied, as it may point into a reused buffer.
+func (h *Hash) Set(field string, value []byte) bool {
+	if i, exists := h.index[field]; exists {
+		h.size += int64(len(value) - len(h.fields[i].value))
+		h.fields[i].value = value
+		return false
+	}
+	
+	field = strings.Clone(field)
+	h.index[field] = len(h.fields)
+	h.fields = append(h.fields, hashField{name: field, value: value})
+	h.size += int64(len(field) + len(value))
+	return true
+}
+
+// Delete removes field, moving the last field into its place.
+func (h *Hash) Delete(field string) bool {
+	i, exists := h.index[field]
+	if !exists {
+		return false
+	}
+	h.size -= int64(len(field) + len(h.fields[i].value))
+	
+	last := len(h.fields) - 1
+	if i != last {
+		h.fields[i] = h.fields[last]
+		h.index[h.fields[i].name] = i
+	}
+	h.fields[last] = hashField{}
+	h.fields = h.fields[:last]
+	delete(h.index, field)
+	return true
+}
+
+// Range calls fn for every field until it returns false.
+func (h *Hash) Range(fn func(field string, value []byte) bool) {
+	for _, f := range h.fields {
+		if !fn(f.name, f.value) {
+			return
+		}
+	}
+}
+
+// Scan calls fn for up to count fields, resuming from cursor, and returns
+// the cursor to continue from, or 0 once every field has been visited. A
+// full scan reports every field present throughout it at least once.
+func (h *Hash) Scan(cursor uint64, count int, fn func(field string, value []byte)) uint64 {
+	return scanBackwards(len(h.fields), cursor, count, func(i int) {
+		fn(h.fields[i].name, h.fields[i].value)
+	})
+}
+
+// scanBackwards visits the positions of an n-element slice from the end,
+// starting below cursor, or at the end for cursor 0. Walking backwards
+// keeps the cursor valid under the swap-with-last removal used by Hash and
+// Set: elements appended during the scan land past the cursor, and a
+// removal only ever moves the last element, which was either visited
+// already or appended since.
+func scanBackwards(n int, cursor uint64, count int, visit func(i int)) uint64 {
+	i := n
+	if cursor > 0 && cursor < uint64(n) {
+		i = int(cursor)
+	}
+	for ; i > 0 && count > 0; count-- {
+		i--
+		visit(i)
+	}
+	return uint64(i)
+}
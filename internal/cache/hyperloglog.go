@@ -0,0 +1,436 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrInvalidHyperLogLog is returned for string values that do not hold a
+// well-formed HyperLogLog.
+var ErrInvalidHyperLogLog = errors.New("key is not a valid HyperLogLog string value")
+
+// HyperLogLogs use Redis' layout: a 16 byte header ("HYLL", the encoding and
+// a cached cardinality) followed by 16384 six-bit registers, stored either
+// densely or as run-length opcodes while most registers are still zero.
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllBits       = 6
+	hllHeaderSize = 16
+	hllDenseSize  = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	
+	hllDense  = 0
+	hllSparse = 1
+	
+	// hllSparseMaxBytes is Redis' default hll-sparse-max-bytes, past which a
+	// sparse HyperLogLog is converted to the dense encoding.
+	hllSparseMaxBytes = 3000
+	hllSparseMaxValue = 32
+	
+	hllAlphaInf = 0.721347520444481703680
+)
+
+// HyperLogLog estimates the number of distinct elements added to it. It
+// works directly on the Value of a string entry, so the structure round-trips
+// through GET and SET as an ordinary string.
+type HyperLogLog struct {
+	entry *Entry
+}
+
+// NewHyperLogLog returns a string entry holding an empty, sparse HyperLogLog.
+func NewHyperLogLog() *Entry {
+	value := hllHeader(hllSparse)
+	value = appendHLLRun(value, 0, hllRegisters)
+	return &Entry{Value: value}
+}
+
+// AsHyperLogLog wraps entry, failing with ErrWrongType if it is not a string
+// and ErrInvalidHyperLogLog if its value is not a HyperLogLog.
+func AsHyperLogLog(entry *Entry) (*HyperLogLog, error) {
+	if entry.Object != nil {
+		return nil, ErrWrongType
+	}
+	value := entry.Value
+	if len(value) < hllHeaderSize || string(value[:4]) != "HYLL" {
+		return nil, ErrInvalidHyperLogLog
+	}
+	switch value[4] {
+	case hllDense:
+		if len(value) != hllDenseSize {
+			return nil, ErrInvalidHyperLogLog
+		}
+	case hllSparse:
+		if _, ok := decodeHLLRuns(value); !ok {
+			return nil, ErrInvalidHyperLogLog
+		}
+	default:
+		return nil, ErrInvalidHyperLogLog
+	}
+	return &HyperLogLog{entry: entry}, nil
+}
+
+func hllHeader(encoding byte) []byte {
+	value := make([]byte, hllHeaderSize)
+	copy(value, "HYLL")
+	value[4] = encoding
+	return value
+}
+
+// Add records element, reporting whether any register changed.
+func (h *HyperLogLog) Add(element []byte) bool {
+	index, count := hllPattern(element)
+	value := h.entry.Value
+	if value[4] == hllDense {
+		if hllGet(value[hllHeaderSize:], index) >= count {
+			return false
+		}
+		value = h.entry.MutableValue(0)
+		hllSet(value[hllHeaderSize:], index, count)
+		h.invalidate()
+		return true
+	}
+	
+	runs, _ := decodeHLLRuns(value)
+	runs, changed := setHLLRun(runs, index, count)
+	if !changed {
+		return false
+	}
+	if count > hllSparseMaxValue {
+		h.storeDense(hllRunRegisters(runs))
+		return true
+	}
+	
+	next := hllHeader(hllSparse)
+	next[15] = 0x80
+	for _, run := range runs {
+		next = appendHLLRun(next, run.value, run.length)
+	}
+	if len(next) > hllSparseMaxBytes {
+		h.storeDense(hllRunRegisters(runs))
+		return true
+	}
+	h.entry.Value = next
+	return true
+}
+
+// Count returns the estimated cardinality, caching it in the header until
+// the next change. It writes to the entry, so it must run under Update.
+func (h *HyperLogLog) Count() uint64 {
+	if h.cached() {
+		return binary.LittleEndian.Uint64(h.entry.Value[8:16])
+	}
+	
+	var registers [hllRegisters]uint8
+	h.maxInto(&registers)
+	count := hllEstimate(&registers)
+	value := h.entry.MutableValue(0)
+	binary.LittleEndian.PutUint64(value[8:16], count)
+	return count
+}
+
+func (h *HyperLogLog) cached() bool {
+	return h.entry.Value[15]&0x80 == 0
+}
+
+func (h *HyperLogLog) invalidate() {
+	h.entry.MutableValue(0)[15] |= 0x80
+}
+
+// Merge folds the registers of others into h. The result stays sparse only
+// if h and all of others are sparse and it still fits the sparse limit.
+func (h *HyperLogLog) Merge(others ...*HyperLogLog) {
+	var registers [hllRegisters]uint8
+	h.maxInto(&registers)
+	dense := h.entry.Value[4] == hllDense
+	for _, other := range others {
+		other.maxInto(&registers)
+		dense = dense || other.entry.Value[4] == hllDense
+	}
+	
+	if !dense {
+		value := hllHeader(hllSparse)
+		value[15] = 0x80
+		for i := 0; i < hllRegisters; {
+			j := i + 1
+			for j < hllRegisters && registers[j] == registers[i] {
+				j++
+			}
+			if registers[i] > hllSparseMaxValue {
+				dense = true
+				break
+			}
+			value = appendHLLRun(value, registers[i], j-i)
+			i = j
+		}
+		if !dense && len(value) <= hllSparseMaxBytes {
+			h.entry.Value = value
+			return
+		}
+	}
+	h.storeDense(&registers)
+}
+
+// CountUnion estimates the cardinality of the union of hlls without
+// modifying any of them.
+func CountUnion(hlls ...*HyperLogLog) uint64 {
+	var registers [hllRegisters]uint8
+	for _, h := range hlls {
+		h.maxInto(&registers)
+	}
+	return hllEstimate(&registers)
+}
+
+func (h *HyperLogLog) storeDense(registers *[hllRegisters]uint8) {
+	value := hllHeader(hllDense)
+	value = append(value, make([]byte, hllDenseSize-hllHeaderSize)...)
+	value[15] = 0x80
+	for i, r := range registers {
+		if r != 0 {
+			hllSet(value[hllHeaderSize:], i, r)
+		}
+	}
+	h.entry.Value = value
+}
+
+// maxInto raises each register in registers to at least h's value for it.
+func (h *HyperLogLog) maxInto(registers *[hllRegisters]uint8) {
+	value := h.entry.Value
+	if value[4] == hllDense {
+		for i := range registers {
+			if r := hllGet(value[hllHeaderSize:], i); r > registers[i] {
+				registers[i] = r
+			}
+		}
+		return
+	}
+	
+	runs, _ := decodeHLLRuns(value)
+	i := 0
+	for _, run := range runs {
+		for end := i + run.length; i < end; i++ {
+			if run.value > registers[i] {
+				registers[i] = run.value
+			}
+		}
+	}
+}
+
+// hllPattern hashes element into the register it updates and the value it
+// proposes: one more than the number of trailing zeros of the remaining hash.
+func hllPattern(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func hllGet(registers []byte, index int) uint8 {
+	bit := index * hllBits
+	b, shift := bit/8, uint(bit%8)
+	v := registers[b] >> shift
+	if b+1 < len(registers) {
+		v |= registers[b+1] << (8 - shift)
+	}
+	return v & 63
+}
+
+func hllSet(registers []byte, index int, value uint8) {
+	bit := index * hllBits
+	b, shift := bit/8, uint(bit%8)
+	registers[b] &^= 63 << shift
+	registers[b] |= value << shift
+	if b+1 < len(registers) {
+		registers[b+1] &^= 63 >> (8 - shift)
+		registers[b+1] |= value >> (8 - shift)
+	}
+}
+
+type hllRun struct {
+	value  uint8
+	length int
+}
+
+// decodeHLLRuns expands the opcodes of a sparse value into runs of equal
+// registers. It fails unless the runs cover exactly every register.
+//
+// The opcodes are ZERO (00xxxxxx, up to 64 zero registers), XZERO
+// (01xxxxxx yyyyyyyy, up to 16384 zero registers) and VAL (1vvvvvxx, up to 4
+// registers set to vvvvv+1).
+func decodeHLLRuns(value []byte) ([]hllRun, bool) {
+	var runs []hllRun
+	total := 0
+	for p := hllHeaderSize; p < len(value); p++ {
+		var run hllRun
+		switch op := value[p]; {
+		case op&0xc0 == 0:
+			run.length = int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 >= len(value) {
+				return nil, false
+			}
+			run.length = (int(op&0x3f)<<8 | int(value[p+1])) + 1
+			p++
+		default:
+			run.value = (op>>2)&0x1f + 1
+			run.length = int(op&0x3) + 1
+		}
+		total += run.length
+		if n := len(runs); n > 0 && runs[n-1].value == run.value {
+			runs[n-1].length += run.length
+		} else {
+			runs = append(runs, run)
+		}
+	}
+	return runs, total == hllRegisters
+}
+
+// setHLLRun raises the register at index to value, splitting the run that
+// holds it and merging the result with equal neighbours.
+func setHLLRun(runs []hllRun, index int, value uint8) ([]hllRun, bool) {
+	start := 0
+	i := 0
+	for ; start+runs[i].length <= index; i++ {
+		start += runs[i].length
+	}
+	run := runs[i]
+	if run.value >= value {
+		return runs, false
+	}
+	
+	split := make([]hllRun, 0, len(runs)+2)
+	split = append(split, runs[:i]...)
+	pieces := []hllRun{
+		{run.value, index - start},
+		{value, 1},
+		{run.value, start + run.length - index - 1},
+	}
+	for _, piece := range append(pieces, runs[i+1:]...) {
+		if piece.length == 0 {
+			continue
+		}
+		if n := len(split); n > 0 && split[n-1].value == piece.value {
+			split[n-1].length += piece.length
+		} else {
+			split = append(split, piece)
+		}
+	}
+	return split, true
+}
+
+func hllRunRegisters(runs []hllRun) *[hllRegisters]uint8 {
+	var registers [hllRegisters]uint8
+	i := 0
+	for _, run := range runs {
+		for end := i + run.length; i < end; i++ {
+			registers[i] = run.value
+		}
+	}
+	return &registers
+}
+
+func appendHLLRun(buf []byte, value uint8, length int) []byte {
+	for length > 0 {
+		n := length
+		switch {
+		case value != 0:
+			n = min(n, 4)
+			buf = append(buf, 0x80|(value-1)<<2|byte(n-1))
+		case n <= 64:
+			buf = append(buf, byte(n-1))
+		default:
+			n = min(n, hllRegisters)
+			buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+		}
+		length -= n
+	}
+	return buf
+}
+
+// hllEstimate implements the cardinality estimator from Otmar Ertl's "New
+// cardinality estimation algorithms for HyperLogLog sketches", as Redis does.
+func hllEstimate(registers *[hllRegisters]uint8) uint64 {
+	// Only counts up to hllQ+1 enter the estimate, but a dense value set by
+	// a client can hold any six-bit register, so every one needs a slot.
+	var histogram [1 << hllBits]int
+	for _, r := range registers {
+		histogram[r]++
+	}
+	
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64-bit MurmurHash2 variant Redis hashes HyperLogLog
+// elements with, so registers match those Redis would compute.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	
+	h := seed ^ uint64(len(data))*m
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+	
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
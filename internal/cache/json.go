@@ -0,0 +1,661 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidJSON     = errors.New("invalid JSON value")
+	ErrInvalidJSONPath = errors.New("invalid JSON path")
+)
+
+// JSON is a document of nested values: nil, bool, int64, float64, string,
+// arrays and objects. Objects keep their keys in insertion order, like
+// RedisJSON does.
+type JSON struct {
+	root any
+	size int64
+}
+
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+type jsonArray struct {
+	items []any
+}
+
+func NewJSON(root any) *JSON {
+	return &JSON{root: root, size: jsonSize(root)}
+}
+
+func (j *JSON) Type() ValueType {
+	return TypeJSON
+}
+
+func (j *JSON) Size() int64 {
+	return j.size
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]any)}
+}
+
+func (o *jsonObject) set(key string, value any) {
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *jsonObject) remove(key string) {
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+// ParseJSON decodes a single JSON value, keeping integers that fit in an
+// int64 apart from floats.
+func ParseJSON(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	value, err := decodeJSON(dec)
+	if err != nil {
+		return nil, ErrInvalidJSON
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrInvalidJSON
+	}
+	return value, nil
+}
+
+func decodeJSON(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			obj := newJSONObject()
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.set(key.(string), value)
+			}
+			_, err := dec.Token()
+			return obj, err
+		}
+		arr := &jsonArray{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr.items = append(arr.items, value)
+		}
+		_, err := dec.Token()
+		return arr, err
+	case json.Number:
+		if n, err := tok.Int64(); err == nil {
+			return n, nil
+		}
+		return tok.Float64()
+	default:
+		return tok, nil
+	}
+}
+
+// JSONType names the type of a JSON value the way JSON.TYPE does.
+func JSONType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *jsonObject:
+		return "object"
+	default:
+		return "array"
+	}
+}
+
+// JSONList wraps values as a JSON array, for replies listing several
+// matches.
+func JSONList(values []any) any {
+	return &jsonArray{items: values}
+}
+
+// JSONFields wraps values as a JSON object under the given keys.
+func JSONFields(keys []string, values []any) any {
+	obj := newJSONObject()
+	for i, key := range keys {
+		obj.set(key, values[i])
+	}
+	return obj
+}
+
+type jsonSegmentKind int
+
+const (
+	jsonKey jsonSegmentKind = iota
+	jsonIndex
+	jsonWildcard
+)
+
+type jsonSegment struct {
+	kind  jsonSegmentKind
+	key   string
+	index int
+	// descend applies the segment at every depth below the current one,
+	// as in "$..name".
+	descend bool
+}
+
+// JSONPath selects values within a document. It accepts JSONPath syntax
+// starting at "$" with .name, ['name'], [index], [*], .* and ..name, as well
+// as RedisJSON's legacy paths such as ".a.b", which address a single value.
+type JSONPath struct {
+	segments []jsonSegment
+	Legacy   bool
+}
+
+func ParseJSONPath(s string) (*JSONPath, error) {
+	path := &JSONPath{Legacy: !strings.HasPrefix(s, "$")}
+	rest := strings.TrimPrefix(s, "$")
+	if path.Legacy && rest == "." {
+		rest = ""
+	} else if path.Legacy && !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+		rest = "." + rest
+	}
+	
+	for rest != "" {
+		var seg jsonSegment
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			seg.descend = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] != '[':
+			return nil, ErrInvalidJSONPath
+		}
+		
+		switch {
+		case strings.HasPrefix(rest, "["):
+			var err error
+			if rest, err = parseJSONBracket(rest, &seg); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(rest, "*"):
+			seg.kind = jsonWildcard
+			rest = rest[1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, ErrInvalidJSONPath
+			}
+			// Keys end up in documents, so they must not share the memory of
+			// s, which may be a reused request buffer.
+			seg.kind, seg.key = jsonKey, strings.Clone(rest[:end])
+			rest = rest[end:]
+		}
+		path.segments = append(path.segments, seg)
+	}
+	return path, nil
+}
+
+// parseJSONBracket parses a [*], [index] or quoted ['name'] segment at the
+// start of s, returning what follows it.
+func parseJSONBracket(s string, seg *jsonSegment) (string, error) {
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		quote := s[1]
+		var key strings.Builder
+		for i := 2; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s):
+				i++
+				key.WriteByte(s[i])
+			case s[i] == quote:
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return "", ErrInvalidJSONPath
+				}
+				seg.kind, seg.key = jsonKey, key.String()
+				return s[i+2:], nil
+			default:
+				key.WriteByte(s[i])
+			}
+		}
+		return "", ErrInvalidJSONPath
+	}
+	
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return "", ErrInvalidJSONPath
+	}
+	inner := strings.TrimSpace(s[1:end])
+	if inner == "*" {
+		seg.kind = jsonWildcard
+		return s[end+1:], nil
+	}
+	n, err := strconv.Atoi(inner)
+	if err != nil {
+		return "", ErrInvalidJSONPath
+	}
+	seg.kind, seg.index = jsonIndex, n
+	return s[end+1:], nil
+}
+
+// IsRoot reports whether the path selects the whole document.
+func (p *JSONPath) IsRoot() bool {
+	return len(p.segments) == 0
+}
+
+// jsonMatch is a value selected by a path, along with the container holding
+// it so it can be replaced or removed. The root has a nil parent.
+type jsonMatch struct {
+	parent any
+	key    string
+	index  int
+	value  any
+}
+
+func (j *JSON) match(segments []jsonSegment) []jsonMatch {
+	matches := []jsonMatch{{value: j.root}}
+	for _, seg := range segments {
+		var next []jsonMatch
+		for _, m := range matches {
+			if seg.descend {
+				next = descendJSON(m.value, seg, next)
+			} else {
+				next = selectJSON(m.value, seg, next)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+func selectJSON(v any, seg jsonSegment, out []jsonMatch) []jsonMatch {
+	switch v := v.(type) {
+	case *jsonObject:
+		switch seg.kind {
+		case jsonKey:
+			if child, ok := v.values[seg.key]; ok {
+				out = append(out, jsonMatch{parent: v, key: seg.key, value: child})
+			}
+		case jsonWildcard:
+			for _, key := range v.keys {
+				out = append(out, jsonMatch{parent: v, key: key, value: v.values[key]})
+			}
+		}
+	case *jsonArray:
+		switch seg.kind {
+		case jsonIndex:
+			i := seg.index
+			if i < 0 {
+				i += len(v.items)
+			}
+			if i >= 0 && i < len(v.items) {
+				out = append(out, jsonMatch{parent: v, index: i, value: v.items[i]})
+			}
+		case jsonWildcard:
+			for i, item := range v.items {
+				out = append(out, jsonMatch{parent: v, index: i, value: item})
+			}
+		}
+	}
+	return out
+}
+
+func descendJSON(v any, seg jsonSegment, out []jsonMatch) []jsonMatch {
+	out = selectJSON(v, seg, out)
+	switch v := v.(type) {
+	case *jsonObject:
+		for _, key := range v.keys {
+			out = descendJSON(v.values[key], seg, out)
+		}
+	case *jsonArray:
+		for _, item := range v.items {
+			out = descendJSON(item, seg, out)
+		}
+	}
+	return out
+}
+
+// replace stores value in place of the matched one.
+func (j *JSON) replace(m jsonMatch, value any) {
+	j.size += jsonSize(value) - jsonSize(m.value)
+	switch parent := m.parent.(type) {
+	case *jsonObject:
+		parent.values[m.key] = value
+	case *jsonArray:
+		parent.items[m.index] = value
+	default:
+		j.root = value
+	}
+}
+
+// Get returns the values path selects.
+func (j *JSON) Get(path *JSONPath) []any {
+	matches := j.match(path.segments)
+	values := make([]any, len(matches))
+	for i, m := range matches {
+		values[i] = m.value
+	}
+	return values
+}
+
+// Set stores value at every location path selects, also adding it under a
+// missing key when the path ends in a name and its parent is an object. nx
+// and xx restrict the write to new or existing locations. It reports
+// whether anything was written.
+func (j *JSON) Set(path *JSONPath, value any, nx, xx bool) bool {
+	if path.IsRoot() {
+		if nx {
+			return false
+		}
+		j.root, j.size = value, jsonSize(value)
+		return true
+	}
+	
+	written := false
+	// Every location after the first gets its own copy, so that later
+	// updates through one path do not show through another.
+	next := func() any {
+		if written {
+			return cloneJSON(value)
+		}
+		written = true
+		return value
+	}
+	
+	last := path.segments[len(path.segments)-1]
+	for _, parent := range j.match(path.segments[:len(path.segments)-1]) {
+		var targets []jsonMatch
+		if last.descend {
+			targets = descendJSON(parent.value, last, nil)
+		} else {
+			targets = selectJSON(parent.value, last, nil)
+		}
+		
+		if obj, ok := parent.value.(*jsonObject); ok && len(targets) == 0 && last.kind == jsonKey && !last.descend {
+			if !xx {
+				v := next()
+				obj.set(last.key, v)
+				j.size += int64(len(last.key)) + jsonSize(v)
+			}
+			continue
+		}
+		if nx {
+			continue
+		}
+		for _, m := range targets {
+			j.replace(m, next())
+		}
+	}
+	return written
+}
+
+// Delete removes the values path selects below the root and returns how
+// many were removed.
+func (j *JSON) Delete(path *JSONPath) int {
+	matches := j.match(path.segments)
+	// Array elements go from the highest index down, so the indexes of the
+	// remaining matches stay valid.
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].index > matches[b].index
+	})
+	
+	deleted := 0
+	for _, m := range matches {
+		switch parent := m.parent.(type) {
+		case *jsonObject:
+			parent.remove(m.key)
+			deleted++
+		case *jsonArray:
+			parent.items = append(parent.items[:m.index], parent.items[m.index+1:]...)
+			deleted++
+		}
+	}
+	// Matches may be nested inside one another, so the size is recounted.
+	j.size = jsonSize(j.root)
+	return deleted
+}
+
+// IncrBy adds incr, an int64 or float64, to every number path selects. It
+// returns the new values, nil for matches that are not numbers, and fails
+// without changing anything if a result would not be a finite number.
+func (j *JSON) IncrBy(path *JSONPath, incr any) ([]any, error) {
+	matches := j.match(path.segments)
+	results := make([]any, len(matches))
+	for i, m := range matches {
+		switch n := m.value.(type) {
+		case int64:
+			if d, ok := incr.(int64); ok && !(d > 0 && n > math.MaxInt64-d) && !(d < 0 && n < math.MinInt64-d) {
+				results[i] = n + d
+				continue
+			}
+			results[i] = float64(n) + jsonFloat(incr)
+		case float64:
+			results[i] = n + jsonFloat(incr)
+		default:
+			continue
+		}
+		if f, ok := results[i].(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return nil, errors.New("result is not a finite number")
+		}
+	}
+	
+	for i, m := range matches {
+		if results[i] != nil {
+			j.replace(m, results[i])
+		}
+	}
+	return results, nil
+}
+
+func jsonFloat(n any) float64 {
+	if i, ok := n.(int64); ok {
+		return float64(i)
+	}
+	return n.(float64)
+}
+
+// ArrAppend appends values to every array path selects and returns the new
+// lengths, -1 for matches that are not arrays.
+func (j *JSON) ArrAppend(path *JSONPath, values []any) []int {
+	matches := j.match(path.segments)
+	lengths := make([]int, len(matches))
+	for i, m := range matches {
+		arr, ok := m.value.(*jsonArray)
+		if !ok {
+			lengths[i] = -1
+			continue
+		}
+		for _, v := range values {
+			if i > 0 {
+				v = cloneJSON(v)
+			}
+			arr.items = append(arr.items, v)
+			j.size += jsonSize(v)
+		}
+		lengths[i] = len(arr.items)
+	}
+	return lengths
+}
+
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case *jsonObject:
+		obj := newJSONObject()
+		for _, key := range v.keys {
+			obj.set(key, cloneJSON(v.values[key]))
+		}
+		return obj
+	case *jsonArray:
+		arr := &jsonArray{items: make([]any, len(v.items))}
+		for i, item := range v.items {
+			arr.items[i] = cloneJSON(item)
+		}
+		return arr
+	default:
+		return v
+	}
+}
+
+// jsonSize counts string and key bytes, plus 8 for every other scalar.
+func jsonSize(v any) int64 {
+	switch v := v.(type) {
+	case string:
+		return int64(len(v))
+	case *jsonObject:
+		var n int64
+		for key, child := range v.values {
+			n += int64(len(key)) + jsonSize(child)
+		}
+		return n
+	case *jsonArray:
+		var n int64
+		for _, item := range v.items {
+			n += jsonSize(item)
+		}
+		return n
+	default:
+		return 8
+	}
+}
+
+// JSONFormat controls the whitespace MarshalJSON emits, as the INDENT,
+// NEWLINE and SPACE options of JSON.GET do. The zero value is compact.
+type JSONFormat struct {
+	Indent  string
+	Newline string
+	Space   string
+}
+
+func MarshalJSON(v any, format JSONFormat) string {
+	return string(appendJSON(nil, v, format, 0))
+}
+
+func appendJSON(buf []byte, v any, f JSONFormat, depth int) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		return appendJSONFloat(buf, v)
+	case string:
+		return appendJSONString(buf, v)
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			return append(buf, "{}"...)
+		}
+		buf = append(buf, '{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONIndent(buf, f, depth+1)
+			buf = appendJSONString(buf, key)
+			buf = append(buf, ':')
+			buf = append(buf, f.Space...)
+			buf = appendJSON(buf, v.values[key], f, depth+1)
+		}
+		buf = appendJSONIndent(buf, f, depth)
+		return append(buf, '}')
+	case *jsonArray:
+		if len(v.items) == 0 {
+			return append(buf, "[]"...)
+		}
+		buf = append(buf, '[')
+		for i, item := range v.items {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONIndent(buf, f, depth+1)
+			buf = appendJSON(buf, item, f, depth+1)
+		}
+		buf = appendJSONIndent(buf, f, depth)
+		return append(buf, ']')
+	}
+	return buf
+}
+
+func appendJSONIndent(buf []byte, f JSONFormat, depth int) []byte {
+	buf = append(buf, f.Newline...)
+	for i := 0; i < depth; i++ {
+		buf = append(buf, f.Indent...)
+	}
+	return buf
+}
+
+// appendJSONFloat writes the shortest representation of f that reads back
+// the same, always with a fraction or exponent so it stays a float.
+func appendJSONFloat(buf []byte, f float64) []byte {
+	start := len(buf)
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-5 || abs >= 1e16) {
+		buf = strconv.AppendFloat(buf, f, 'e', -1, 64)
+		if i := strings.IndexByte(string(buf[start:]), '+'); i >= 0 {
+			buf = append(buf[:start+i], buf[start+i+1:]...)
+		}
+	} else {
+		buf = strconv.AppendFloat(buf, f, 'f', -1, 64)
+	}
+	if !strings.ContainsAny(string(buf[start:]), ".e") {
+		buf = append(buf, ".0"...)
+	}
+	return buf
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf = append(buf, '\\', c)
+		case c == '\n':
+			buf = append(buf, '\\', 'n')
+		case c == '\r':
+			buf = append(buf, '\\', 'r')
+		case c == '\t':
+			buf = append(buf, '\\', 't')
+		case c < 0x20:
+			buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,199 @@
+package cache
+
+// listChunkSize bounds the number of elements per node. Like Redis'
+// quicklist, chunking keeps per-element pointer overhead low while pushes
+// and pops at either end stay cheap.
+const listChunkSize = 128
+
+type listNode struct {
+	items      [][]byte
+	prev, next *listNode
+}
+
+// List is a deque of elements stored as a doubly linked list of chunks.
+type List struct {
+	head, tail *listNode
+	length     int
+	size       int64
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+func (l *List) Type() ValueType {
+	return TypeList
+}
+
+func (l *List) Size() int64 {
+	return l.size
+}
+
+func (l *List) Len() int {
+	return l.length
+}
+
+func (l *List) PushFront(value []byte) {
+	if l.head == nil || len(l.head.items) >= listChunkSize {
+		node := &listNode{items: make([][]byte, 0, 8), next: l.head}
+		if l.head != nil {
+			l.head.prev = node
+		} else {
+			l.tail = node
+		}
+		l.head = node
+	}
+	
+	items := append(l.head.items, nil)
+	copy(items[1:], items)
+	items[0] = value
+	l.head.items = items
+	l.length++
+	l.size += int64(len(value))
+}
+
+func (l *List) PushBack(value []byte) {
+	if l.tail == nil || len(l.tail.items) >= listChunkSize {
+		node := &listNode{items: make([][]byte, 0, 8), prev: l.tail}
+		if l.tail != nil {
+			l.tail.next = node
+		} else {
+			l.head = node
+		}
+		l.tail = node
+	}
+	
+	l.tail.items = append(l.tail.items, value)
+	l.length++
+	l.size += int64(len(value))
+}
+
+func (l *List) PopFront() ([]byte, bool) {
+	if l.head == nil {
+		return nil, false
+	}
+	
+	node := l.head
+	value := node.items[0]
+	node.items[0] = nil
+	node.items = node.items[1:]
+	if len(node.items) == 0 {
+		l.unlink(node)
+	}
+	l.length--
+	l.size -= int64(len(value))
+	return value, true
+}
+
+func (l *List) PopBack() ([]byte, bool) {
+	if l.tail == nil {
+		return nil, false
+	}
+	
+	node := l.tail
+	last := len(node.items) - 1
+	value := node.items[last]
+	node.items[last] = nil
+	node.items = node.items[:last]
+	if len(node.items) == 0 {
+		l.unlink(node)
+	}
+	l.length--
+	l.size -= int64(len(value))
+	return value, true
+}
+
+// Index returns the element at index, counting from the tail when index is
+// negative.
+func (l *List) Index(index int) ([]byte, bool) {
+	node, offset, ok := l.locate(index)
+	if !ok {
+		return nil, false
+	}
+	return node.items[offset], true
+}
+
+// Set replaces the element at index, counting from the tail when index is
+// negative. It reports false if index is out of range.
+func (l *List) Set(index int, value []byte) bool {
+	node, offset, ok := l.locate(index)
+	if !ok {
+		return false
+	}
+	l.size += int64(len(value) - len(node.items[offset]))
+	node.items[offset] = value
+	return true
+}
+
+// Range calls fn for the elements from start to stop inclusive, which must
+// already be resolved to valid non-negative indexes, until fn returns false.
+func (l *List) Range(start, stop int, fn func(value []byte) bool) {
+	node, offset, ok := l.locate(start)
+	if !ok {
+		return
+	}
+	
+	for i := start; i <= stop && node != nil; i++ {
+		if !fn(node.items[offset]) {
+			return
+		}
+		offset++
+		if offset == len(node.items) {
+			node, offset = node.next, 0
+		}
+	}
+}
+
+// Trim keeps only the elements from start to stop inclusive, which must
+// already be resolved to valid non-negative indexes.
+func (l *List) Trim(start, stop int) {
+	tail := l.length - 1 - stop
+	for i := 0; i < start; i++ {
+		l.PopFront()
+	}
+	for i := 0; i < tail; i++ {
+		l.PopBack()
+	}
+}
+
+func (l *List) locate(index int) (*listNode, int, bool) {
+	if index < 0 {
+		index += l.length
+	}
+	if index < 0 || index >= l.length {
+		return nil, 0, false
+	}
+	
+	if index < l.length/2 {
+		for node := l.head; node != nil; node = node.next {
+			if index < len(node.items) {
+				return node, index, true
+			}
+			index -= len(node.items)
+		}
+		return nil, 0, false
+	}
+	
+	index = l.length - 1 - index
+	for node := l.tail; node != nil; node = node.prev {
+		if index < len(node.items) {
+			return node, len(node.items) - 1 - index, true
+		}
+		index -= len(node.items)
+	}
+	return nil, 0, false
+}
+
+func (l *List) unlink(node *listNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+	node.prev, node.next = nil, nil
+}
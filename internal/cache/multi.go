@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// GetMulti reads several keys as one atomic snapshot: every shard involved is
+// read-locked for the whole call, so a concurrent SetMulti is observed either
+// entirely or not at all. found[i] reports whether keys[i] holds a string.
+func (c *Cache) GetMulti(keys []string) (values [][]byte, found []bool) {
+	shards := c.shardsFor(keys)
+	for _, s := range shards {
+		s.mu.RLock()
+	}
+	defer func() {
+		for _, s := range shards {
+			s.mu.RUnlock()
+		}
+	}()
+	
+	now := time.Now().UnixNano()
+	values = make([][]byte, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		s := c.shard(key)
+		entry, exists := s.get(key)
+		if !exists || entry.Object != nil || (entry.ExpiresAt > 0 && now > entry.ExpiresAt) {
+			continue
+		}
+		entry.touch(now)
+		s.onGet(entry.Key, entry)
+		entry.share()
+		values[i] = entry.Value
+		found[i] = true
+	}
+	return values, found
+}
+
+// ViewMulti runs fn with the live entries for keys, nil for missing ones, as
+// one atomic snapshot under the read locks of every shard involved. fn must
+// not modify the entries.
+func (c *Cache) ViewMulti(keys []string, fn func(entries []*Entry) error) error {
+	shards := c.shardsFor(keys)
+	for _, s := range shards {
+		s.mu.RLock()
+	}
+	defer func() {
+		for _, s := range shards {
+			s.mu.RUnlock()
+		}
+	}()
+	
+	now := time.Now().UnixNano()
+	entries := make([]*Entry, len(keys))
+	for i, key := range keys {
+		s := c.shard(key)
+		entry, exists := s.get(key)
+		if !exists || (entry.ExpiresAt > 0 && now > entry.ExpiresAt) {
+			continue
+		}
+		entry.touch(now)
+		s.onGet(entry.Key, entry)
+		entries[i] = entry
+	}
+	return fn(entries)
+}
+
+// SetMulti stores all key/value pairs atomically with respect to GetMulti and
+// other multi-key operations, replacing values of any type. With onlyIfNoneExist nothing is written if any
+// key already exists, and false is returned. Like Update it is never refused
+// for lack of memory; callers run CheckMemory first.
+func (c *Cache) SetMulti(keys []string, values [][]byte, onlyIfNoneExist bool) bool {
+	shards := c.shardsFor(keys)
+	for _, s := range shards {
+		s.mu.Lock()
+	}
+	defer func() {
+		for _, s := range shards {
+			s.mu.Unlock()
+		}
+	}()
+	
+	now := time.Now().UnixNano()
+	if onlyIfNoneExist {
+		for _, key := range keys {
+			if c.lookup(c.shard(key), key, now) != nil {
+				return false
+			}
+		}
+	}
+	
+	for i, key := range keys {
+		c.store(c.shard(key), &Entry{
+			Key:       key,
+			Value:     values[i],
+			CreatedAt: now,
+			LastUsed:  now,
+			UseCount:  1,
+		})
+	}
+	c.reclaim(shards[0], c.overLimit)
+	return true
+}
+
+// shardsFor returns the distinct shards owning keys in index order, which is
+// the order multi-key operations must lock them in to avoid deadlocks.
+func (c *Cache) shardsFor(keys []string) []*shard {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := int(hashKey(key) & c.mask)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	
+	shards := make([]*shard, len(indexes))
+	for i, index := range indexes {
+		shards[i] = c.shards[index]
+	}
+	return shards
+}
+
+// UpdateMulti is Update across several keys at once: every shard involved is
+// locked for the duration of fn, which receives the live entries in the
+// order of keys and returns the entries to store, nil deleting a key. A key
+// listed more than once is looked up once, and only the result at its first
+// position is stored.
+func (c *Cache) UpdateMulti(keys []string, fn func(entries []*Entry) ([]*Entry, error)) error {
+	shards := c.shardsFor(keys)
+	for _, s := range shards {
+		s.mu.Lock()
+	}
+	defer func() {
+		for _, s := range shards {
+			s.mu.Unlock()
+		}
+	}()
+	
+	now := time.Now().UnixNano()
+	entries := make([]*Entry, len(keys))
+	for i, key := range keys {
+		entries[i] = c.lookup(c.shard(key), key, now)
+	}
+	
+	next, err := fn(entries)
+	if err != nil {
+		return err
+	}
+	
+	done := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if done[key] {
+			continue
+		}
+		done[key] = true
+		c.apply(c.shard(key), key, entries[i], next[i], now)
+	}
+	c.reclaim(shards[0], c.overLimit)
+	return nil
+}
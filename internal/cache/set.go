@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// intsetMaxEntries bounds how many members a set keeps in its compact
+// integer encoding, like Redis' set-max-intset-entries.
+const intsetMaxEntries = 512
+
+// Set holds unique members. While it holds only integers, and no more than
+// intsetMaxEntries of them, it is stored as a sorted []int64 (an intset); it
+// converts to a table the first time that stops being true.
+type Set struct {
+	ints []int64
+	
+	// members and index form the table encoding; index maps each member to
+	// its position in members so random picks stay O(1).
+	members []string
+	index   map[string]int
+	
+	size int64
+}
+
+func NewSet() *Set {
+	return &Set{}
+}
+
+func (s *Set) Type() ValueType {
+	return TypeSet
+}
+
+func (s *Set) Size() int64 {
+	return s.size
+}
+
+func (s *Set) Len() int {
+	if s.index == nil {
+		return len(s.ints)
+	}
+	return len(s.members)
+}
+
+// Add inserts member and reports whether it was not already present.
+func (s *Set) Add(member string) bool {
+	if s.index == nil {
+		if n, ok := parseSetInt(member); ok {
+			i, found := s.search(n)
+			if found {
+				return false
+			}
+			if len(s.ints) < intsetMaxEntries {
+				s.ints = append(s.ints, 0)
+				copy(s.ints[i+1:], s.ints[i:])
+				s.ints[i] = n
+				s.size += 8
+				return true
+			}
+		}
+		s.convert()
+	}
+	
+	if _, exists := s.index[member]; exists {
+		return false
+	}
+	member = strings.Clone(member)
+	s.index[member] = len(s.members)
+	s.members = append(s.members, member)
+	s.size += int64(len(member))
+	return true
+}
+
+// Remove deletes member and reports whether it was present.
+func (s *Set) Remove(member string) bool {
+	if s.index == nil {
+		n, ok := parseSetInt(member)
+		if !ok {
+			return false
+		}
+		i, found := s.search(n)
+		if !found {
+			return false
+		}
+		s.removeInt(i)
+		return true
+	}
+	
+	i, exists := s.index[member]
+	if !exists {
+		return false
+	}
+	s.removeMember(i)
+	return true
+}
+
+func (s *Set) Contains(member string) bool {
+	if s.index == nil {
+		n, ok := parseSetInt(member)
+		if !ok {
+			return false
+		}
+		_, found := s.search(n)
+		return found
+	}
+	
+	_, exists := s.index[member]
+	return exists
+}
+
+// Range calls fn for every member until it returns false. Intsets are
+// visited in ascending order.
+func (s *Set) Range(fn func(member string) bool) {
+	if s.index == nil {
+		for _, n := range s.ints {
+			if !fn(strconv.FormatInt(n, 10)) {
+				return
+			}
+		}
+		return
+	}
+	
+	for _, member := range s.members {
+		if !fn(member) {
+			return
+		}
+	}
+}
+
+// Scan calls fn for up to count members, resuming from cursor, and returns
+// the cursor to continue from, or 0 once every member has been visited. A
+// full scan reports every member present throughout it at least once. Like
+// Redis with its compact encodings, an intset, which is small, is visited
+// whole in one call.
+func (s *Set) Scan(cursor uint64, count int, fn func(member string)) uint64 {
+	if s.index == nil {
+		for _, n := range s.ints {
+			fn(strconv.FormatInt(n, 10))
+		}
+		return 0
+	}
+	return scanBackwards(len(s.members), cursor, count, func(i int) {
+		fn(s.members[i])
+	})
+}
+
+// Random returns a uniformly chosen member, or false if the set is empty.
+func (s *Set) Random() (string, bool) {
+	if s.Len() == 0 {
+		return "", false
+	}
+	
+	i := rand.Intn(s.Len())
+	if s.index == nil {
+		return strconv.FormatInt(s.ints[i], 10), true
+	}
+	return s.members[i], true
+}
+
+// Pop removes and returns a uniformly chosen member.
+func (s *Set) Pop() (string, bool) {
+	if s.Len() == 0 {
+		return "", false
+	}
+	
+	i := rand.Intn(s.Len())
+	if s.index == nil {
+		member := strconv.FormatInt(s.ints[i], 10)
+		s.removeInt(i)
+		return member, true
+	}
+	member := s.members[i]
+	s.removeMember(i)
+	return member, true
+}
+
+func (s *Set) search(n int64) (int, bool) {
+	i := sort.Search(len(s.ints), func(i int) bool { return s.ints[i] >= n })
+	return i, i < len(s.ints) && s.ints[i] == n
+}
+
+func (s *Set) removeInt(i int) {
+	s.ints = append(s.ints[:i], s.ints[i+1:]...)
+	s.size -= 8
+}
+
+func (s *Set) removeMember(i int) {
+	member := s.members[i]
+	last := len(s.members) - 1
+	if i != last {
+		s.members[i] = s.members[last]
+		s.index[s.members[i]] = i
+	}
+	s.members[last] = ""
+	s.members = s.members[:last]
+	delete(s.index, member)
+	s.size -= int64(len(member))
+}
+
+// convert switches an intset to the table encoding.
+func (s *Set) convert() {
+	s.members = make([]string, 0, len(s.ints)+1)
+	s.index = make(map[string]int, len(s.ints)+1)
+	s.size = 0
+	for _, n := range s.ints {
+		member := strconv.FormatInt(n, 10)
+		s.index[member] = len(s.members)
+		s.members = append(s.members, member)
+		s.size += int64(len(member))
+	}
+	s.ints = nil
+}
+
+// parseSetInt reports whether member is the canonical decimal form of an
+// int64, which is what qualifies it for the intset encoding.
+func parseSetInt(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
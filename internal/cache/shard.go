@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"sync"
+)
+
+// Keys are spread over shardBuckets fixed buckets by the high bits of their
+// hash, the low bits having picked the shard. Scan resumes at a bucket
+// boundary, which stays put however the maps grow or shrink.
+const (
+	shardBucketBits = 6
+	shardBuckets    = 1 << shardBucketBits
+)
+
+type shard struct {
+	mu      sync.RWMutex
+	buckets [shardBuckets]map[string]*Entry
+	expires map[string]*Entry
+	
+	// policyMu serializes policy calls, since readers only hold mu.RLock
+	// while OnGet reorders the policy's bookkeeping.
+	policyMu sync.Mutex
+	policy   EvictionPolicy
+	
+	hits   int64
+	misses int64
+}
+
+func newShard(policy EvictionPolicy) *shard {
+	return &shard{
+		expires: make(map[string]*Entry),
+		policy:  policy,
+	}
+}
+
+func bucketOf(key string) int {
+	return int(hashKey(key) >> (64 - shardBucketBits))
+}
+
+func (s *shard) get(key string) (*Entry, bool) {
+	entry, exists := s.buckets[bucketOf(key)][key]
+	return entry, exists
+}
+
+// put stores entry under key, creating its bucket on first use.
+func (s *shard) put(key string, entry *Entry) {
+	b := bucketOf(key)
+	if s.buckets[b] == nil {
+		s.buckets[b] = make(map[string]*Entry)
+	}
+	s.buckets[b][key] = entry
+}
+
+func (s *shard) del(key string) {
+	delete(s.buckets[bucketOf(key)], key)
+}
+
+func (s *shard) len() int {
+	n := 0
+	for _, bucket := range s.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+func (s *shard) onGet(key string, entry *Entry) {
+	if s.policy == nil {
+		return
+	}
+	s.policyMu.Lock()
+	s.policy.OnGet(key, entry)
+	s.policyMu.Unlock()
+}
+
+func (s *shard) onSet(key string, entry *Entry) {
+	if s.policy == nil {
+		return
+	}
+	s.policyMu.Lock()
+	s.policy.OnSet(key, entry)
+	s.policyMu.Unlock()
+}
+
+func (s *shard) onDelete(key string) {
+	if s.policy == nil {
+		return
+	}
+	s.policyMu.Lock()
+	s.policy.OnDelete(key)
+	s.policyMu.Unlock()
+}
+
+func (s *shard) victims(excess int64) []string {
+	if s.policy == nil {
+		return nil
+	}
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	return s.policy.GetVictims(excess)
+}
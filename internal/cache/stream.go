@@ -0,0 +1,390 @@
+package cache
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StreamID identifies a stream entry: a millisecond timestamp plus a
+// sequence number for entries added within the same millisecond.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID sorts after every other ID.
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// ParseStreamID parses "ms-seq", or "ms" with seq defaulting to defaultSeq.
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	return StreamID{Ms: ms, Seq: seq}, true
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Next returns the smallest ID greater than id, or id itself if it is
+// already the maximum.
+func (id StreamID) Next() StreamID {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}
+	default:
+		return id
+	}
+}
+
+// Prev returns the largest ID smaller than id, or id itself if it is 0-0.
+func (id StreamID) Prev() StreamID {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}
+	default:
+		return id
+	}
+}
+
+// StreamEntry is one stream record; Fields alternates field names and
+// values in insertion order.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+func (e StreamEntry) size() int64 {
+	size := int64(16)
+	for _, field := range e.Fields {
+		size += int64(len(field))
+	}
+	return size
+}
+
+// Stream is an append-only log of entries ordered by ID, with consumer
+// groups tracking what each group has delivered and what is still
+// unacknowledged.
+type Stream struct {
+	entries []StreamEntry
+	lastID  StreamID
+	groups  map[string]*ConsumerGroup
+	size    int64
+}
+
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*ConsumerGroup)}
+}
+
+func (s *Stream) Type() ValueType {
+	return TypeStream
+}
+
+func (s *Stream) Size() int64 {
+	return s.size
+}
+
+func (s *Stream) Len() int {
+	return len(s.entries)
+}
+
+// LastID returns the ID of the last entry ever added, even if it has since
+// been trimmed.
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+// NextID returns the ID an auto-generated entry added at nowMs gets.
+func (s *Stream) NextID(nowMs uint64) StreamID {
+	if nowMs > s.lastID.Ms {
+		return StreamID{Ms: nowMs}
+	}
+	return s.lastID.Next()
+}
+
+// Add appends an entry and reports false, adding nothing, unless id is
+// greater than every ID added before. The strings in fields are copied, as
+// they may point into a reused buffer.
+func (s *Stream) Add(id StreamID, fields []string) bool {
+	if !s.lastID.Less(id) {
+		return false
+	}
+	
+	for i, field := range fields {
+		fields[i] = strings.Clone(field)
+	}
+	entry := StreamEntry{ID: id, Fields: fields}
+	s.entries = append(s.entries, entry)
+	s.lastID = id
+	s.size += entry.size()
+	return true
+}
+
+// Get returns the entry with the given ID.
+func (s *Stream) Get(id StreamID) (StreamEntry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].ID == id {
+		return s.entries[i], true
+	}
+	return StreamEntry{}, false
+}
+
+// Range calls fn for the entries with IDs between start and end inclusive,
+// in descending order if reverse is set, until fn returns false.
+func (s *Stream) Range(start, end StreamID, reverse bool, fn func(entry StreamEntry) bool) {
+	from := s.search(start)
+	to := s.search(end.Next())
+	if end == MaxStreamID {
+		to = len(s.entries)
+	}
+	
+	if reverse {
+		for i := to - 1; i >= from; i-- {
+			if !fn(s.entries[i]) {
+				return
+			}
+		}
+		return
+	}
+	for i := from; i < to; i++ {
+		if !fn(s.entries[i]) {
+			return
+		}
+	}
+}
+
+// TrimMaxLen removes the oldest entries until at most maxLen remain,
+// removing no more than limit entries if limit is positive. It returns the
+// number of entries removed.
+func (s *Stream) TrimMaxLen(maxLen int, limit int) int {
+	n := len(s.entries) - maxLen
+	return s.trim(n, limit)
+}
+
+// TrimMinID removes entries with IDs below minID, like TrimMaxLen.
+func (s *Stream) TrimMinID(minID StreamID, limit int) int {
+	return s.trim(s.search(minID), limit)
+}
+
+func (s *Stream) trim(n int, limit int) int {
+	if n <= 0 {
+		return 0
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	
+	for i := 0; i < n; i++ {
+		s.size -= s.entries[i].size()
+		s.entries[i] = StreamEntry{}
+	}
+	// Reslicing drops the trimmed prefix from the capacity, so the next
+	// growing append lets the old backing array go.
+	s.entries = s.entries[n:]
+	return n
+}
+
+func (s *Stream) search(id StreamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// CreateGroup adds a consumer group that will deliver entries after lastID,
+// reporting false if the group already exists.
+func (s *Stream) CreateGroup(name string, lastID StreamID) bool {
+	if _, exists := s.groups[name]; exists {
+		return false
+	}
+	name = strings.Clone(name)
+	s.groups[name] = &ConsumerGroup{
+		LastID:    lastID,
+		stream:    s,
+		pending:   make(map[StreamID]*PendingEntry),
+		consumers: make(map[string]*Consumer),
+	}
+	s.size += int64(len(name))
+	return true
+}
+
+func (s *Stream) Group(name string) *ConsumerGroup {
+	return s.groups[name]
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	group, exists := s.groups[name]
+	if !exists {
+		return false
+	}
+	for consumer := range group.consumers {
+		group.DeleteConsumer(consumer)
+	}
+	delete(s.groups, name)
+	s.size -= int64(len(name))
+	return true
+}
+
+// pendingEntrySize approximates the bookkeeping cost of one unacknowledged
+// delivery.
+const pendingEntrySize = 40
+
+// ConsumerGroup delivers each stream entry to one of its consumers and
+// keeps it in the pending entries list until acknowledged.
+type ConsumerGroup struct {
+	// LastID is the last entry delivered to any consumer of the group.
+	LastID StreamID
+	
+	stream    *Stream
+	pending   map[StreamID]*PendingEntry
+	consumers map[string]*Consumer
+}
+
+// PendingEntry is a delivered but unacknowledged entry.
+type PendingEntry struct {
+	ID       StreamID
+	Consumer string
+	// DeliveredAt is the Unix time in milliseconds of the last delivery.
+	DeliveredAt int64
+	Deliveries  int64
+}
+
+type Consumer struct {
+	Name string
+	// SeenAt is the Unix time in milliseconds the consumer last read.
+	SeenAt int64
+}
+
+// Consumer returns the named consumer, creating it if needed, and marks it
+// as seen at now.
+func (g *ConsumerGroup) Consumer(name string, now int64) *Consumer {
+	consumer, exists := g.consumers[name]
+	if !exists {
+		consumer = &Consumer{Name: strings.Clone(name)}
+		g.consumers[consumer.Name] = consumer
+		g.stream.size += int64(len(name))
+	}
+	consumer.SeenAt = now
+	return consumer
+}
+
+// CreateConsumer adds the named consumer and reports whether it is new.
+func (g *ConsumerGroup) CreateConsumer(name string, now int64) bool {
+	if _, exists := g.consumers[name]; exists {
+		return false
+	}
+	g.Consumer(name, now)
+	return true
+}
+
+// DeleteConsumer removes a consumer along with its pending entries and
+// returns how many pending entries it had.
+func (g *ConsumerGroup) DeleteConsumer(name string) int {
+	if _, exists := g.consumers[name]; !exists {
+		return 0
+	}
+	
+	removed := 0
+	for id, entry := range g.pending {
+		if entry.Consumer == name {
+			delete(g.pending, id)
+			g.stream.size -= pendingEntrySize
+			removed++
+		}
+	}
+	delete(g.consumers, name)
+	g.stream.size -= int64(len(name))
+	return removed
+}
+
+// Consumers returns the group's consumers sorted by name.
+func (g *ConsumerGroup) Consumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.consumers))
+	for _, consumer := range g.consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+// Deliver records that the entry id was delivered to consumer at now,
+// adding it to the pending entries list or updating it if already there.
+func (g *ConsumerGroup) Deliver(id StreamID, consumer string, now int64) *PendingEntry {
+	entry, exists := g.pending[id]
+	if !exists {
+		entry = &PendingEntry{ID: id}
+		g.pending[id] = entry
+		g.stream.size += pendingEntrySize
+	}
+	if c, exists := g.consumers[consumer]; exists {
+		entry.Consumer = c.Name
+	} else {
+		entry.Consumer = strings.Clone(consumer)
+	}
+	entry.DeliveredAt = now
+	entry.Deliveries++
+	return entry
+}
+
+// Pending returns the pending entry for id, or nil.
+func (g *ConsumerGroup) Pending(id StreamID) *PendingEntry {
+	return g.pending[id]
+}
+
+// Ack removes id from the pending entries list and reports whether it was
+// there.
+func (g *ConsumerGroup) Ack(id StreamID) bool {
+	if _, exists := g.pending[id]; !exists {
+		return false
+	}
+	delete(g.pending, id)
+	g.stream.size -= pendingEntrySize
+	return true
+}
+
+// RangePending calls fn, in ID order, for the pending entries with IDs
+// between start and end inclusive, owned by consumer unless it is empty,
+// until fn returns false.
+func (g *ConsumerGroup) RangePending(start, end StreamID, consumer string, fn func(entry *PendingEntry) bool) {
+	entries := make([]*PendingEntry, 0, len(g.pending))
+	for id, entry := range g.pending {
+		if id.Less(start) || end.Less(id) || (consumer != "" && entry.Consumer != consumer) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID.Less(entries[j].ID)
+	})
+	
+	for _, entry := range entries {
+		if !fn(entry) {
+			return
+		}
+	}
+}
+
+func (g *ConsumerGroup) PendingLen() int {
+	return len(g.pending)
+}
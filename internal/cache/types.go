@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"errors"
+)
+
+var ErrWrongType = errors.New("wrong type")
+
+type ValueType uint8
+
+const (
+	TypeString ValueType = iota
+	TypeHash
+	TypeList
+	TypeSet
+	TypeSortedSet
+	TypeStream
+	TypeJSON
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeSortedSet:
+		return "zset"
+	case TypeStream:
+		return "stream"
+	case TypeJSON:
+		return "ReJSON-RL"
+	default:
+		return "unknown"
+	}
+}
+
+// Object is a non-string value held by an Entry. Objects are only safe to
+// use inside View or Update, under the lock of the shard that owns them.
+type Object interface {
+	Type() ValueType
+	// Size returns the number of bytes the object accounts for against
+	// max_memory.
+	Size() int64
+}
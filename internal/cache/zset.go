@@ -0,0 +1,377 @@
+package cache
+
+import (
+	"math/rand"
+	"strings"
+)
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+// ScoreBound is one end of a score range.
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+// LexBound is one end of a lexicographic range. Inf is -1 or 1 for the
+// unbounded "-" and "+" ends, in which case Member is ignored.
+type LexBound struct {
+	Member    string
+	Exclusive bool
+	Inf       int
+}
+
+// SortedSet orders unique members by score, then by member, using a
+// skiplist with spans for rank queries plus a map for score lookups, the
+// same layout as Redis' zset.
+type SortedSet struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+	scores map[string]float64
+	size   int64
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+		scores: make(map[string]float64),
+	}
+}
+
+func (z *SortedSet) Type() ValueType {
+	return TypeSortedSet
+}
+
+func (z *SortedSet) Size() int64 {
+	return z.size
+}
+
+func (z *SortedSet) Len() int {
+	return z.length
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, exists := z.scores[member]
+	return score, exists
+}
+
+// Add sets the score of member, inserting it if needed, and reports whether
+// it was added. member is copied before being stored, as it may point into
+// a reused buffer.
+func (z *SortedSet) Add(member string, score float64) bool {
+	if old, exists := z.scores[member]; exists {
+		if old != score {
+			z.delete(old, member)
+			member = strings.Clone(member)
+			z.insert(member, score)
+			z.scores[member] = score
+		}
+		return false
+	}
+	
+	member = strings.Clone(member)
+	z.insert(member, score)
+	z.scores[member] = score
+	z.size += int64(len(member)) + 8
+	return true
+}
+
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+	
+	z.delete(score, member)
+	delete(z.scores, member)
+	z.size -= int64(len(member)) + 8
+	return true
+}
+
+// Rank returns the 0-based position of member in ascending order.
+func (z *SortedSet) Rank(member string) (int, bool) {
+	score, exists := z.scores[member]
+	if !exists {
+		return 0, false
+	}
+	
+	rank := 0
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && !less(score, member, next.score, next.member); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = next
+		}
+		if x != z.header && x.member == member {
+			return rank - 1, true
+		}
+	}
+	return 0, false
+}
+
+// RangeByRank calls fn for the members from rank start to stop inclusive,
+// which must already be resolved to valid 0-based ranks, walking backwards
+// from the highest rank if reverse is set.
+func (z *SortedSet) RangeByRank(start, stop int, reverse bool, fn func(member string, score float64) bool) {
+	if reverse {
+		start, stop = z.length-1-start, z.length-1-stop
+	}
+	x := z.byRank(start + 1)
+	for n := 0; x != nil && n <= abs(stop-start); n++ {
+		if !fn(x.member, x.score) {
+			return
+		}
+		x = z.step(x, reverse)
+	}
+}
+
+// RangeByScore calls fn for the members with scores between min and max,
+// in ascending order or descending if reverse is set.
+func (z *SortedSet) RangeByScore(min, max ScoreBound, reverse bool, fn func(member string, score float64) bool) {
+	inRange := func(x *skiplistNode) bool {
+		return aboveMin(x.score, min) && belowMax(x.score, max)
+	}
+	
+	var x *skiplistNode
+	if reverse {
+		x = z.last(func(x *skiplistNode) bool { return belowMax(x.score, max) })
+	} else {
+		x = z.first(func(x *skiplistNode) bool { return aboveMin(x.score, min) })
+	}
+	for ; x != nil && inRange(x); x = z.step(x, reverse) {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// RangeByLex calls fn for the members between min and max by byte order,
+// which is only meaningful when all members share the same score.
+func (z *SortedSet) RangeByLex(min, max LexBound, reverse bool, fn func(member string, score float64) bool) {
+	inRange := func(x *skiplistNode) bool {
+		return aboveLexMin(x.member, min) && belowLexMax(x.member, max)
+	}
+	
+	var x *skiplistNode
+	if reverse {
+		x = z.last(func(x *skiplistNode) bool { return belowLexMax(x.member, max) })
+	} else {
+		x = z.first(func(x *skiplistNode) bool { return aboveLexMin(x.member, min) })
+	}
+	for ; x != nil && inRange(x); x = z.step(x, reverse) {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+func (z *SortedSet) insert(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+	
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for next := x.level[i].forward; next != nil && less(next.score, next.member, score, member); next = x.level[i].forward {
+			rank[i] += x.level[i].span
+			x = next
+		}
+		update[i] = x
+	}
+	
+	level := randomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.header
+			update[i].level[i].span = z.length
+		}
+		z.level = level
+	}
+	
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < z.level; i++ {
+		update[i].level[i].span++
+	}
+	
+	if update[0] != z.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		z.tail = x
+	}
+	z.length++
+}
+
+func (z *SortedSet) delete(score float64, member string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && less(next.score, next.member, score, member); next = x.level[i].forward {
+			x = next
+		}
+		update[i] = x
+	}
+	
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+	
+	for i := 0; i < z.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		z.tail = x.backward
+	}
+	for z.level > 1 && z.header.level[z.level-1].forward == nil {
+		z.level--
+	}
+	z.length--
+}
+
+// byRank returns the node at the 1-based rank, or nil.
+func (z *SortedSet) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank && x != z.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// first returns the first node satisfying ok, which must hold for a suffix
+// of the list.
+func (z *SortedSet) first(ok func(x *skiplistNode) bool) *skiplistNode {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !ok(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// last returns the last node satisfying ok, which must hold for a prefix of
+// the list.
+func (z *SortedSet) last(ok func(x *skiplistNode) bool) *skiplistNode {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && ok(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == z.header {
+		return nil
+	}
+	return x
+}
+
+func (z *SortedSet) step(x *skiplistNode, reverse bool) *skiplistNode {
+	if reverse {
+		return x.backward
+	}
+	return x.level[0].forward
+}
+
+// less reports whether the first member sorts before the second.
+func less(aScore float64, aMember string, bScore float64, bMember string) bool {
+	return aScore < bScore || (aScore == bScore && aMember < bMember)
+}
+
+func aboveMin(score float64, min ScoreBound) bool {
+	if min.Exclusive {
+		return score > min.Score
+	}
+	return score >= min.Score
+}
+
+func belowMax(score float64, max ScoreBound) bool {
+	if max.Exclusive {
+		return score < max.Score
+	}
+	return score <= max.Score
+}
+
+func aboveLexMin(member string, min LexBound) bool {
+	switch {
+	case min.Inf < 0:
+		return true
+	case min.Inf > 0:
+		return false
+	case min.Exclusive:
+		return member > min.Member
+	default:
+		return member >= min.Member
+	}
+}
+
+func belowLexMax(member string, max LexBound) bool {
+	switch {
+	case max.Inf > 0:
+		return true
+	case max.Inf < 0:
+		return false
+	case max.Exclusive:
+		return member < max.Member
+	default:
+		return member <= max.Member
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -28,6 +28,7 @@ type CacheConfig struct {
 	MaxMemory       string        `mapstructure:"max_memory"`
 	EvictionPolicy  string        `mapstructure:"eviction_policy"`
 	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+	Shards          int           `mapstructure:"shards"`
 }
 
 type ClusterConfig struct {
@@ -86,6 +87,7 @@ func setDefaults() {
 	viper.SetDefault("cache.max_memory", "1GB")
 	viper.SetDefault("cache.eviction_policy", "lru")
 	viper.SetDefault("cache.cleanup_interval", "60s")
+	viper.SetDefault("cache.shards", 256)
 	
 	viper.SetDefault("cluster.enabled", false)
 	viper.SetDefault("cluster.replica_count", 1)
@@ -109,11 +111,20 @@ func validate(config *Config) error {
 		return fmt.Errorf("max_connections must be positive")
 	}
 	
-	validPolicies := map[string]bool{"lru": true, "lfu": true, "random": true}
+	validPolicies := map[string]bool{
+		"lru": true, "lfu": true, "random": true,
+		"allkeys-lru": true, "allkeys-lfu": true, "allkeys-random": true,
+		"volatile-lru": true, "volatile-lfu": true, "volatile-random": true, "volatile-ttl": true,
+		"noeviction": true,
+	}
 	if !validPolicies[config.Cache.EvictionPolicy] {
 		return fmt.Errorf("invalid eviction policy: %s", config.Cache.EvictionPolicy)
 	}
 	
+	if config.Cache.Shards <= 0 {
+		return fmt.Errorf("cache shards must be positive")
+	}
+	
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if !validLevels[config.Logging.Level] {
 		return fmt.Errorf("invalid log level: %s", config.Logging.Level)
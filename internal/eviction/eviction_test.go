@@ -0,0 +1,150 @@
+package eviction
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(room(3, "key0"), func() cache.EvictionPolicy { return NewLRU() })
+	
+	for i := 0; i < 3; i++ {
+		c.Set("key"+strconv.Itoa(i), []byte("0123456789"), 0)
+	}
+	c.Get("key0")
+	
+	if err := c.Set("key3", []byte("0123456789"), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	
+	if _, exists := c.Get("key1"); exists {
+		t.Error("key1 should have been evicted")
+	}
+	if _, exists := c.Get("key0"); !exists {
+		t.Error("key0 was recently used and should survive")
+	}
+	if c.Size() > room(3, "key0") {
+		t.Errorf("Cache size %d exceeds max memory", c.Size())
+	}
+}
+
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	c := newCache(room(3, "key0"), func() cache.EvictionPolicy { return NewLFU() })
+	
+	for i := 0; i < 3; i++ {
+		c.Set("key"+strconv.Itoa(i), []byte("0123456789"), 0)
+	}
+	c.Get("key0")
+	c.Get("key0")
+	c.Get("key2")
+	
+	c.Set("key3", []byte("0123456789"), 0)
+	
+	if _, exists := c.Get("key1"); exists {
+		t.Error("key1 should have been evicted")
+	}
+	if c.Count() != 3 {
+		t.Errorf("Expected 3 entries, got %d", c.Count())
+	}
+}
+
+func TestOversizedValueIsRejected(t *testing.T) {
+	c := newCache(8, func() cache.EvictionPolicy { return NewLRU() })
+	
+	if err := c.Set("big", []byte("0123456789"), 0); err != cache.ErrOutOfMemory {
+		t.Errorf("Expected ErrOutOfMemory, got %v", err)
+	}
+	if c.Size() != 0 {
+		t.Errorf("Expected empty cache, got size %d", c.Size())
+	}
+}
+
+func TestVolatileOnlyEvictsKeysWithTTL(t *testing.T) {
+	c := newCache(room(3, "session1"), func() cache.EvictionPolicy { return NewVolatile(NewLRU()) })
+	
+	c.Set("pinned", []byte("0123456789"), 0)
+	c.Set("session1", []byte("0123456789"), time.Hour)
+	c.Set("session2", []byte("0123456789"), time.Hour)
+	
+	if err := c.Set("session3", []byte("0123456789"), time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, exists := c.Get("pinned"); !exists {
+		t.Error("Key without TTL must never be evicted")
+	}
+	if _, exists := c.Get("session1"); exists {
+		t.Error("session1 should have been evicted")
+	}
+	
+	c.Set("session2", []byte("0123456789"), 0)
+	c.Set("session3", []byte("0123456789"), 0)
+	if err := c.Set("config", []byte("0123456789"), 0); err != cache.ErrOutOfMemory {
+		t.Errorf("Expected ErrOutOfMemory without volatile keys, got %v", err)
+	}
+}
+
+func TestVolatileTTLEvictsSoonestExpiring(t *testing.T) {
+	c := newCache(room(3, "pinned"), func() cache.EvictionPolicy { return NewTTL() })
+	
+	c.Set("late", []byte("0123456789"), time.Hour)
+	c.Set("soon", []byte("0123456789"), time.Minute)
+	c.Set("pinned", []byte("0123456789"), 0)
+	c.Set("new", []byte("0123456789"), 2*time.Hour)
+	
+	if _, exists := c.Get("soon"); exists {
+		t.Error("soon should have been evicted first")
+	}
+	if _, exists := c.Get("late"); !exists {
+		t.Error("late should survive")
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	policy, err := New("noeviction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCache(room(2, "a"), policy)
+	
+	c.Set("a", []byte("0123456789"), 0)
+	c.Set("b", []byte("0123456789"), 0)
+	if err := c.Set("c", []byte("x"), 0); err != cache.ErrOutOfMemory {
+		t.Errorf("Expected ErrOutOfMemory, got %v", err)
+	}
+	if err := c.Set("a", []byte("short"), 0); err != nil {
+		t.Errorf("Shrinking an existing key should succeed, got %v", err)
+	}
+}
+
+// newCache uses a single shard so that eviction order is exact.
+func newCache(maxSize int64, newPolicy func() cache.EvictionPolicy) *cache.Cache {
+	return cache.NewSharded(maxSize, 1, newPolicy)
+}
+
+// room is the memory taken by n keys named like key holding ten bytes each.
+func room(n int, key string) int64 {
+	return int64(n) * (&cache.Entry{Key: key, Value: []byte("0123456789")}).Size()
+}
+
+func TestShardedCacheStaysWithinMaxMemory(t *testing.T) {
+	newPolicy, err := New("lru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cache.New(room(100, "key000"), newPolicy)
+	
+	for i := 0; i < 1000; i++ {
+		if err := c.Set("key"+strconv.Itoa(i), []byte("0123456789"), 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if c.Size() > room(100, "key000") {
+			t.Fatalf("Cache size %d exceeds max memory", c.Size())
+		}
+	}
+	if c.Count() != 100 {
+		t.Errorf("Expected 100 entries, got %d", c.Count())
+	}
+}
@@ -2,19 +2,20 @@ package eviction
 
 import (
 	"container/heap"
+	"sync/atomic"
+
 	"github.com/tectix/hpcs/internal/cache"
 )
 
 type LFU struct {
-	maxSize int64
-	items   map[string]*lfuItem
-	heap    *lfuHeap
+	items map[string]*lfuItem
+	heap  *lfuHeap
 }
 
 type lfuItem struct {
 	key      string
 	entry    *cache.Entry
-	useCount int
+	useCount int64
 	index    int
 }
 
@@ -45,25 +46,24 @@ func (h *lfuHeap) Pop() interface{} {
 	return item
 }
 
-func NewLFU(maxSize int64) *LFU {
+func NewLFU() *LFU {
 	h := &lfuHeap{}
 	heap.Init(h)
 	return &LFU{
-		maxSize: maxSize,
-		items:   make(map[string]*lfuItem),
-		heap:    h,
+		items: make(map[string]*lfuItem),
+		heap:  h,
 	}
 }
 
 func (l *LFU) OnGet(key string, entry *cache.Entry) {
 	if item, exists := l.items[key]; exists {
-		item.useCount = entry.UseCount
+		item.useCount = atomic.LoadInt64(&entry.UseCount)
 		heap.Fix(l.heap, item.index)
 	} else {
 		item := &lfuItem{
 			key:      key,
 			entry:    entry,
-			useCount: entry.UseCount,
+			useCount: atomic.LoadInt64(&entry.UseCount),
 		}
 		heap.Push(l.heap, item)
 		l.items[key] = item
@@ -73,13 +73,13 @@ func (l *LFU) OnGet(key string, entry *cache.Entry) {
 func (l *LFU) OnSet(key string, entry *cache.Entry) {
 	if item, exists := l.items[key]; exists {
 		item.entry = entry
-		item.useCount = entry.UseCount
+		item.useCount = atomic.LoadInt64(&entry.UseCount)
 		heap.Fix(l.heap, item.index)
 	} else {
 		item := &lfuItem{
 			key:      key,
 			entry:    entry,
-			useCount: entry.UseCount,
+			useCount: atomic.LoadInt64(&entry.UseCount),
 		}
 		heap.Push(l.heap, item)
 		l.items[key] = item
@@ -93,21 +93,17 @@ func (l *LFU) OnDelete(key string) {
 	}
 }
 
-func (l *LFU) GetVictims(c *cache.Cache) []string {
-	if c.Size() <= l.maxSize {
-		return nil
-	}
-	
+// GetVictims pops victims straight off the heap; the cache deletes every
+// key it returns, so the OnDelete that follows finds nothing to remove.
+func (l *LFU) GetVictims(excess int64) []string {
 	var victims []string
-	currentSize := c.Size()
-	
-	heapCopy := make(lfuHeap, len(*l.heap))
-	copy(heapCopy, *l.heap)
+	freed := int64(0)
 	
-	for len(heapCopy) > 0 && currentSize > l.maxSize {
-		item := heap.Pop(&heapCopy).(*lfuItem)
+	for l.heap.Len() > 0 && freed < excess {
+		item := heap.Pop(l.heap).(*lfuItem)
+		delete(l.items, item.key)
 		victims = append(victims, item.key)
-		currentSize -= int64(len(item.entry.Value))
+		freed += item.entry.Size()
 	}
 	
 	return victims
@@ -6,9 +6,8 @@ import (
 )
 
 type LRU struct {
-	maxSize int64
-	list    *list.List
-	items   map[string]*list.Element
+	list  *list.List
+	items map[string]*list.Element
 }
 
 type lruItem struct {
@@ -16,11 +15,10 @@ type lruItem struct {
 	entry *cache.Entry
 }
 
-func NewLRU(maxSize int64) *LRU {
+func NewLRU() *LRU {
 	return &LRU{
-		maxSize: maxSize,
-		list:    list.New(),
-		items:   make(map[string]*list.Element),
+		list:  list.New(),
+		items: make(map[string]*list.Element),
 	}
 }
 
@@ -52,18 +50,14 @@ func (l *LRU) OnDelete(key string) {
 	}
 }
 
-func (l *LRU) GetVictims(c *cache.Cache) []string {
-	if c.Size() <= l.maxSize {
-		return nil
-	}
-	
+func (l *LRU) GetVictims(excess int64) []string {
 	var victims []string
-	currentSize := c.Size()
+	freed := int64(0)
 	
-	for elem := l.list.Back(); elem != nil && currentSize > l.maxSize; elem = elem.Prev() {
+	for elem := l.list.Back(); elem != nil && freed < excess; elem = elem.Prev() {
 		item := elem.Value.(*lruItem)
 		victims = append(victims, item.key)
-		currentSize -= int64(len(item.entry.Value))
+		freed += item.entry.Size()
 	}
 	
 	return victims
@@ -0,0 +1,41 @@
+package eviction
+
+import (
+	"fmt"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// New returns a constructor for the named policy; the cache calls it once
+// per shard.
+func New(policy string) (func() cache.EvictionPolicy, error) {
+	switch policy {
+	case "lru", "allkeys-lru":
+		return func() cache.EvictionPolicy { return NewLRU() }, nil
+	case "lfu", "allkeys-lfu":
+		return func() cache.EvictionPolicy { return NewLFU() }, nil
+	case "random", "allkeys-random":
+		return func() cache.EvictionPolicy { return NewRandom() }, nil
+	case "volatile-lru":
+		return func() cache.EvictionPolicy { return NewVolatile(NewLRU()) }, nil
+	case "volatile-lfu":
+		return func() cache.EvictionPolicy { return NewVolatile(NewLFU()) }, nil
+	case "volatile-random":
+		return func() cache.EvictionPolicy { return NewVolatile(NewRandom()) }, nil
+	case "volatile-ttl":
+		return func() cache.EvictionPolicy { return NewTTL() }, nil
+	case "noeviction":
+		return func() cache.EvictionPolicy { return NoEviction{} }, nil
+	default:
+		return nil, fmt.Errorf("unsupported eviction policy: %s", policy)
+	}
+}
+
+// NoEviction never offers victims, so writes fail with cache.ErrOutOfMemory
+// once max_memory is reached.
+type NoEviction struct{}
+
+func (NoEviction) OnGet(key string, entry *cache.Entry) {}
+func (NoEviction) OnSet(key string, entry *cache.Entry) {}
+func (NoEviction) OnDelete(key string)                  {}
+func (NoEviction) GetVictims(excess int64) []string     { return nil }
@@ -0,0 +1,66 @@
+package eviction
+
+import (
+	"math/rand"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+type Random struct {
+	keys    []string
+	entries []*cache.Entry
+	index   map[string]int
+}
+
+func NewRandom() *Random {
+	return &Random{
+		index: make(map[string]int),
+	}
+}
+
+func (r *Random) OnGet(key string, entry *cache.Entry) {
+	r.OnSet(key, entry)
+}
+
+func (r *Random) OnSet(key string, entry *cache.Entry) {
+	if i, exists := r.index[key]; exists {
+		r.entries[i] = entry
+		return
+	}
+	r.index[key] = len(r.keys)
+	r.keys = append(r.keys, key)
+	r.entries = append(r.entries, entry)
+}
+
+func (r *Random) OnDelete(key string) {
+	i, exists := r.index[key]
+	if !exists {
+		return
+	}
+	
+	last := len(r.keys) - 1
+	if i != last {
+		r.keys[i] = r.keys[last]
+		r.entries[i] = r.entries[last]
+		r.index[r.keys[i]] = i
+	}
+	r.keys = r.keys[:last]
+	r.entries[last] = nil
+	r.entries = r.entries[:last]
+	delete(r.index, key)
+}
+
+func (r *Random) GetVictims(excess int64) []string {
+	var victims []string
+	freed := int64(0)
+	
+	for len(r.keys) > 0 && freed < excess {
+		i := rand.Intn(len(r.keys))
+		key := r.keys[i]
+		freed += r.entries[i].Size()
+		victims = append(victims, key)
+		r.OnDelete(key)
+	}
+	
+	return victims
+}
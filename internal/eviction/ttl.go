@@ -0,0 +1,99 @@
+package eviction
+
+import (
+	"container/heap"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// TTL evicts the keys closest to expiring first. Keys without an expiry are
+// never tracked, so it only makes sense as volatile-ttl.
+type TTL struct {
+	items map[string]*ttlItem
+	heap  *ttlHeap
+}
+
+type ttlItem struct {
+	key       string
+	entry     *cache.Entry
+	expiresAt int64
+	index     int
+}
+
+type ttlHeap []*ttlItem
+
+func (h ttlHeap) Len() int           { return len(h) }
+func (h ttlHeap) Less(i, j int) bool { return h[i].expiresAt < h[j].expiresAt }
+func (h ttlHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ttlHeap) Push(x interface{}) {
+	item := x.(*ttlItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *ttlHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[0 : n-1]
+	return item
+}
+
+func NewTTL() *TTL {
+	return &TTL{
+		items: make(map[string]*ttlItem),
+		heap:  &ttlHeap{},
+	}
+}
+
+func (t *TTL) OnGet(key string, entry *cache.Entry) {}
+
+func (t *TTL) OnSet(key string, entry *cache.Entry) {
+	if entry.ExpiresAt == 0 {
+		t.OnDelete(key)
+		return
+	}
+	
+	if item, exists := t.items[key]; exists {
+		item.entry = entry
+		item.expiresAt = entry.ExpiresAt
+		heap.Fix(t.heap, item.index)
+		return
+	}
+	
+	item := &ttlItem{
+		key:       key,
+		entry:     entry,
+		expiresAt: entry.ExpiresAt,
+	}
+	heap.Push(t.heap, item)
+	t.items[key] = item
+}
+
+func (t *TTL) OnDelete(key string) {
+	if item, exists := t.items[key]; exists {
+		heap.Remove(t.heap, item.index)
+		delete(t.items, key)
+	}
+}
+
+func (t *TTL) GetVictims(excess int64) []string {
+	var victims []string
+	freed := int64(0)
+	
+	for t.heap.Len() > 0 && freed < excess {
+		item := heap.Pop(t.heap).(*ttlItem)
+		delete(t.items, item.key)
+		victims = append(victims, item.key)
+		freed += item.entry.Size()
+	}
+	
+	return victims
+}
@@ -0,0 +1,37 @@
+package eviction
+
+import (
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// Volatile restricts a policy to keys that carry a TTL, so keys without one
+// are never evicted.
+type Volatile struct {
+	policy cache.EvictionPolicy
+}
+
+func NewVolatile(policy cache.EvictionPolicy) *Volatile {
+	return &Volatile{policy: policy}
+}
+
+func (v *Volatile) OnGet(key string, entry *cache.Entry) {
+	if entry.ExpiresAt > 0 {
+		v.policy.OnGet(key, entry)
+	}
+}
+
+func (v *Volatile) OnSet(key string, entry *cache.Entry) {
+	if entry.ExpiresAt > 0 {
+		v.policy.OnSet(key, entry)
+	} else {
+		v.policy.OnDelete(key)
+	}
+}
+
+func (v *Volatile) OnDelete(key string) {
+	v.policy.OnDelete(key)
+}
+
+func (v *Volatile) GetVictims(excess int64) []string {
+	return v.policy.GetVictims(excess)
+}
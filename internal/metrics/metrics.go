@@ -32,6 +32,13 @@ var (
 		},
 	)
 
+	ExpiredKeys = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "hpcs_expired_keys_total",
+			Help: "Total number of keys removed by active expiration",
+		},
+	)
+
 	RequestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "hpcs_request_duration_seconds",
@@ -61,6 +68,7 @@ func init() {
 		CacheOperations,
 		CacheSize,
 		CacheEntries,
+		ExpiredKeys,
 		RequestDuration,
 		ActiveConnections,
 		TotalConnections,
@@ -90,6 +98,10 @@ func UpdateCacheEntries(count int) {
 	CacheEntries.Set(float64(count))
 }
 
+func RecordExpiredKeys(count int) {
+	ExpiredKeys.Add(float64(count))
+}
+
 func RecordRequestDuration(operation string, duration time.Duration) {
 	RequestDuration.WithLabelValues(operation).Observe(duration.Seconds())
 }
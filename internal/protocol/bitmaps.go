@@ -0,0 +1,576 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errBitOffset    = errors.New("bit offset is not an integer or out of range")
+	errBitValue     = errors.New("bit is not an integer or out of range")
+	errBitfieldType = errors.New("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+)
+
+// maxBitOffset bounds bit offsets so a bitmap never outgrows maxStringLength.
+const maxBitOffset = maxStringLength*8 - 1
+
+// parseBitOffset parses the offset of a field of the given width in bits.
+// With hash set, a "#n" offset counts in fields rather than bits, as BITFIELD
+// allows.
+func parseBitOffset(s string, width int64, hash bool) (int64, error) {
+	multiplier := int64(1)
+	if hash && strings.HasPrefix(s, "#") {
+		s, multiplier = s[1:], width
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (maxBitOffset+1-width)/multiplier {
+		return 0, errBitOffset
+	}
+	return n * multiplier, nil
+}
+
+func (h *CommandHandler) handleSetBit(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("setbit")
+	}
+	
+	offset, err := parseBitOffset(args[1].Str, 1, false)
+	if err != nil {
+		return errorReply(err)
+	}
+	if args[2].Str != "0" && args[2].Str != "1" {
+		return errorReply(errBitValue)
+	}
+	
+	var old byte
+	err = h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			entry = &cache.Entry{}
+		}
+		value := entry.MutableValue(int(offset>>3) + 1)
+		mask := byte(0x80) >> uint(offset&7)
+		if value[offset>>3]&mask != 0 {
+			old = 1
+		}
+		if args[2].Str == "1" {
+			value[offset>>3] |= mask
+		} else {
+			value[offset>>3] &^= mask
+		}
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(old))
+}
+
+func (h *CommandHandler) handleGetBit(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("getbit")
+	}
+	
+	offset, err := parseBitOffset(args[1].Str, 1, false)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var bit uint64
+	err = h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		if err := checkString(entry); err != nil {
+			return err
+		}
+		if entry != nil {
+			bit = getBits(entry.Value, offset, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(bit))
+}
+
+// bitRange resolves the optional start, end and BYTE|BIT arguments shared by
+// BITCOUNT and BITPOS into an inclusive range of bit positions.
+func bitRange(args []Value, length int64) (from, to int64, ok bool, err error) {
+	start, end := int64(0), int64(-1)
+	var err1, err2 error
+	if len(args) > 0 {
+		start, err1 = strconv.ParseInt(args[0].Str, 10, 64)
+	}
+	if len(args) > 1 {
+		end, err2 = strconv.ParseInt(args[1].Str, 10, 64)
+	}
+	if err1 != nil || err2 != nil {
+		return 0, 0, false, errNotInteger
+	}
+	
+	unit := int64(8)
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2].Str) {
+		case "BYTE":
+		case "BIT":
+			unit = 1
+		default:
+			return 0, 0, false, errSyntax
+		}
+	}
+	
+	from, to, ok = clampRange(start, end, length*8/unit)
+	if !ok {
+		return 0, 0, false, nil
+	}
+	return from * unit, to*unit + unit - 1, true, nil
+}
+
+func (h *CommandHandler) handleBitCount(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("bitcount")
+	}
+	if len(args) == 2 || len(args) > 4 {
+		return errorReply(errSyntax)
+	}
+	
+	var count int64
+	err := h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		if err := checkString(entry); err != nil {
+			return err
+		}
+		var value []byte
+		if entry != nil {
+			value = entry.Value
+		}
+		from, to, ok, err := bitRange(args[1:], int64(len(value)))
+		if err != nil {
+			return err
+		}
+		if ok {
+			count = countBits(value, from, to)
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(count)
+}
+
+func (h *CommandHandler) handleBitPos(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("bitpos")
+	}
+	if len(args) > 5 {
+		return errorReply(errSyntax)
+	}
+	
+	n, err := strconv.ParseInt(args[1].Str, 10, 64)
+	if err != nil {
+		return errorReply(errNotInteger)
+	}
+	if n != 0 && n != 1 {
+		return NewError("ERR The bit argument must be 1 or 0.")
+	}
+	bit := byte(n)
+	
+	pos := int64(-1)
+	err = h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		if err := checkString(entry); err != nil {
+			return err
+		}
+		if entry == nil {
+			if bit == 0 {
+				pos = 0
+			}
+			return bitRangeCheck(args[2:])
+		}
+		
+		from, to, ok, err := bitRange(args[2:], int64(len(entry.Value)))
+		if err != nil || !ok {
+			return err
+		}
+		pos = findBit(entry.Value, bit, from, to)
+		// Without an explicit end the string counts as padded with zeros,
+		// so a clear bit is always found just past it.
+		if pos == -1 && bit == 0 && len(args) < 4 {
+			pos = to + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(pos)
+}
+
+// bitRangeCheck validates range arguments that will not be applied.
+func bitRangeCheck(args []Value) error {
+	_, _, _, err := bitRange(args, 0)
+	return err
+}
+
+func (h *CommandHandler) handleBitOp(args []Value) Value {
+	if len(args) < 3 {
+		return wrongArgs("bitop")
+	}
+	
+	op := strings.ToUpper(args[0].Str)
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return NewError("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return errorReply(errSyntax)
+	}
+	
+	var length int
+	err := h.cache.UpdateMulti(valueStrings(args[1:]), func(entries []*cache.Entry) ([]*cache.Entry, error) {
+		sources := make([][]byte, len(entries)-1)
+		for i, entry := range entries[1:] {
+			if err := checkString(entry); err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				sources[i] = entry.Value
+			}
+			if len(sources[i]) > length {
+				length = len(sources[i])
+			}
+		}
+		
+		next := make([]*cache.Entry, len(entries))
+		copy(next, entries)
+		next[0] = nil
+		if length > 0 {
+			next[0] = &cache.Entry{Value: combineBits(op, sources, length)}
+		}
+		return next, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(length))
+}
+
+// combineBits applies a BITOP operation to sources, treating each as padded
+// with zero bytes up to length.
+func combineBits(op string, sources [][]byte, length int) []byte {
+	result := make([]byte, length)
+	copy(result, sources[0])
+	if op == "NOT" {
+		for i := range result {
+			result[i] = ^result[i]
+		}
+		return result
+	}
+	
+	for _, src := range sources[1:] {
+		switch op {
+		case "AND":
+			for i := range result {
+				if i < len(src) {
+					result[i] &= src[i]
+				} else {
+					result[i] = 0
+				}
+			}
+		case "OR":
+			for i, b := range src {
+				result[i] |= b
+			}
+		case "XOR":
+			for i, b := range src {
+				result[i] ^= b
+			}
+		}
+	}
+	return result
+}
+
+type bitfieldOverflow int
+
+const (
+	overflowWrap bitfieldOverflow = iota
+	overflowSat
+	overflowFail
+)
+
+type bitfieldOp struct {
+	name     string
+	signed   bool
+	width    uint
+	offset   int64
+	value    int64
+	overflow bitfieldOverflow
+}
+
+func parseBitfieldType(s string) (bool, uint, error) {
+	if len(s) < 2 {
+		return false, 0, errBitfieldType
+	}
+	signed := s[0] == 'i' || s[0] == 'I'
+	if !signed && s[0] != 'u' && s[0] != 'U' {
+		return false, 0, errBitfieldType
+	}
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 || width > 64 || (!signed && width == 64) {
+		return false, 0, errBitfieldType
+	}
+	return signed, uint(width), nil
+}
+
+func parseBitfieldOps(args []Value) ([]bitfieldOp, error) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		name := strings.ToUpper(args[i].Str)
+		switch name {
+		case "OVERFLOW":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			switch strings.ToUpper(args[i+1].Str) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, errors.New("Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		case "GET":
+			if i+2 >= len(args) {
+				return nil, errSyntax
+			}
+		case "SET", "INCRBY":
+			if i+3 >= len(args) {
+				return nil, errSyntax
+			}
+		default:
+			return nil, errSyntax
+		}
+		
+		op := bitfieldOp{name: name, overflow: overflow}
+		var err error
+		if op.signed, op.width, err = parseBitfieldType(args[i+1].Str); err != nil {
+			return nil, err
+		}
+		if op.offset, err = parseBitOffset(args[i+2].Str, int64(op.width), true); err != nil {
+			return nil, err
+		}
+		i += 3
+		if name != "GET" {
+			if op.value, err = strconv.ParseInt(args[i].Str, 10, 64); err != nil {
+				return nil, errNotInteger
+			}
+			i++
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// read returns the field at the op's offset, sign-extended if it is signed.
+func (op *bitfieldOp) read(value []byte) int64 {
+	return op.truncate(getBits(value, op.offset, op.width))
+}
+
+func (op *bitfieldOp) truncate(v uint64) int64 {
+	shift := 64 - op.width
+	if op.signed {
+		return int64(v<<shift) >> shift
+	}
+	return int64(v << shift >> shift)
+}
+
+// add computes old+incr in the op's type, resolving overflow by its policy.
+// It returns false if the policy is FAIL and the result overflowed.
+func (op *bitfieldOp) add(old, incr int64) (int64, bool) {
+	var min, max int64
+	var up, down bool
+	if op.signed {
+		max = int64(1)<<(op.width-1) - 1
+		min = -max - 1
+		up = incr > 0 && old > max-incr
+		down = incr < 0 && old < min-incr
+	} else {
+		max = int64(1)<<op.width - 1
+		up = incr > 0 && old > max-incr
+		down = incr < 0 && uint64(old) < -uint64(incr)
+	}
+	
+	if up || down {
+		switch op.overflow {
+		case overflowFail:
+			return 0, false
+		case overflowSat:
+			if up {
+				return max, true
+			}
+			return min, true
+		}
+	}
+	return op.truncate(uint64(old) + uint64(incr)), true
+}
+
+func (op *bitfieldOp) apply(value []byte) Value {
+	old := op.read(value)
+	switch op.name {
+	case "GET":
+		return NewInteger(old)
+	case "SET":
+		next, ok := op.add(0, op.value)
+		if !ok {
+			return NewNullBulkString()
+		}
+		setBits(value, op.offset, op.width, uint64(next))
+		return NewInteger(old)
+	default:
+		next, ok := op.add(old, op.value)
+		if !ok {
+			return NewNullBulkString()
+		}
+		setBits(value, op.offset, op.width, uint64(next))
+		return NewInteger(next)
+	}
+}
+
+func (h *CommandHandler) handleBitfield(name string, args []Value, readOnly bool) Value {
+	if len(args) == 0 {
+		return wrongArgs(name)
+	}
+	
+	ops, err := parseBitfieldOps(args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	// size is how many bytes the writes need, zero if there are none.
+	size := 0
+	for _, op := range ops {
+		if op.name == "GET" {
+			continue
+		}
+		if readOnly {
+			return NewError("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		if end := int((op.offset+int64(op.width)-1)>>3) + 1; end > size {
+			size = end
+		}
+	}
+	
+	results := make([]Value, len(ops))
+	if size == 0 {
+		err = h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+			if err := checkString(entry); err != nil {
+				return err
+			}
+			var value []byte
+			if entry != nil {
+				value = entry.Value
+			}
+			for i := range ops {
+				results[i] = ops[i].apply(value)
+			}
+			return nil
+		})
+	} else {
+		err = h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+			if err := checkString(entry); err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				entry = &cache.Entry{}
+			}
+			value := entry.MutableValue(size)
+			for i := range ops {
+				results[i] = ops[i].apply(value)
+			}
+			return entry, nil
+		})
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewArray(results...)
+}
+
+// getBits reads width bits starting at bit offset as an unsigned integer,
+// most significant bit first; bits past the end of value read as zero.
+func getBits(value []byte, offset int64, width uint) uint64 {
+	var v uint64
+	for pos := offset; pos < offset+int64(width); pos++ {
+		v <<= 1
+		if pos>>3 < int64(len(value)) {
+			v |= uint64(value[pos>>3]>>(7-uint(pos&7))) & 1
+		}
+	}
+	return v
+}
+
+func setBits(value []byte, offset int64, width uint, v uint64) {
+	for i := uint(0); i < width; i++ {
+		pos := offset + int64(i)
+		mask := byte(0x80) >> uint(pos&7)
+		if v>>(width-1-i)&1 != 0 {
+			value[pos>>3] |= mask
+		} else {
+			value[pos>>3] &^= mask
+		}
+	}
+}
+
+// countBits counts the set bits between the inclusive bit positions from and to.
+func countBits(value []byte, from, to int64) int64 {
+	first, last := from>>3, to>>3
+	head := byte(0xff) >> uint(from&7)
+	tail := byte(0xff) << uint(7-to&7)
+	if first == last {
+		return int64(bits.OnesCount8(value[first] & head & tail))
+	}
+	
+	n := bits.OnesCount8(value[first]&head) + bits.OnesCount8(value[last]&tail)
+	middle := value[first+1 : last]
+	for len(middle) >= 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(middle))
+		middle = middle[8:]
+	}
+	for _, b := range middle {
+		n += bits.OnesCount8(b)
+	}
+	return int64(n)
+}
+
+// findBit returns the position of the first bit equal to bit between the
+// inclusive positions from and to, or -1.
+func findBit(value []byte, bit byte, from, to int64) int64 {
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for pos := from; pos <= to; {
+		b := value[pos>>3]
+		if pos&7 == 0 && pos+7 <= to && b == skip {
+			pos += 8
+			continue
+		}
+		if b>>(7-uint(pos&7))&1 == bit {
+			return pos
+		}
+		pos++
+	}
+	return -1
+}
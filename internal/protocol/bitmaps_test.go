@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func TestSetBitAndGetBit(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"SETBIT", "b", "7", "1"}, ":0"},
+		{[]string{"GET", "b"}, "\x01"},
+		{[]string{"SETBIT", "b", "7", "0"}, ":1"},
+		{[]string{"SETBIT", "b", "7", "1"}, ":0"},
+		{[]string{"GETBIT", "b", "7"}, ":1"},
+		{[]string{"GETBIT", "b", "6"}, ":0"},
+		{[]string{"GETBIT", "b", "100"}, ":0"},
+		{[]string{"GETBIT", "missing", "0"}, ":0"},
+		{[]string{"SETBIT", "b", "17", "1"}, ":0"},
+		{[]string{"GET", "b"}, "\x01\x00\x40"},
+		{[]string{"SETBIT", "b", "-1", "1"}, "-ERR bit offset is not an integer or out of range"},
+		{[]string{"SETBIT", "b", "4294967296", "1"}, "-ERR bit offset is not an integer or out of range"},
+		{[]string{"GETBIT", "b", "x"}, "-ERR bit offset is not an integer or out of range"},
+		{[]string{"SETBIT", "b", "0", "2"}, "-ERR bit is not an integer or out of range"},
+		{[]string{"SETBIT", "b", "0"}, "-ERR wrong number of arguments for 'setbit' command"},
+		{[]string{"HSET", "h", "f", "v"}, ":1"},
+		{[]string{"SETBIT", "h", "0", "1"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
+
+func TestBitCountAndBitPos(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"SET", "k", "foobar"}, "+OK"},
+		{[]string{"BITCOUNT", "k"}, ":26"},
+		{[]string{"BITCOUNT", "k", "0", "0"}, ":4"},
+		{[]string{"BITCOUNT", "k", "1", "1"}, ":6"},
+		{[]string{"BITCOUNT", "k", "1", "1", "BYTE"}, ":6"},
+		{[]string{"BITCOUNT", "k", "5", "30", "BIT"}, ":17"},
+		{[]string{"BITCOUNT", "k", "-2", "-1"}, ":7"},
+		{[]string{"BITCOUNT", "k", "-5", "-1", "bit"}, ":2"},
+		{[]string{"BITCOUNT", "k", "10", "20"}, ":0"},
+		{[]string{"BITCOUNT", "k", "3", "1"}, ":0"},
+		{[]string{"BITCOUNT", "missing"}, ":0"},
+		{[]string{"BITCOUNT", "k", "0"}, "-ERR syntax error"},
+		{[]string{"BITCOUNT", "k", "0", "1", "WORD"}, "-ERR syntax error"},
+		{[]string{"BITCOUNT", "k", "a", "1"}, "-ERR value is not an integer or out of range"},
+		{[]string{"BITCOUNT"}, "-ERR wrong number of arguments for 'bitcount' command"},
+		
+		{[]string{"SET", "p", "\xff\xf0\x00"}, "+OK"},
+		{[]string{"BITPOS", "p", "0"}, ":12"},
+		{[]string{"SET", "p", "\x00\xff\xf0"}, "+OK"},
+		{[]string{"BITPOS", "p", "1", "0"}, ":8"},
+		{[]string{"BITPOS", "p", "1", "2"}, ":16"},
+		{[]string{"BITPOS", "p", "1", "2", "-1", "BYTE"}, ":16"},
+		{[]string{"BITPOS", "p", "1", "7", "15", "BIT"}, ":8"},
+		{[]string{"BITPOS", "p", "0", "8", "-1", "BIT"}, ":20"},
+		{[]string{"SET", "p", "\x00\x00\x00"}, "+OK"},
+		{[]string{"BITPOS", "p", "1"}, ":-1"},
+		{[]string{"BITPOS", "p", "1", "7", "-3", "BIT"}, ":-1"},
+		
+		// Without an end, a string of set bits counts as padded with zeros.
+		{[]string{"SET", "ones", "\xff\xff"}, "+OK"},
+		{[]string{"BITPOS", "ones", "0"}, ":16"},
+		{[]string{"BITPOS", "ones", "0", "1"}, ":16"},
+		{[]string{"BITPOS", "ones", "0", "0", "-1"}, ":-1"},
+		{[]string{"BITPOS", "missing", "0"}, ":0"},
+		{[]string{"BITPOS", "missing", "1"}, ":-1"},
+		{[]string{"BITPOS", "k", "2"}, "-ERR The bit argument must be 1 or 0."},
+		{[]string{"BITPOS", "k", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"BITPOS", "k", "1", "0", "1", "BITS"}, "-ERR syntax error"},
+		{[]string{"BITPOS", "missing", "1", "0", "1", "WORD"}, "-ERR syntax error"},
+		{[]string{"BITPOS", "k", "1", "0", "1", "BIT", "extra"}, "-ERR syntax error"},
+		{[]string{"BITPOS", "k"}, "-ERR wrong number of arguments for 'bitpos' command"},
+	})
+}
+
+func TestBitOp(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"SET", "a", "\xff\x0f"}, "+OK"},
+		{[]string{"SET", "b", "\xf0"}, "+OK"},
+		
+		// The shorter key counts as padded with zero bytes.
+		{[]string{"BITOP", "AND", "dest", "a", "b"}, ":2"},
+		{[]string{"GET", "dest"}, "\xf0\x00"},
+		{[]string{"BITOP", "OR", "dest", "a", "b"}, ":2"},
+		{[]string{"GET", "dest"}, "\xff\x0f"},
+		{[]string{"BITOP", "XOR", "dest", "a", "b"}, ":2"},
+		{[]string{"GET", "dest"}, "\x0f\x0f"},
+		{[]string{"BITOP", "NOT", "dest", "b"}, ":1"},
+		{[]string{"GET", "dest"}, "\x0f"},
+		{[]string{"BITOP", "and", "dest", "a", "missing"}, ":2"},
+		{[]string{"GET", "dest"}, "\x00\x00"},
+		{[]string{"BITOP", "OR", "a", "a", "b", "b"}, ":2"},
+		{[]string{"GET", "a"}, "\xff\x0f"},
+		
+		// An empty result deletes the destination.
+		{[]string{"BITOP", "AND", "dest", "missing", "other"}, ":0"},
+		{[]string{"EXISTS", "dest"}, ":0"},
+		
+		{[]string{"BITOP", "NOT", "dest", "a", "b"}, "-ERR BITOP NOT must be called with a single source key."},
+		{[]string{"BITOP", "NAND", "dest", "a"}, "-ERR syntax error"},
+		{[]string{"HSET", "h", "f", "v"}, ":1"},
+		{[]string{"BITOP", "OR", "dest", "a", "h"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{[]string{"BITOP", "OR", "dest"}, "-ERR wrong number of arguments for 'bitop' command"},
+	})
+}
+
+func TestBitfield(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	typeError := "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."
+	runSteps(t, handler, []commandStep{
+		{[]string{"BITFIELD", "u", "SET", "u8", "0", "255", "GET", "u8", "0"}, "[:0 :255]"},
+		{[]string{"BITFIELD", "u", "INCRBY", "u8", "0", "1"}, "[:0]"},
+		{[]string{"BITFIELD", "u", "OVERFLOW", "SAT", "SET", "u8", "0", "300", "GET", "u8", "0"}, "[:0 :255]"},
+		{[]string{"BITFIELD", "u", "OVERFLOW", "FAIL", "INCRBY", "u8", "0", "1", "GET", "u8", "0"}, "[(nil) :255]"},
+		{[]string{"BITFIELD", "u", "OVERFLOW", "SAT", "INCRBY", "u8", "0", "-300"}, "[:0]"},
+		{[]string{"BITFIELD", "u", "OVERFLOW", "WRAP", "INCRBY", "u8", "0", "-1"}, "[:255]"},
+		{[]string{"BITFIELD", "u", "SET", "u8", "0", "300"}, "[:255]"},
+		{[]string{"BITFIELD", "u", "GET", "u8", "0"}, "[:44]"},
+		{[]string{"BITFIELD", "u", "OVERFLOW", "FAIL", "SET", "u8", "0", "256", "GET", "u8", "0"}, "[(nil) :44]"},
+		
+		// OVERFLOW applies to the subcommands after it.
+		{[]string{"BITFIELD", "o", "SET", "u8", "0", "250", "OVERFLOW", "SAT", "INCRBY", "u8", "0", "10", "OVERFLOW", "WRAP", "INCRBY", "u8", "0", "10"}, "[:0 :255 :9]"},
+		
+		{[]string{"BITFIELD", "i", "SET", "i8", "0", "127", "INCRBY", "i8", "0", "1"}, "[:0 :-128]"},
+		{[]string{"BITFIELD", "i", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "-1000", "INCRBY", "i8", "0", "1000"}, "[:-128 :127]"},
+		{[]string{"BITFIELD", "i", "OVERFLOW", "FAIL", "INCRBY", "i8", "0", "1", "INCRBY", "i8", "0", "-255", "INCRBY", "i8", "0", "-127"}, "[(nil) :-128 (nil)]"},
+		{[]string{"BITFIELD", "i", "SET", "i8", "0", "200", "GET", "i8", "0", "GET", "u8", "0"}, "[:-128 :-56 :200]"},
+		
+		// Fields need not be byte aligned, and #n counts in fields.
+		{[]string{"BITFIELD", "w", "SET", "i5", "3", "-1", "GET", "u8", "0", "GET", "i5", "3", "GET", "u5", "3"}, "[:0 :31 :-1 :31]"},
+		{[]string{"BITFIELD", "w", "SET", "u4", "#3", "9", "GET", "u8", "8", "GET", "u4", "12"}, "[:0 :9 :9]"},
+		{[]string{"BITFIELD", "n", "SET", "u8", "#1", "200", "GET", "u8", "8", "GET", "u8", "#1"}, "[:0 :200 :200]"},
+		{[]string{"STRLEN", "n"}, ":2"},
+		
+		{[]string{"BITFIELD", "l", "SET", "i64", "0", "-1", "GET", "i64", "0", "GET", "u63", "0"}, "[:0 :-1 :9223372036854775807]"},
+		{[]string{"BITFIELD", "l", "SET", "i64", "0", "9223372036854775807", "OVERFLOW", "FAIL", "INCRBY", "i64", "0", "1"}, "[:-1 (nil)]"},
+		{[]string{"BITFIELD", "l", "INCRBY", "i64", "0", "1"}, "[:-9223372036854775808]"},
+		{[]string{"BITFIELD", "l", "OVERFLOW", "SAT", "INCRBY", "i64", "0", "-1"}, "[:-9223372036854775808]"},
+		
+		// Reads alone never create the key.
+		{[]string{"BITFIELD", "missing", "GET", "u8", "0"}, "[:0]"},
+		{[]string{"BITFIELD", "missing"}, "[]"},
+		{[]string{"EXISTS", "missing"}, ":0"},
+		{[]string{"BITFIELD_RO", "u", "GET", "u8", "0"}, "[:44]"},
+		{[]string{"BITFIELD_RO", "u", "SET", "u8", "0", "1"}, "-ERR BITFIELD_RO only supports the GET subcommand"},
+		
+		{[]string{"BITFIELD", "k", "GET", "u64", "0"}, typeError},
+		{[]string{"BITFIELD", "k", "GET", "i65", "0"}, typeError},
+		{[]string{"BITFIELD", "k", "GET", "i0", "0"}, typeError},
+		{[]string{"BITFIELD", "k", "GET", "x8", "0"}, typeError},
+		{[]string{"BITFIELD", "k", "GET", "u", "0"}, typeError},
+		{[]string{"BITFIELD", "k", "GET", "u8", "-1"}, "-ERR bit offset is not an integer or out of range"},
+		{[]string{"BITFIELD", "k", "GET", "u8", "#-1"}, "-ERR bit offset is not an integer or out of range"},
+		{[]string{"BITFIELD", "k", "GET", "u8", "4294967289"}, "-ERR bit offset is not an integer or out of range"},
+		{[]string{"BITFIELD", "k", "OVERFLOW", "BAD"}, "-ERR Invalid OVERFLOW type specified"},
+		{[]string{"BITFIELD", "k", "OVERFLOW"}, "-ERR syntax error"},
+		{[]string{"BITFIELD", "k", "GET", "u8"}, "-ERR syntax error"},
+		{[]string{"BITFIELD", "k", "SET", "u8", "0"}, "-ERR syntax error"},
+		{[]string{"BITFIELD", "k", "FOO", "u8", "0"}, "-ERR syntax error"},
+		{[]string{"BITFIELD", "k", "SET", "u8", "0", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"BITFIELD"}, "-ERR wrong number of arguments for 'bitfield' command"},
+		{[]string{"EXISTS", "k"}, ":0"},
+	})
+}
@@ -0,0 +1,170 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errTimeoutNotFloat = errors.New("timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("timeout is negative")
+)
+
+// blockedClients parks clients running blocking commands and serves them in
+// FIFO order, per key, as writes make their keys ready.
+type blockedClients struct {
+	mu      sync.Mutex
+	waiters map[string][]*waiter
+	ready   []string
+	
+	// count is read without mu so writers can skip signaling when nobody is
+	// blocked.
+	count int64
+}
+
+// waiter is one blocked client. serve tries to complete its command against
+// key, reporting false if the key cannot satisfy it yet. A waiter that
+// drains takes what it is served from the key, as list pops do, so once one
+// fails no waiter queued behind it on that key can succeed either.
+type waiter struct {
+	keys   []string
+	drains bool
+	serve  func(key string) (Value, bool)
+	reply  chan Value
+}
+
+func newBlockedClients() *blockedClients {
+	return &blockedClients{
+		waiters: make(map[string][]*waiter),
+	}
+}
+
+// block serves the command immediately if possible, otherwise it waits until
+// a write to one of keys lets serve succeed, the timeout elapses or ctx is
+// done. A zero timeout waits forever.
+func (b *blockedClients) block(ctx context.Context, keys []string, timeout time.Duration, drains bool, serve func(key string) (Value, bool)) (Value, bool) {
+	w := &waiter{keys: keys, drains: drains, serve: serve, reply: make(chan Value, 1)}
+	
+	atomic.AddInt64(&b.count, 1)
+	b.mu.Lock()
+	for _, key := range keys {
+		// A draining newcomer lines up behind the clients already waiting
+		// on a key rather than taking what is meant for them.
+		if drains && len(b.waiters[key]) > 0 {
+			continue
+		}
+		if reply, ok := serve(key); ok {
+			b.drain()
+			b.mu.Unlock()
+			atomic.AddInt64(&b.count, -1)
+			return reply, true
+		}
+	}
+	// A parked waiter outlives the request naming its keys, whose strings
+	// the parser reuses.
+	w.keys = make([]string, len(keys))
+	for i, key := range keys {
+		w.keys[i] = strings.Clone(key)
+		b.waiters[w.keys[i]] = append(b.waiters[w.keys[i]], w)
+	}
+	b.mu.Unlock()
+	
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	
+	select {
+	case reply := <-w.reply:
+		return reply, true
+	case <-expired:
+	case <-ctx.Done():
+	}
+	
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case reply := <-w.reply:
+		return reply, true
+	default:
+		b.remove(w)
+		return Value{}, false
+	}
+}
+
+// signal serves the clients blocked on key after a write to it.
+func (b *blockedClients) signal(key string) {
+	if atomic.LoadInt64(&b.count) == 0 {
+		return
+	}
+	
+	b.mu.Lock()
+	b.ready = append(b.ready, key)
+	b.drain()
+	b.mu.Unlock()
+}
+
+// markReady queues key to be served once the current serve returns. It must
+// only be called from within a waiter's serve function.
+func (b *blockedClients) markReady(key string) {
+	b.ready = append(b.ready, key)
+}
+
+func (b *blockedClients) drain() {
+	for len(b.ready) > 0 {
+		key := b.ready[0]
+		b.ready = b.ready[1:]
+		
+		for i := 0; i < len(b.waiters[key]); {
+			w := b.waiters[key][i]
+			reply, ok := w.serve(key)
+			if !ok {
+				if w.drains {
+					break
+				}
+				i++
+				continue
+			}
+			b.remove(w)
+			w.reply <- reply
+		}
+	}
+	b.ready = nil
+}
+
+func (b *blockedClients) remove(w *waiter) {
+	for _, key := range w.keys {
+		queue := b.waiters[key]
+		kept := queue[:0]
+		for _, other := range queue {
+			if other != w {
+				kept = append(kept, other)
+			}
+		}
+		if len(kept) == 0 {
+			delete(b.waiters, key)
+		} else {
+			b.waiters[key] = kept
+		}
+	}
+	atomic.AddInt64(&b.count, -1)
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(seconds) || seconds > float64(1<<62)/float64(time.Second) {
+		return 0, errTimeoutNotFloat
+	}
+	if seconds < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
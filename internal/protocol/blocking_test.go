@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// executeContext runs a command given as plain strings under ctx.
+func executeContext(ctx context.Context, handler *CommandHandler, args ...string) Value {
+	cmd := make([]Value, len(args))
+	for i, arg := range args {
+		cmd[i] = NewBulkString(arg)
+	}
+	return handler.ExecuteSession(ctx, NewSession(), NewArray(cmd...))
+}
+
+// waitBlocked waits until n clients are parked on key.
+func waitBlocked(t *testing.T, handler *CommandHandler, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		handler.blocked.mu.Lock()
+		parked := len(handler.blocked.waiters[key])
+		handler.blocked.mu.Unlock()
+		if parked == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d clients blocked on %q, got %d", n, key, parked)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBlockingPopServesClientsInOrder(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024, nil))
+	
+	replies := make([]chan Value, 3)
+	for i := range replies {
+		replies[i] = make(chan Value, 1)
+		go func(reply chan Value) {
+			reply <- executeContext(context.Background(), handler, "BLPOP", "list", "0")
+		}(replies[i])
+		waitBlocked(t, handler, "list", i+1)
+	}
+	
+	executeContext(context.Background(), handler, "RPUSH", "list", "a", "b", "c")
+	for i, want := range []string{"a", "b", "c"} {
+		select {
+		case reply := <-replies[i]:
+			if len(reply.Array) != 2 || reply.Array[0].Str != "list" || reply.Array[1].Str != want {
+				t.Errorf("Client %d: got %+v, want list %q", i, reply, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Client %d was never served", i)
+		}
+	}
+	
+	// A client arriving while others wait lines up behind them.
+	go func() {
+		replies[0] <- executeContext(context.Background(), handler, "BRPOP", "list", "0")
+	}()
+	waitBlocked(t, handler, "list", 1)
+	go func() {
+		replies[1] <- executeContext(context.Background(), handler, "BLPOP", "other", "list", "0")
+	}()
+	waitBlocked(t, handler, "list", 2)
+	executeContext(context.Background(), handler, "RPUSH", "list", "d")
+	if reply := <-replies[0]; len(reply.Array) != 2 || reply.Array[1].Str != "d" {
+		t.Errorf("Expected the first client to get d, got %+v", reply)
+	}
+	executeContext(context.Background(), handler, "RPUSH", "other", "e")
+	if reply := <-replies[1]; len(reply.Array) != 2 || reply.Array[0].Str != "other" || reply.Array[1].Str != "e" {
+		t.Errorf("Expected the second client to get e from other, got %+v", reply)
+	}
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024, nil))
+	
+	start := time.Now()
+	reply := executeContext(context.Background(), handler, "BLPOP", "list", "0.05")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("BLPOP returned after %v, before its timeout", elapsed)
+	}
+	if got := string(reply.Marshal()); got != "*-1\r\n" {
+		t.Errorf("Expected a null array on timeout, got %q", got)
+	}
+	waitBlocked(t, handler, "list", 0)
+	
+	// Nobody is left waiting to take what is pushed next.
+	executeContext(context.Background(), handler, "RPUSH", "list", "a")
+	if reply := executeContext(context.Background(), handler, "LLEN", "list"); reply.Int != 1 {
+		t.Errorf("Expected the pushed element to stay in the list, got length %d", reply.Int)
+	}
+	
+	for _, timeout := range []string{"-1", "soon", "nan"} {
+		if reply := executeContext(context.Background(), handler, "BLPOP", "list", timeout); reply.Type != Error {
+			t.Errorf("BLPOP with timeout %q: expected an error, got %+v", timeout, reply)
+		}
+	}
+}
+
+func TestBlockingPopCancelledOnDisconnect(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024, nil))
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	reply := make(chan Value, 1)
+	go func() {
+		reply <- executeContext(ctx, handler, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	}()
+	waitBlocked(t, handler, "src", 1)
+	
+	cancel()
+	select {
+	case got := <-reply:
+		if got.Type != Array || !got.Null {
+			t.Errorf("Expected a null array once cancelled, got %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BLMOVE did not return once its context was cancelled")
+	}
+	waitBlocked(t, handler, "src", 0)
+	
+	executeContext(context.Background(), handler, "RPUSH", "src", "a")
+	if got := executeContext(context.Background(), handler, "LLEN", "src"); got.Int != 1 {
+		t.Errorf("Expected a cancelled client not to take the element, src has length %d", got.Int)
+	}
+	if got := executeContext(context.Background(), handler, "EXISTS", "dst"); got.Int != 0 {
+		t.Error("Expected a cancelled client not to move anything to dst")
+	}
+}
+
+func TestBlockingPopSkipsOnlyContendedKeys(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024, nil))
+	
+	waiting := make(chan Value, 1)
+	go func() {
+		waiting <- executeContext(context.Background(), handler, "BLPOP", "empty", "0")
+	}()
+	waitBlocked(t, handler, "empty", 1)
+	
+	executeContext(context.Background(), handler, "RPUSH", "full", "x")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply := executeContext(ctx, handler, "BLPOP", "empty", "full", "0")
+	if len(reply.Array) != 2 || reply.Array[0].Str != "full" || reply.Array[1].Str != "x" {
+		t.Errorf("Expected the element of the uncontended key, got %+v", reply)
+	}
+	
+	// The first client keeps its place on the contended key.
+	executeContext(context.Background(), handler, "RPUSH", "empty", "y")
+	if reply := <-waiting; len(reply.Array) != 2 || reply.Array[1].Str != "y" {
+		t.Errorf("Expected the first client to get y, got %+v", reply)
+	}
+}
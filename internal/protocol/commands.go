@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"context"
+	"errors"
 	"strconv"
 	"strings"
 	"time"
@@ -13,24 +15,46 @@ type CommandExecutor interface {
 }
 
 type CommandHandler struct {
-	cache *cache.Cache
+	cache   *cache.Cache
+	blocked *blockedClients
 }
 
 func NewCommandHandler(cache *cache.Cache) *CommandHandler {
 	return &CommandHandler{
-		cache: cache,
+		cache:   cache,
+		blocked: newBlockedClients(),
 	}
 }
 
 func (h *CommandHandler) Execute(cmd Value) Value {
+	return h.ExecuteContext(context.Background(), cmd)
+}
+
+// ExecuteContext runs cmd, giving up on blocking commands once ctx is done.
+func (h *CommandHandler) ExecuteContext(ctx context.Context, cmd Value) Value {
+	return h.ExecuteSession(ctx, &Session{Protocol: 2}, cmd)
+}
+
+// ExecuteSession runs cmd on behalf of the connection session belongs to,
+// giving up on blocking commands once ctx is done.
+func (h *CommandHandler) ExecuteSession(ctx context.Context, session *Session, cmd Value) Value {
 	if cmd.Type != Array || len(cmd.Array) == 0 {
 		return NewError("ERR wrong number of arguments")
 	}
 
-	command := strings.ToUpper(cmd.Array[0].Str)
+	var buf [32]byte
+	command := upperASCII(buf[:0], cmd.Array[0].Str)
 	args := cmd.Array[1:]
 
-	switch command {
+	if growsMemory[string(command)] && len(args) > 0 {
+		if err := h.cache.CheckMemory(args[0].Str); err != nil {
+			return errorReply(err)
+		}
+	}
+
+	switch string(command) {
+	case "HELLO":
+		return h.handleHello(session, args)
 	case "GET":
 		return h.handleGet(args)
 	case "SET":
@@ -41,14 +65,314 @@ func (h *CommandHandler) Execute(cmd Value) Value {
 		return h.handleExists(args)
 	case "KEYS":
 		return h.handleKeys(args)
+	case "SCAN":
+		return h.handleScan(args)
+	case "TYPE":
+		return h.handleType(args)
 	case "FLUSHALL":
 		return h.handleFlushAll(args)
 	case "PING":
 		return h.handlePing(args)
 	case "INFO":
 		return h.handleInfo(args)
+	case "EXPIRE":
+		return h.handleExpire("expire", args, time.Second, false)
+	case "PEXPIRE":
+		return h.handleExpire("pexpire", args, time.Millisecond, false)
+	case "EXPIREAT":
+		return h.handleExpire("expireat", args, time.Second, true)
+	case "PEXPIREAT":
+		return h.handleExpire("pexpireat", args, time.Millisecond, true)
+	case "TTL":
+		return h.handleTTL("ttl", args, time.Second)
+	case "PTTL":
+		return h.handleTTL("pttl", args, time.Millisecond)
+	case "PERSIST":
+		return h.handlePersist(args)
+	case "INCR":
+		return h.handleIncr("incr", args, 1, false)
+	case "DECR":
+		return h.handleIncr("decr", args, -1, false)
+	case "INCRBY":
+		return h.handleIncr("incrby", args, 1, true)
+	case "DECRBY":
+		return h.handleIncr("decrby", args, -1, true)
+	case "INCRBYFLOAT":
+		return h.handleIncrByFloat(args)
+	case "MGET":
+		return h.handleMGet(args)
+	case "MSET":
+		return h.handleMSet("mset", args, false)
+	case "MSETNX":
+		return h.handleMSet("msetnx", args, true)
+	case "GETSET":
+		return h.handleGetSet(args)
+	case "GETDEL":
+		return h.handleGetDel(args)
+	case "GETEX":
+		return h.handleGetEx(args)
+	case "APPEND":
+		return h.handleAppend(args)
+	case "STRLEN":
+		return h.handleStrLen(args)
+	case "SETRANGE":
+		return h.handleSetRange(args)
+	case "GETRANGE":
+		return h.handleGetRange(args)
+	case "SETBIT":
+		return h.handleSetBit(args)
+	case "GETBIT":
+		return h.handleGetBit(args)
+	case "BITCOUNT":
+		return h.handleBitCount(args)
+	case "BITPOS":
+		return h.handleBitPos(args)
+	case "BITOP":
+		return h.handleBitOp(args)
+	case "BITFIELD":
+		return h.handleBitfield("bitfield", args, false)
+	case "BITFIELD_RO":
+		return h.handleBitfield("bitfield_ro", args, true)
+	case "PFADD":
+		return h.handlePFAdd(args)
+	case "PFCOUNT":
+		return h.handlePFCount(args)
+	case "PFMERGE":
+		return h.handlePFMerge(args)
+	case "GEOADD":
+		return h.handleGeoAdd(args)
+	case "GEODIST":
+		return h.handleGeoDist(args)
+	case "GEOPOS":
+		return h.handleGeoPos(args)
+	case "GEOSEARCH":
+		return h.handleGeoSearch(args)
+	case "JSON.SET":
+		return h.handleJSONSet(args)
+	case "JSON.GET":
+		return h.handleJSONGet(args)
+	case "JSON.DEL":
+		return h.handleJSONDel(args)
+	case "JSON.NUMINCRBY":
+		return h.handleJSONNumIncrBy(args)
+	case "JSON.ARRAPPEND":
+		return h.handleJSONArrAppend(args)
+	case "HSET":
+		return h.handleHSet("hset", args)
+	case "HMSET":
+		return h.handleHSet("hmset", args)
+	case "HSETNX":
+		return h.handleHSetNX(args)
+	case "HGET":
+		return h.handleHGet(args)
+	case "HMGET":
+		return h.handleHMGet(args)
+	case "HDEL":
+		return h.handleHDel(args)
+	case "HGETALL":
+		return h.handleHGetAll(args)
+	case "HKEYS":
+		return h.handleHKeys("hkeys", args, false)
+	case "HVALS":
+		return h.handleHKeys("hvals", args, true)
+	case "HLEN":
+		return h.handleHLen(args)
+	case "HEXISTS":
+		return h.handleHExists(args)
+	case "HSTRLEN":
+		return h.handleHStrLen(args)
+	case "HINCRBY":
+		return h.handleHIncrBy(args)
+	case "HINCRBYFLOAT":
+		return h.handleHIncrByFloat(args)
+	case "HSCAN":
+		return h.handleHScan(args)
+	case "LPUSH":
+		return h.handlePush("lpush", args, true, false)
+	case "RPUSH":
+		return h.handlePush("rpush", args, false, false)
+	case "LPUSHX":
+		return h.handlePush("lpushx", args, true, true)
+	case "RPUSHX":
+		return h.handlePush("rpushx", args, false, true)
+	case "LPOP":
+		return h.handlePop("lpop", args, true)
+	case "RPOP":
+		return h.handlePop("rpop", args, false)
+	case "LLEN":
+		return h.handleLLen(args)
+	case "LRANGE":
+		return h.handleLRange(args)
+	case "LTRIM":
+		return h.handleLTrim(args)
+	case "LINDEX":
+		return h.handleLIndex(args)
+	case "LSET":
+		return h.handleLSet(args)
+	case "LMOVE":
+		return h.handleLMove(args)
+	case "RPOPLPUSH":
+		return h.handleRPopLPush(args)
+	case "BLPOP":
+		return h.handleBPop(ctx, "blpop", args, true)
+	case "BRPOP":
+		return h.handleBPop(ctx, "brpop", args, false)
+	case "BLMOVE":
+		return h.handleBLMove(ctx, args)
+	case "BRPOPLPUSH":
+		return h.handleBRPopLPush(ctx, args)
+	case "SADD":
+		return h.handleSAdd(args)
+	case "SREM":
+		return h.handleSRem(args)
+	case "SMEMBERS":
+		return h.handleSMembers(args)
+	case "SISMEMBER":
+		return h.handleSIsMember(args)
+	case "SMISMEMBER":
+		return h.handleSMIsMember(args)
+	case "SCARD":
+		return h.handleSCard(args)
+	case "SUNION":
+		return h.handleSetOp("sunion", args, setUnion)
+	case "SINTER":
+		return h.handleSetOp("sinter", args, setInter)
+	case "SDIFF":
+		return h.handleSetOp("sdiff", args, setDiff)
+	case "SUNIONSTORE":
+		return h.handleSetOpStore("sunionstore", args, setUnion)
+	case "SINTERSTORE":
+		return h.handleSetOpStore("sinterstore", args, setInter)
+	case "SDIFFSTORE":
+		return h.handleSetOpStore("sdiffstore", args, setDiff)
+	case "SPOP":
+		return h.handleSPop(args)
+	case "SRANDMEMBER":
+		return h.handleSRandMember(args)
+	case "SSCAN":
+		return h.handleSScan(args)
+	case "ZADD":
+		return h.handleZAdd(args)
+	case "ZINCRBY":
+		return h.handleZIncrBy(args)
+	case "ZREM":
+		return h.handleZRem(args)
+	case "ZSCORE":
+		return h.handleZScore(args)
+	case "ZCARD":
+		return h.handleZCard(args)
+	case "ZCOUNT":
+		return h.handleZCount(args)
+	case "ZRANK":
+		return h.handleZRank("zrank", args, false)
+	case "ZREVRANK":
+		return h.handleZRank("zrevrank", args, true)
+	case "ZRANGE":
+		return h.handleZRange("zrange", args, zrangeOptions{}, true)
+	case "ZREVRANGE":
+		return h.handleZRange("zrevrange", args, zrangeOptions{rev: true}, false)
+	case "ZRANGEBYSCORE":
+		return h.handleZRange("zrangebyscore", args, zrangeOptions{by: zrangeByScore}, false)
+	case "ZREVRANGEBYSCORE":
+		return h.handleZRange("zrevrangebyscore", args, zrangeOptions{by: zrangeByScore, rev: true}, false)
+	case "ZRANGEBYLEX":
+		return h.handleZRange("zrangebylex", args, zrangeOptions{by: zrangeByLex}, false)
+	case "ZREVRANGEBYLEX":
+		return h.handleZRange("zrevrangebylex", args, zrangeOptions{by: zrangeByLex, rev: true}, false)
+	case "ZPOPMIN":
+		return h.handleZPop("zpopmin", args, false)
+	case "ZPOPMAX":
+		return h.handleZPop("zpopmax", args, true)
+	case "XADD":
+		return h.handleXAdd(args)
+	case "XTRIM":
+		return h.handleXTrim(args)
+	case "XLEN":
+		return h.handleXLen(args)
+	case "XRANGE":
+		return h.handleXRange("xrange", args, false)
+	case "XREVRANGE":
+		return h.handleXRange("xrevrange", args, true)
+	case "XREAD":
+		return h.handleXRead(ctx, args)
+	case "XGROUP":
+		return h.handleXGroup(args)
+	case "XREADGROUP":
+		return h.handleXReadGroup(ctx, args)
+	case "XACK":
+		return h.handleXAck(args)
+	case "XPENDING":
+		return h.handleXPending(args)
+	case "XCLAIM":
+		return h.handleXClaim(args)
+	case "XAUTOCLAIM":
+		return h.handleXAutoClaim(args)
 	default:
-		return NewError("ERR unknown command '" + command + "'")
+		return NewError(unknownCommand + string(command) + "'")
+	}
+}
+
+// unknownCommand starts the error returned for commands the handler does
+// not implement.
+const unknownCommand = "ERR unknown command '"
+
+// IsUnknownCommand reports whether reply is the error returned for a command
+// the handler does not implement.
+func IsUnknownCommand(reply Value) bool {
+	return reply.Type == Error && strings.HasPrefix(reply.Str, unknownCommand)
+}
+
+// growsMemory lists the commands Redis flags denyoom: those that may grow
+// the cache, which are refused while it is over maxmemory and nothing can be
+// evicted. Other writes, such as pops and deletions, still run so clients
+// can free memory.
+var growsMemory = map[string]bool{
+	"SET": true, "INCR": true, "DECR": true, "INCRBY": true, "DECRBY": true,
+	"INCRBYFLOAT": true, "MSET": true, "MSETNX": true, "GETSET": true,
+	"APPEND": true, "SETRANGE": true, "SETBIT": true, "BITOP": true,
+	"BITFIELD": true, "PFADD": true, "PFMERGE": true, "GEOADD": true,
+	"JSON.SET": true, "JSON.NUMINCRBY": true, "JSON.ARRAPPEND": true,
+	"HSET": true, "HMSET": true, "HSETNX": true, "HINCRBY": true,
+	"HINCRBYFLOAT": true, "LPUSH": true, "RPUSH": true, "LPUSHX": true,
+	"RPUSHX": true, "LSET": true, "LMOVE": true, "RPOPLPUSH": true,
+	"BLMOVE": true, "BRPOPLPUSH": true, "SADD": true, "SUNIONSTORE": true,
+	"SINTERSTORE": true, "SDIFFSTORE": true, "ZADD": true, "ZINCRBY": true,
+	"XADD": true, "XGROUP": true, "XREADGROUP": true,
+}
+
+// upperASCII appends s upper-cased to buf. Command names are matched this
+// way, on a buffer on the caller's stack, rather than with strings.ToUpper,
+// which allocates for every command a client sends in lower case.
+func upperASCII(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+// IsBlocking reports whether cmd may park the calling connection.
+func IsBlocking(cmd Value) bool {
+	if cmd.Type != Array || len(cmd.Array) == 0 {
+		return false
+	}
+
+	var buf [32]byte
+	switch string(upperASCII(buf[:0], cmd.Array[0].Str)) {
+	case "BLPOP", "BRPOP", "BLMOVE", "BRPOPLPUSH":
+		return true
+	case "XREAD", "XREADGROUP":
+		for _, arg := range cmd.Array[1:] {
+			if strings.EqualFold(arg.Str, "BLOCK") {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
 	}
 }
 
@@ -58,12 +382,15 @@ func (h *CommandHandler) handleGet(args []Value) Value {
 	}
 
 	key := args[0].Str
-	value, exists := h.cache.Get(key)
+	value, exists, err := h.cache.GetString(key)
+	if err != nil {
+		return errorReply(err)
+	}
 	if !exists {
-		return Value{Type: BulkString, Str: ""}
+		return NewNullBulkString()
 	}
 
-	return NewBulkString(string(value))
+	return NewBulkString(bytesString(value))
 }
 
 func (h *CommandHandler) handleSet(args []Value) Value {
@@ -73,37 +400,74 @@ func (h *CommandHandler) handleSet(args []Value) Value {
 
 	key := args[0].Str
 	value := []byte(args[1].Str)
-	var ttl time.Duration
-
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(args[i].Str)
-			switch arg {
-			case "EX":
-				if i+1 >= len(args) {
-					return NewError("ERR syntax error")
-				}
-				seconds, err := strconv.Atoi(args[i+1].Str)
-				if err != nil {
-					return NewError("ERR value is not an integer or out of range")
-				}
-				ttl = time.Duration(seconds) * time.Second
-				i++
-			case "PX":
-				if i+1 >= len(args) {
-					return NewError("ERR syntax error")
-				}
-				ms, err := strconv.Atoi(args[i+1].Str)
-				if err != nil {
-					return NewError("ERR value is not an integer or out of range")
-				}
-				ttl = time.Duration(ms) * time.Millisecond
-				i++
+	var opts cache.SetOptions
+	expiry := ""
+
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].Str)
+		switch arg {
+		case "NX":
+			if opts.OnlyIfExists || opts.OnlyIfMissing {
+				return NewError("ERR syntax error")
+			}
+			opts.OnlyIfMissing = true
+		case "XX":
+			if opts.OnlyIfExists || opts.OnlyIfMissing {
+				return NewError("ERR syntax error")
+			}
+			opts.OnlyIfExists = true
+		case "GET":
+			if opts.Get {
+				return NewError("ERR syntax error")
 			}
+			opts.Get = true
+		case "KEEPTTL":
+			if expiry != "" {
+				return NewError("ERR syntax error")
+			}
+			expiry = arg
+			opts.KeepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiry != "" || i+1 >= len(args) {
+				return NewError("ERR syntax error")
+			}
+			expiry = arg
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return NewError("ERR value is not an integer or out of range")
+			}
+			i++
+			if n <= 0 {
+				return NewError("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if arg == "PX" || arg == "PXAT" {
+				unit = time.Millisecond
+			}
+			expiresAt, ok := expiryTime(n, unit, arg == "EXAT" || arg == "PXAT")
+			if !ok {
+				return NewError("ERR invalid expire time in 'set' command")
+			}
+			opts.ExpiresAt = expiresAt
+		default:
+			return NewError("ERR syntax error")
 		}
 	}
 
-	h.cache.Set(key, value, ttl)
+	prev, existed, err := h.cache.SetWithOptions(key, value, opts)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if opts.Get {
+		if !existed {
+			return NewNullBulkString()
+		}
+		return NewBulkString(string(prev))
+	}
+	if (opts.OnlyIfMissing && existed) || (opts.OnlyIfExists && !existed) {
+		return NewNullBulkString()
+	}
 	return NewSimpleString("OK")
 }
 
@@ -129,9 +493,12 @@ func (h *CommandHandler) handleExists(args []Value) Value {
 
 	exists := int64(0)
 	for _, arg := range args {
-		if _, found := h.cache.Get(arg.Str); found {
-			exists++
-		}
+		h.cache.View(arg.Str, func(entry *cache.Entry) error {
+			if entry != nil {
+				exists++
+			}
+			return nil
+		})
 	}
 
 	return NewInteger(exists)
@@ -142,19 +509,63 @@ func (h *CommandHandler) handleKeys(args []Value) Value {
 		return NewError("ERR wrong number of arguments for 'keys' command")
 	}
 
+	// Walking the keyspace a few buckets at a time keeps every shard lock
+	// short, so KEYS no longer stalls writers on a large cache.
 	pattern := args[0].Str
-	keys := h.cache.Keys()
-
 	var matchedKeys []Value
-	for _, key := range keys {
-		if matchPattern(key, pattern) {
-			matchedKeys = append(matchedKeys, NewBulkString(key))
+	cursor := uint64(0)
+	for {
+		cursor = h.cache.Scan(cursor, 1024, func(key string, entry *cache.Entry) {
+			if matchPattern(key, pattern) {
+				matchedKeys = append(matchedKeys, NewBulkString(key))
+			}
+		})
+		if cursor == 0 {
+			break
 		}
 	}
 
 	return NewArray(matchedKeys...)
 }
 
+func (h *CommandHandler) handleScan(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("scan")
+	}
+
+	opts, err := parseScanOptions(args, true)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var keys []Value
+	cursor := h.cache.Scan(opts.cursor, opts.count, func(key string, entry *cache.Entry) {
+		if opts.typeName != "" && strings.ToLower(entry.Type().String()) != opts.typeName {
+			return
+		}
+		if opts.pattern == "" || matchPattern(key, opts.pattern) {
+			keys = append(keys, NewBulkString(key))
+		}
+	})
+
+	return NewArray(NewBulkString(strconv.FormatUint(cursor, 10)), NewArray(keys...))
+}
+
+func (h *CommandHandler) handleType(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("type")
+	}
+
+	valueType := "none"
+	h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		if entry != nil {
+			valueType = entry.Type().String()
+		}
+		return nil
+	})
+	return NewSimpleString(valueType)
+}
+
 func (h *CommandHandler) handleFlushAll(args []Value) Value {
 	h.cache.Clear()
 	return NewSimpleString("OK")
@@ -174,49 +585,88 @@ func (h *CommandHandler) handleInfo(args []Value) Value {
 	info += "\r\n"
 	info += "# Memory\r\n"
 	info += "used_memory:" + strconv.FormatInt(h.cache.Size(), 10) + "\r\n"
-	info += "keyspace_hits:0\r\n"
-	info += "keyspace_misses:0\r\n"
+	info += "keyspace_hits:" + strconv.FormatInt(h.cache.Hits(), 10) + "\r\n"
+	info += "keyspace_misses:" + strconv.FormatInt(h.cache.Misses(), 10) + "\r\n"
+	info += "\r\n"
+	info += "# Stats\r\n"
+	info += "expired_keys:" + strconv.FormatInt(h.cache.ExpiredKeys(), 10) + "\r\n"
 
 	return NewBulkString(info)
 }
 
-func matchPattern(str, pattern string) bool {
-	if pattern == "*" {
-		return true
+var (
+	errSyntax        = errors.New("syntax error")
+	errInvalidCursor = errors.New("invalid cursor")
+)
+
+// codedError is an error replied with its own code, such as NOGROUP, in
+// place of the generic ERR prefix.
+type codedError string
+
+func (e codedError) Error() string {
+	return string(e)
+}
+
+func wrongArgs(command string) Value {
+	return NewError("ERR wrong number of arguments for '" + command + "' command")
+}
+
+func errorReply(err error) Value {
+	if errors.Is(err, cache.ErrOutOfMemory) {
+		return NewError("OOM command not allowed when used memory > 'maxmemory'.")
+	}
+	if errors.Is(err, cache.ErrWrongType) {
+		return NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if errors.Is(err, cache.ErrInvalidHyperLogLog) {
+		return NewError("WRONGTYPE Key is not a valid HyperLogLog string value.")
 	}
-	
-	if !strings.Contains(pattern, "*") && !strings.Contains(pattern, "?") {
-		return str == pattern
+	var coded codedError
+	if errors.As(err, &coded) {
+		return NewError(string(coded))
 	}
-	
-	return simpleGlobMatch(str, pattern)
+	return NewError("ERR " + err.Error())
 }
 
-func simpleGlobMatch(str, pattern string) bool {
-	if pattern == "" {
-		return str == ""
-	}
-	
-	if pattern == "*" {
-		return true
+type scanOptions struct {
+	cursor  uint64
+	pattern string
+	count   int
+	// typeName is the TYPE filter, which only SCAN itself accepts.
+	typeName string
+}
+
+// parseScanOptions validates the cursor and MATCH/COUNT options of the *SCAN
+// commands, and the TYPE option if withType is set.
+func parseScanOptions(args []Value, withType bool) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0].Str, 10, 64)
+	if err != nil {
+		return opts, errInvalidCursor
 	}
-	
-	if len(pattern) > 0 && pattern[0] == '*' {
-		for i := 0; i <= len(str); i++ {
-			if simpleGlobMatch(str[i:], pattern[1:]) {
-				return true
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		switch option := strings.ToUpper(args[i].Str); {
+		case option == "MATCH":
+			opts.pattern = args[i+1].Str
+		case option == "COUNT":
+			count, err := strconv.ParseInt(args[i+1].Str, 10, 32)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if count < 1 {
+				return opts, errSyntax
 			}
+			opts.count = int(count)
+		case option == "TYPE" && withType:
+			opts.typeName = strings.ToLower(args[i+1].Str)
+		default:
+			return opts, errSyntax
 		}
-		return false
-	}
-	
-	if len(str) == 0 {
-		return false
 	}
-	
-	if len(pattern) > 0 && (pattern[0] == '?' || pattern[0] == str[0]) {
-		return simpleGlobMatch(str[1:], pattern[1:])
-	}
-	
-	return false
-}
\ No newline at end of file
+	return opts, nil
+}
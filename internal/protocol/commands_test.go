@@ -0,0 +1,180 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// execute runs a command given as plain strings.
+func execute(handler *CommandHandler, session *Session, args ...string) Value {
+	cmd := make([]Value, len(args))
+	for i, arg := range args {
+		cmd[i] = NewBulkString(arg)
+	}
+	return handler.ExecuteSession(context.Background(), session, NewArray(cmd...))
+}
+
+func TestOutOfMemoryOnlyRefusesGrowth(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024, nil))
+	session := NewSession()
+	
+	// Growth that Update cannot reclaim leaves the cache over its limit.
+	big := strings.Repeat("x", 2048)
+	execute(handler, session, "SADD", "set", "a", "b")
+	execute(handler, session, "XADD", "stream", "1-1", "f", "v")
+	execute(handler, session, "XGROUP", "CREATE", "stream", "g", "0")
+	execute(handler, session, "RPUSH", "list", big, "small")
+	if handler.cache.Size() <= handler.cache.MaxSize() {
+		t.Fatalf("Expected the cache to be over its limit, size %d", handler.cache.Size())
+	}
+	
+	oom := "OOM command not allowed when used memory > 'maxmemory'."
+	for _, args := range [][]string{
+		{"RPUSH", "list", "more"},
+		{"SADD", "set", "c"},
+		{"SET", "key", "value"},
+		{"HSET", "hash", "field", "value"},
+		{"XGROUP", "CREATE", "stream", "g2", "$"},
+		{"XGROUP", "CREATE", "other", "g", "$", "MKSTREAM"},
+		{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "stream", ">"},
+	} {
+		if reply := execute(handler, session, args...); reply.Type != Error || reply.Str != oom {
+			t.Errorf("%v: expected an OOM error, got %+v", args, reply)
+		}
+	}
+	
+	if reply := execute(handler, session, "SREM", "set", "a"); reply.Type != Integer || reply.Int != 1 {
+		t.Errorf("SREM must run over the limit, got %+v", reply)
+	}
+	if reply := execute(handler, session, "GET", "missing"); reply.Type != BulkString || !reply.Null {
+		t.Errorf("GET must run over the limit, got %+v", reply)
+	}
+	if reply := execute(handler, session, "LPOP", "list"); reply.Type != BulkString || reply.Str != big {
+		t.Errorf("LPOP must run over the limit, got %+v", reply)
+	}
+	
+	if handler.cache.Size() > handler.cache.MaxSize() {
+		t.Fatalf("Expected LPOP to bring the cache under its limit, size %d", handler.cache.Size())
+	}
+	if reply := execute(handler, session, "SADD", "set", "c"); reply.Type != Integer || reply.Int != 1 {
+		t.Errorf("SADD must run again once memory was freed, got %+v", reply)
+	}
+	if reply := execute(handler, session, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "stream", ">"); reply.Type != Array || len(reply.Array) != 1 {
+		t.Errorf("XREADGROUP must run again once memory was freed, got %+v", reply)
+	}
+}
+
+// scanAll iterates a SCAN-style command until its cursor returns to 0,
+// returning the items seen and the number of calls made.
+func scanAll(t *testing.T, handler *CommandHandler, args ...string) ([]string, int) {
+	var items []string
+	cursor, calls := "0", 0
+	for {
+		cmd := append([]string{args[0], args[1], cursor}, args[2:]...)
+		reply := execute(handler, NewSession(), cmd...)
+		if reply.Type != Array || len(reply.Array) != 2 {
+			t.Fatalf("%v: unexpected reply %+v", cmd, reply)
+		}
+		for _, item := range reply.Array[1].Array {
+			items = append(items, item.Str)
+		}
+		calls++
+		if cursor = reply.Array[0].Str; cursor == "0" {
+			return items, calls
+		}
+	}
+}
+
+// commandStep is a command and its expected reply, as rendered by show.
+type commandStep struct {
+	args []string
+	want string
+}
+
+// runSteps executes steps in order on one session, checking every reply.
+func runSteps(t *testing.T, handler *CommandHandler, steps []commandStep) {
+	t.Helper()
+	session := NewSession()
+	for _, step := range steps {
+		if got := show(execute(handler, session, step.args...)); got != step.want {
+			t.Errorf("%q: got %s, want %s", step.args, got, step.want)
+		}
+	}
+}
+
+// show renders a reply compactly, much like redis-cli: integers as :n,
+// simple strings as +s, errors as -message, bulk strings as themselves,
+// nulls as (nil) and arrays in brackets.
+func show(v Value) string {
+	switch {
+	case v.Null:
+		return "(nil)"
+	case v.Type == Integer:
+		return ":" + strconv.FormatInt(v.Int, 10)
+	case v.Type == SimpleString:
+		return "+" + v.Str
+	case v.Type == Error:
+		return "-" + v.Str
+	case v.Type == Array:
+		items := make([]string, len(v.Array))
+		for i, item := range v.Array {
+			items[i] = show(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case v.Str == "":
+		return `""`
+	default:
+		return v.Str
+	}
+}
+
+func TestHScan(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	session := NewSession()
+	for i := 0; i < 100; i++ {
+		execute(handler, session, "HSET", "hash", "field:"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	
+	items, calls := scanAll(t, handler, "HSCAN", "hash", "COUNT", "10")
+	if len(items) != 200 || calls != 10 {
+		t.Errorf("Expected 100 field-value pairs over 10 calls, got %d items over %d calls", len(items), calls)
+	}
+	for i := 0; i+1 < len(items); i += 2 {
+		if "v"+strings.TrimPrefix(items[i], "field:") != items[i+1] {
+			t.Errorf("Field %s came back with value %s", items[i], items[i+1])
+		}
+	}
+	
+	items, _ = scanAll(t, handler, "HSCAN", "hash", "MATCH", "field:1?", "COUNT", "7")
+	if len(items) != 20 {
+		t.Errorf("Expected the 10 fields matching field:1?, got %v", items)
+	}
+	if items, calls := scanAll(t, handler, "HSCAN", "missing"); len(items) != 0 || calls != 1 {
+		t.Errorf("Expected an empty scan of a missing key, got %v", items)
+	}
+}
+
+func TestSScan(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	session := NewSession()
+	execute(handler, session, "SADD", "ints", "1", "2", "3")
+	if items, calls := scanAll(t, handler, "SSCAN", "ints", "COUNT", "1"); len(items) != 3 || calls != 1 {
+		t.Errorf("Expected a small intset in one call, got %v over %d calls", items, calls)
+	}
+	
+	for i := 0; i < 100; i++ {
+		execute(handler, session, "SADD", "set", "member:"+strconv.Itoa(i))
+	}
+	items, calls := scanAll(t, handler, "SSCAN", "set", "COUNT", "10")
+	if len(items) != 100 || calls != 10 {
+		t.Errorf("Expected 100 members over 10 calls, got %d over %d calls", len(items), calls)
+	}
+	items, _ = scanAll(t, handler, "SSCAN", "set", "MATCH", "member:?")
+	if len(items) != 10 {
+		t.Errorf("Expected the 10 members matching member:?, got %v", items)
+	}
+}
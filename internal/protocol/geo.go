@@ -0,0 +1,367 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errGeoUnit   = errors.New("unsupported unit provided. please use M, KM, FT, MI")
+	errGeoMember = errors.New("could not decode requested zset member")
+)
+
+func parseGeoUnit(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errGeoUnit
+}
+
+// parseGeoPosition parses a longitude, latitude pair, rejecting positions
+// the geohash encoding cannot represent.
+func parseGeoPosition(lonArg, latArg string) (float64, float64, error) {
+	lon, err := parseFloat(lonArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	lat, err := parseFloat(latArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, ok := cache.GeoEncode(lon, lat); !ok {
+		return 0, 0, fmt.Errorf("invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+func formatDistance(meters, unit float64) string {
+	return strconv.FormatFloat(meters/unit, 'f', 4, 64)
+}
+
+// formatCoordinate prints a coordinate with up to 17 decimals, as Redis does.
+func formatCoordinate(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func geoPositionValue(score float64) Value {
+	lon, lat := cache.GeoDecode(uint64(score))
+	return NewArray(NewBulkString(formatCoordinate(lon)), NewBulkString(formatCoordinate(lat)))
+}
+
+func (h *CommandHandler) handleGeoAdd(args []Value) Value {
+	if len(args) < 4 {
+		return wrongArgs("geoadd")
+	}
+	
+	var nx, xx, ch bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		default:
+			break flags
+		}
+	}
+	
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return NewError("ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+	}
+	if nx && xx {
+		return errorReply(errZAddNXXX)
+	}
+	
+	scores := make([]float64, len(triples)/3)
+	for j := range scores {
+		lon, lat, err := parseGeoPosition(triples[3*j].Str, triples[3*j+1].Str)
+		if err != nil {
+			return errorReply(err)
+		}
+		hash, _ := cache.GeoEncode(lon, lat)
+		scores[j] = float64(hash)
+	}
+	
+	added, changed := 0, 0
+	err := h.updateZSet(args[0].Str, !xx, func(zset *cache.SortedSet) error {
+		for j, score := range scores {
+			member := triples[3*j+2].Str
+			old, exists := zset.Score(member)
+			if (exists && nx) || (!exists && xx) {
+				continue
+			}
+			if zset.Add(member, score) {
+				added++
+			} else if score != old {
+				changed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if ch {
+		return NewInteger(int64(added + changed))
+	}
+	return NewInteger(int64(added))
+}
+
+func (h *CommandHandler) handleGeoDist(args []Value) Value {
+	if len(args) < 3 {
+		return wrongArgs("geodist")
+	}
+	if len(args) > 4 {
+		return errorReply(errSyntax)
+	}
+	
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		if unit, err = parseGeoUnit(args[3].Str); err != nil {
+			return errorReply(err)
+		}
+	}
+	
+	var score1, score2 float64
+	found := false
+	err := h.viewZSet(args[0].Str, func(zset *cache.SortedSet) {
+		if zset == nil {
+			return
+		}
+		var ok1, ok2 bool
+		score1, ok1 = zset.Score(args[1].Str)
+		score2, ok2 = zset.Score(args[2].Str)
+		found = ok1 && ok2
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return NewNullBulkString()
+	}
+	
+	lon1, lat1 := cache.GeoDecode(uint64(score1))
+	lon2, lat2 := cache.GeoDecode(uint64(score2))
+	return NewBulkString(formatDistance(cache.GeoDistance(lon1, lat1, lon2, lat2), unit))
+}
+
+func (h *CommandHandler) handleGeoPos(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("geopos")
+	}
+	
+	result := make([]Value, len(args)-1)
+	err := h.viewZSet(args[0].Str, func(zset *cache.SortedSet) {
+		for i, arg := range args[1:] {
+			result[i] = NewNullArray()
+			if zset == nil {
+				continue
+			}
+			if score, ok := zset.Score(arg.Str); ok {
+				result[i] = geoPositionValue(score)
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewArray(result...)
+}
+
+type geoSearchOptions struct {
+	shape      cache.GeoShape
+	unit       float64
+	member     string
+	fromMember bool
+	fromLonLat bool
+	byRadius   bool
+	byBox      bool
+	// order is 1 for ascending distances, -1 for descending, 0 for none.
+	order     int
+	count     int64
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+}
+
+func parseGeoSearch(args []Value) (geoSearchOptions, error) {
+	var opts geoSearchOptions
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].Str)
+		switch {
+		case arg == "FROMMEMBER" && i+1 < len(args):
+			opts.member, opts.fromMember = args[i+1].Str, true
+			i++
+		case arg == "FROMLONLAT" && i+2 < len(args):
+			lon, lat, err := parseGeoPosition(args[i+1].Str, args[i+2].Str)
+			if err != nil {
+				return opts, err
+			}
+			opts.shape.Lon, opts.shape.Lat, opts.fromLonLat = lon, lat, true
+			i += 2
+		case arg == "BYRADIUS" && i+2 < len(args):
+			radius, err := strconv.ParseFloat(args[i+1].Str, 64)
+			if err != nil {
+				return opts, errors.New("need numeric radius")
+			}
+			if radius < 0 {
+				return opts, errors.New("radius cannot be negative")
+			}
+			if opts.unit, err = parseGeoUnit(args[i+2].Str); err != nil {
+				return opts, err
+			}
+			opts.shape.Radius, opts.byRadius = radius*opts.unit, true
+			i += 2
+		case arg == "BYBOX" && i+3 < len(args):
+			width, err := strconv.ParseFloat(args[i+1].Str, 64)
+			if err != nil {
+				return opts, errors.New("need numeric width")
+			}
+			height, err := strconv.ParseFloat(args[i+2].Str, 64)
+			if err != nil {
+				return opts, errors.New("need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return opts, errors.New("height or width cannot be negative")
+			}
+			if opts.unit, err = parseGeoUnit(args[i+3].Str); err != nil {
+				return opts, err
+			}
+			opts.shape.Width, opts.shape.Height, opts.byBox = width*opts.unit, height*opts.unit, true
+			i += 3
+		case arg == "ASC":
+			opts.order = 1
+		case arg == "DESC":
+			opts.order = -1
+		case arg == "COUNT" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n <= 0 {
+				return opts, errors.New("COUNT must be > 0")
+			}
+			opts.count = n
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1].Str, "ANY") {
+				opts.any = true
+				i++
+			}
+		case arg == "WITHCOORD":
+			opts.withCoord = true
+		case arg == "WITHDIST":
+			opts.withDist = true
+		case arg == "WITHHASH":
+			opts.withHash = true
+		default:
+			return opts, errSyntax
+		}
+	}
+	
+	if opts.fromMember == opts.fromLonLat {
+		return opts, errors.New("exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+	}
+	if opts.byRadius == opts.byBox {
+		return opts, errors.New("exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+	}
+	// Without ANY, a COUNT means the nearest matches, so they get sorted.
+	if opts.count > 0 && !opts.any && opts.order == 0 {
+		opts.order = 1
+	}
+	return opts, nil
+}
+
+type geoMatch struct {
+	member   string
+	score    float64
+	distance float64
+}
+
+func (h *CommandHandler) handleGeoSearch(args []Value) Value {
+	if len(args) < 5 {
+		return wrongArgs("geosearch")
+	}
+	
+	opts, err := parseGeoSearch(args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var matches []geoMatch
+	err = h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		zset, err := asZSet(entry)
+		if err != nil || zset == nil {
+			return err
+		}
+		shape := opts.shape
+		if opts.fromMember {
+			score, ok := zset.Score(opts.member)
+			if !ok {
+				return errGeoMember
+			}
+			shape.Lon, shape.Lat = cache.GeoDecode(uint64(score))
+		}
+		zset.GeoSearch(shape, func(member string, score, distance float64) bool {
+			matches = append(matches, geoMatch{member, score, distance})
+			return !opts.any || int64(len(matches)) < opts.count
+		})
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if opts.order != 0 {
+		sort.Slice(matches, func(i, j int) bool {
+			if opts.order < 0 {
+				return matches[i].distance > matches[j].distance
+			}
+			return matches[i].distance < matches[j].distance
+		})
+	}
+	if opts.count > 0 && int64(len(matches)) > opts.count {
+		matches = matches[:opts.count]
+	}
+	
+	result := make([]Value, len(matches))
+	for i, match := range matches {
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			result[i] = NewBulkString(match.member)
+			continue
+		}
+		item := []Value{NewBulkString(match.member)}
+		if opts.withDist {
+			item = append(item, NewBulkString(formatDistance(match.distance, opts.unit)))
+		}
+		if opts.withHash {
+			item = append(item, NewInteger(int64(match.score)))
+		}
+		if opts.withCoord {
+			item = append(item, geoPositionValue(match.score))
+		}
+		result[i] = NewArray(item...)
+	}
+	return NewArray(result...)
+}
@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func TestGeoSearch(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}, ":2"},
+		{[]string{"GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"}, ":2"},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "km"}, "166.2742"},
+		
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}, "[Catania Palermo]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC"}, "[Palermo Catania]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST"}, "[[Catania 56.4413] [Palermo 190.4424]]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200000", "m", "ASC", "WITHDIST"}, "[[Catania 56441.2579] [Palermo 190442.4298]]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST"},
+			"[[Catania 56.4413 [15.08726745843887329 37.50266842333162032]] " +
+				"[Palermo 190.4424 [13.36138933897018433 38.11555639549629859]] " +
+				"[edge2 279.7403 [17.24151045083999634 38.78813451624225195]] " +
+				"[edge1 279.7405 [12.7584877610206604 38.78813451624225195]]]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHHASH"},
+			"[[Catania :3479447370796909] [Palermo :3479099956230698] [edge2 :3481342659049484] [edge1 :3479273021651468]]"},
+		
+		// The box is measured from its center, so a narrow box misses the
+		// members that a radius of the same size would include.
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "120", "km", "ASC"}, "[Catania]"},
+		
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "ASC", "WITHDIST"}, "[[Palermo 0.0000] [edge1 91.4007] [Catania 166.2742]]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Catania", "BYBOX", "500", "300", "km", "DESC"}, "[edge1 edge2 Palermo Catania]"},
+		
+		// COUNT alone keeps the nearest matches; COUNT ANY keeps the first
+		// found, then sorts only if asked to.
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "1"}, "[Catania]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "2", "DESC"}, "[edge1 edge2]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "4", "ANY", "ASC"}, "[Catania Palermo edge2 edge1]"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km"}, "[]"},
+		{[]string{"GEOSEARCH", "missing", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km"}, "[]"},
+		
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Rome", "BYRADIUS", "10", "km"}, "-ERR could not decode requested zset member"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km"}, "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch"},
+		{[]string{"GEOSEARCH", "Sicily", "BYRADIUS", "10", "km", "ASC"}, "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km", "BYBOX", "1", "1", "km"}, "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "ASC", "WITHDIST"}, "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "yd"}, "-ERR unsupported unit provided. please use M, KM, FT, MI"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "far", "km"}, "-ERR need numeric radius"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km"}, "-ERR radius cannot be negative"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "1", "-1", "km"}, "-ERR height or width cannot be negative"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "1", "tall", "km"}, "-ERR need numeric height"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "200", "37", "BYRADIUS", "10", "km"}, "-ERR invalid longitude,latitude pair 200.000000,37.000000"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km", "COUNT", "0"}, "-ERR COUNT must be > 0"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km", "COUNT", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km", "ANY"}, "-ERR syntax error"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10"}, "-ERR syntax error"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15"}, "-ERR wrong number of arguments for 'geosearch' command"},
+		{[]string{"SET", "s", "v"}, "+OK"},
+		{[]string{"GEOSEARCH", "s", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
+
+// COUNT ANY may return any of the matches, so only its size is fixed.
+func TestGeoSearchCountAny(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	execute(handler, nil, "GEOADD", "points", "0", "0", "a", "0.001", "0", "b", "0.002", "0", "c", "0.003", "0", "d")
+	
+	result := execute(handler, nil, "GEOSEARCH", "points", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km", "COUNT", "2", "ANY")
+	if len(result.Array) != 2 || result.Array[0].Str == result.Array[1].Str {
+		t.Fatalf("COUNT 2 ANY returned %s, want two distinct members", show(result))
+	}
+}
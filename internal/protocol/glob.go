@@ -0,0 +1,90 @@
+package protocol
+
+// matchPattern reports whether str matches the Redis glob pattern, as used
+// by KEYS, the MATCH option of the *SCAN commands and channel patterns. A
+// star matches any run of bytes, ? any single byte, [abc] one of the listed
+// bytes, [^abc] any other and [a-z] a range, while \x matches x itself.
+//
+// Every token but * matches exactly one byte, so on a mismatch only the
+// most recent * needs to absorb one more byte. That bounds the work to
+// len(str)*len(pattern) where naive recursion is exponential.
+func matchPattern(str, pattern string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(str) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				star, next = p, i
+				p++
+				continue
+			}
+			if width, ok := matchToken(pattern[p:], str[i]); ok {
+				p += width
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		p, i = star+1, next
+	}
+	
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchToken matches c against the token pattern starts with and returns
+// the width of that token.
+func matchToken(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		width, ok := matchClass(pattern[1:], c)
+		return width + 1, ok
+	case '\\':
+		// A trailing backslash stands for itself.
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+	}
+	return 1, pattern[0] == c
+}
+
+// matchClass matches c against a character class, pattern starting just
+// past its opening bracket, and returns the width up to and including the
+// closing bracket. Like Redis, an unterminated class runs to the end of the
+// pattern and a reversed range is read the right way round.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 0
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		i++
+	}
+	
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	if i < len(pattern) {
+		i++
+	}
+	return i, matched != negate
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"", "", true},
+		{"", "a", false},
+		{"user:*", "user:42", true},
+		{"user:*", "user", false},
+		{"*:name", "user:42:name", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[b-a]llo", "hallo", true},
+		{"h[^a-c]llo", "hdllo", true},
+		{"h[^a-c]llo", "hbllo", false},
+		{"[0-9][0-9]", "42", true},
+		{"[\\]]", "]", true},
+		{"[\\^]", "^", true},
+		{"[\\-a]", "-", true},
+		{"h[ae", "ha", true},
+		{"h[ae", "hae", false},
+		{"h[^", "hx", true},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"\\?x", "?x", true},
+		{"\\[a]", "[a]", true},
+		{"a\\", "a\\", true},
+		{"a\\", "a", false},
+		{"**a", "ba", true},
+		{"a*b*c", "abxbc", true},
+		{"a*b*c", "acb", false},
+		{"*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 10000), false},
+		{"*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 10000) + "b", true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.str, tt.pattern); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", shorten(tt.str), tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func shorten(s string) string {
+	if len(s) > 20 {
+		return s[:20] + "..."
+	}
+	return s
+}
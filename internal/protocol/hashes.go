@@ -0,0 +1,390 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errHashNotInteger = errors.New("hash value is not an integer")
+	errHashNotFloat   = errors.New("hash value is not a float")
+)
+
+// asHash returns the hash held by entry, nil if entry is nil, or
+// cache.ErrWrongType if it holds another type.
+func asHash(entry *cache.Entry) (*cache.Hash, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	hash, ok := entry.Object.(*cache.Hash)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return hash, nil
+}
+
+// updateHash runs fn against the hash at key, creating it if create is set,
+// and deletes the key once the hash is left empty.
+func (h *CommandHandler) updateHash(key string, create bool, fn func(hash *cache.Hash) error) error {
+	return h.cache.Update(key, func(entry *cache.Entry) (*cache.Entry, error) {
+		hash, err := asHash(entry)
+		if err != nil {
+			return nil, err
+		}
+		if hash == nil {
+			if !create {
+				return nil, nil
+			}
+			hash = cache.NewHash()
+			entry = &cache.Entry{Object: hash}
+		}
+		
+		if err := fn(hash); err != nil {
+			return nil, err
+		}
+		if hash.Len() == 0 {
+			return nil, nil
+		}
+		return entry, nil
+	})
+}
+
+// viewHash runs fn with the hash at key, or nil if the key does not exist.
+func (h *CommandHandler) viewHash(key string, fn func(hash *cache.Hash)) error {
+	return h.cache.View(key, func(entry *cache.Entry) error {
+		hash, err := asHash(entry)
+		if err != nil {
+			return err
+		}
+		fn(hash)
+		return nil
+	})
+}
+
+func (h *CommandHandler) handleHSet(name string, args []Value) Value {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongArgs(name)
+	}
+	
+	added := 0
+	err := h.updateHash(args[0].Str, true, func(hash *cache.Hash) error {
+		for i := 1; i < len(args); i += 2 {
+			if hash.Set(args[i].Str, []byte(args[i+1].Str)) {
+				added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if name == "hmset" {
+		return NewSimpleString("OK")
+	}
+	return NewInteger(int64(added))
+}
+
+func (h *CommandHandler) handleHSetNX(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("hsetnx")
+	}
+	
+	added := false
+	err := h.updateHash(args[0].Str, true, func(hash *cache.Hash) error {
+		if _, exists := hash.Get(args[1].Str); !exists {
+			added = hash.Set(args[1].Str, []byte(args[2].Str))
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if added {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+func (h *CommandHandler) handleHGet(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("hget")
+	}
+	
+	var value []byte
+	found := false
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash != nil {
+			value, found = hash.Get(args[1].Str)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewBulkString(string(value))
+}
+
+func (h *CommandHandler) handleHMGet(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("hmget")
+	}
+	
+	result := make([]Value, len(args)-1)
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		for i, field := range args[1:] {
+			result[i] = NewNullBulkString()
+			if hash == nil {
+				continue
+			}
+			if value, exists := hash.Get(field.Str); exists {
+				result[i] = NewBulkString(string(value))
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(result...)
+}
+
+func (h *CommandHandler) handleHDel(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("hdel")
+	}
+	
+	deleted := 0
+	err := h.updateHash(args[0].Str, false, func(hash *cache.Hash) error {
+		for _, field := range args[1:] {
+			if hash.Delete(field.Str) {
+				deleted++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(deleted))
+}
+
+func (h *CommandHandler) handleHGetAll(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("hgetall")
+	}
+	
+	var result []Value
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash == nil {
+			return
+		}
+		result = make([]Value, 0, hash.Len()*2)
+		hash.Range(func(field string, value []byte) bool {
+			result = append(result, NewBulkString(field), NewBulkString(string(value)))
+			return true
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewMap(result...)
+}
+
+// handleHKeys implements HKEYS and HVALS.
+func (h *CommandHandler) handleHKeys(name string, args []Value, values bool) Value {
+	if len(args) != 1 {
+		return wrongArgs(name)
+	}
+	
+	var result []Value
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash == nil {
+			return
+		}
+		result = make([]Value, 0, hash.Len())
+		hash.Range(func(field string, value []byte) bool {
+			if values {
+				result = append(result, NewBulkString(string(value)))
+			} else {
+				result = append(result, NewBulkString(field))
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(result...)
+}
+
+func (h *CommandHandler) handleHLen(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("hlen")
+	}
+	
+	length := 0
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash != nil {
+			length = hash.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+func (h *CommandHandler) handleHExists(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("hexists")
+	}
+	
+	found := false
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash != nil {
+			_, found = hash.Get(args[1].Str)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if found {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+func (h *CommandHandler) handleHStrLen(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("hstrlen")
+	}
+	
+	var value []byte
+	err := h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash != nil {
+			value, _ = hash.Get(args[1].Str)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(len(value)))
+}
+
+func (h *CommandHandler) handleHIncrBy(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("hincrby")
+	}
+	
+	delta, err := strconv.ParseInt(args[2].Str, 10, 64)
+	if err != nil {
+		return errorReply(errNotInteger)
+	}
+	
+	var result int64
+	err = h.updateHash(args[0].Str, true, func(hash *cache.Hash) error {
+		var current int64
+		if value, exists := hash.Get(args[1].Str); exists {
+			n, err := strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return errHashNotInteger
+			}
+			current = n
+		}
+		
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return errOverflow
+		}
+		result = current + delta
+		hash.Set(args[1].Str, strconv.AppendInt(nil, result, 10))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(result)
+}
+
+func (h *CommandHandler) handleHIncrByFloat(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("hincrbyfloat")
+	}
+	
+	delta, err := parseFloat(args[2].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var result []byte
+	err = h.updateHash(args[0].Str, true, func(hash *cache.Hash) error {
+		var current float64
+		if value, exists := hash.Get(args[1].Str); exists {
+			n, err := parseFloat(string(value))
+			if err != nil {
+				return errHashNotFloat
+			}
+			current = n
+		}
+		
+		sum := current + delta
+		if math.IsNaN(sum) || math.IsInf(sum, 0) {
+			return errNaN
+		}
+		result = strconv.AppendFloat(nil, sum, 'f', -1, 64)
+		hash.Set(args[1].Str, result)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewBulkString(string(result))
+}
+
+// handleHScan visits up to COUNT fields per call, resuming from the cursor
+// the previous call returned, and replies with the next cursor, 0 once the
+// scan is complete. MATCH filters the fields visited, so a call may return
+// fewer than COUNT or none at all.
+func (h *CommandHandler) handleHScan(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("hscan")
+	}
+	
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var items []Value
+	var cursor uint64
+	err = h.viewHash(args[0].Str, func(hash *cache.Hash) {
+		if hash == nil {
+			return
+		}
+		cursor = hash.Scan(opts.cursor, opts.count, func(field string, value []byte) {
+			if opts.pattern == "" || matchPattern(field, opts.pattern) {
+				items = append(items, NewBulkString(field), NewBulkString(string(value)))
+			}
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(NewBulkString(strconv.FormatUint(cursor, 10)), NewArray(items...))
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func (h *CommandHandler) handlePFAdd(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("pfadd")
+	}
+	
+	changed := false
+	err := h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if entry == nil {
+			entry, changed = cache.NewHyperLogLog(), true
+		}
+		hll, err := cache.AsHyperLogLog(entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args[1:] {
+			if hll.Add([]byte(arg.Str)) {
+				changed = true
+			}
+		}
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if changed {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+func (h *CommandHandler) handlePFCount(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("pfcount")
+	}
+	
+	var count uint64
+	if len(args) == 1 {
+		// A single key caches its cardinality in the value, which is a write.
+		err := h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+			if entry == nil {
+				return nil, nil
+			}
+			hll, err := cache.AsHyperLogLog(entry)
+			if err != nil {
+				return nil, err
+			}
+			count = hll.Count()
+			return entry, nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		return NewInteger(int64(count))
+	}
+	
+	err := h.cache.ViewMulti(valueStrings(args), func(entries []*cache.Entry) error {
+		hlls := make([]*cache.HyperLogLog, 0, len(entries))
+		for _, entry := range entries {
+			if entry == nil {
+				continue
+			}
+			hll, err := cache.AsHyperLogLog(entry)
+			if err != nil {
+				return err
+			}
+			hlls = append(hlls, hll)
+		}
+		count = cache.CountUnion(hlls...)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(count))
+}
+
+func (h *CommandHandler) handlePFMerge(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("pfmerge")
+	}
+	
+	err := h.cache.UpdateMulti(valueStrings(args), func(entries []*cache.Entry) ([]*cache.Entry, error) {
+		sources := make([]*cache.HyperLogLog, 0, len(entries)-1)
+		for _, entry := range entries[1:] {
+			if entry == nil {
+				continue
+			}
+			hll, err := cache.AsHyperLogLog(entry)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, hll)
+		}
+		
+		next := make([]*cache.Entry, len(entries))
+		copy(next, entries)
+		if next[0] == nil {
+			next[0] = cache.NewHyperLogLog()
+		}
+		dest, err := cache.AsHyperLogLog(next[0])
+		if err != nil {
+			return nil, err
+		}
+		dest.Merge(sources...)
+		return next, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewSimpleString("OK")
+}
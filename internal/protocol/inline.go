@@ -0,0 +1,163 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+)
+
+// inlineMaxSize caps an inline command line like Redis' 64KB limit, so a
+// client sending no newline cannot grow the buffer forever.
+const inlineMaxSize = 64 * 1024
+
+var (
+	ErrUnbalancedQuotes = errors.New("unbalanced quotes in request")
+	ErrInlineTooBig     = errors.New("too big inline request")
+)
+
+// parseInline reads a command typed as a plain line and splits it into
+// the bulk strings a client would have sent.
+func (p *Parser) parseInline() ([]Value, error) {
+	line, err := p.readInlineLine()
+	if err != nil {
+		return nil, err
+	}
+	return splitInline(line)
+}
+
+func (p *Parser) readInlineLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := p.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > inlineMaxSize {
+			return "", ErrInlineTooBig
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
+}
+
+// splitInline splits line into arguments the way Redis does: on spaces,
+// with double quoted arguments understanding \n, \r, \t, \b, \a and \xHH
+// escapes and single quoted ones only \'. A closing quote must end its
+// argument.
+func splitInline(line string) ([]Value, error) {
+	// at returns 0 past the end of line, but the end is always tested by
+	// index since a NUL byte is an ordinary character of an argument.
+	at := func(i int) byte {
+		if i < len(line) {
+			return line[i]
+		}
+		return 0
+	}
+	
+	var args []Value
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; i++ {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && at(i+1) == 'x' && isHex(at(i+2)) && isHex(at(i+3)):
+					arg = append(arg, unhex(at(i+2))<<4|unhex(at(i+3)))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					arg = append(arg, unescape(line[i]))
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && at(i+1) == '\'':
+					i++
+					arg = append(arg, '\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t':
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+		}
+		args = append(args, NewBulkString(string(arg)))
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
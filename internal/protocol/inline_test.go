@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSplitInline(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  error
+	}{
+		{"", nil, nil},
+		{"  \t\r\n", nil, nil},
+		{"SET key value\r\n", []string{"SET", "key", "value"}, nil},
+		{"  GET \t key  ", []string{"GET", "key"}, nil},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}, nil},
+		{`SET key "a\nb\r\tc\bd\ae\"f\\g\qh"`, []string{"SET", "key", "a\nb\r\tc\bd\ae\"f\\gqh"}, nil},
+		{`SET key "\x41\x6a\x00\xff"`, []string{"SET", "key", "Aj\x00\xff"}, nil},
+		{`SET key "\x4" "\xzz"`, []string{"SET", "key", "x4", "xzz"}, nil},
+		{`SET key 'it\'s "raw" \n'`, []string{"SET", "key", `it's "raw" \n`}, nil},
+		{`SET key ""`, []string{"SET", "key", ""}, nil},
+		{`SET key ''`, []string{"SET", "key", ""}, nil},
+		{`SET ab"c d" e`, []string{"SET", "abc d", "e"}, nil},
+		{"SET key a\x00b", []string{"SET", "key", "a\x00b"}, nil},
+		{"SET \x00 \"\x00\"", []string{"SET", "\x00", "\x00"}, nil},
+		{`SET key "value`, nil, ErrUnbalancedQuotes},
+		{`SET key 'value`, nil, ErrUnbalancedQuotes},
+		{`SET key "value\"`, nil, ErrUnbalancedQuotes},
+		{`SET key "value"x`, nil, ErrUnbalancedQuotes},
+		{`SET key 'value'x`, nil, ErrUnbalancedQuotes},
+	}
+	for _, tt := range tests {
+		values, err := splitInline(tt.line)
+		if err != tt.err {
+			t.Errorf("splitInline(%q) returned error %v, want %v", tt.line, err, tt.err)
+			continue
+		}
+		var got []string
+		for _, v := range values {
+			got = append(got, v.Str)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitInline(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	parser := NewParser(bytes.NewReader([]byte("SET k \"a\\x00b\"\r\n*1\r\n$4\r\nPING\r\nGET k\n")))
+	for _, want := range [][]string{{"SET", "k", "a\x00b"}, {"PING"}, {"GET", "k"}} {
+		value, err := parser.Parse()
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		var got []string
+		for _, v := range value.Array {
+			got = append(got, v.Str)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
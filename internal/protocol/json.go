@@ -0,0 +1,308 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errJSONNewRoot   = errors.New("new objects must be created at the root")
+	errJSONNoKey     = errors.New("could not perform this operation on a key that doesn't exist")
+	errJSONNotNumber = errors.New("value is not a number")
+	errJSONNumber    = codedError("WRONGTYPE wrong type of path value - expected a number")
+	errJSONArray     = codedError("WRONGTYPE wrong type of path value - expected an array")
+)
+
+func asJSON(entry *cache.Entry) (*cache.JSON, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	doc, ok := entry.Object.(*cache.JSON)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return doc, nil
+}
+
+func jsonPathMissing(path string) error {
+	return errors.New("Path '" + path + "' does not exist")
+}
+
+// updateJSON runs fn against the document at key, failing if there is none.
+func (h *CommandHandler) updateJSON(key string, fn func(doc *cache.JSON) error) error {
+	return h.cache.Update(key, func(entry *cache.Entry) (*cache.Entry, error) {
+		doc, err := asJSON(entry)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return nil, errJSONNoKey
+		}
+		if err := fn(doc); err != nil {
+			return nil, err
+		}
+		return entry, nil
+	})
+}
+
+func (h *CommandHandler) handleJSONSet(args []Value) Value {
+	if len(args) != 3 && len(args) != 4 {
+		return wrongArgs("json.set")
+	}
+	
+	path, err := cache.ParseJSONPath(args[1].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	value, err := cache.ParseJSON(args[2].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3].Str) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+	
+	written := false
+	err = h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		doc, err := asJSON(entry)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			if !path.IsRoot() {
+				return nil, errJSONNewRoot
+			}
+			if xx {
+				return nil, nil
+			}
+			written = true
+			return &cache.Entry{Object: cache.NewJSON(value)}, nil
+		}
+		written = doc.Set(path, value, nx, xx)
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !written {
+		return NewNullBulkString()
+	}
+	return NewSimpleString("OK")
+}
+
+func (h *CommandHandler) handleJSONGet(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("json.get")
+	}
+	
+	var format cache.JSONFormat
+	i := 1
+options:
+	for ; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i].Str) {
+		case "INDENT":
+			format.Indent = args[i+1].Str
+		case "NEWLINE":
+			format.Newline = args[i+1].Str
+		case "SPACE":
+			format.Space = args[i+1].Str
+		default:
+			break options
+		}
+	}
+	names := valueStrings(args[i:])
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+	paths := make([]*cache.JSONPath, len(names))
+	legacy := true
+	for j, name := range names {
+		path, err := cache.ParseJSONPath(name)
+		if err != nil {
+			return errorReply(err)
+		}
+		paths[j] = path
+		legacy = legacy && path.Legacy
+	}
+	
+	var result any
+	found := false
+	err := h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		doc, err := asJSON(entry)
+		if err != nil || doc == nil {
+			return err
+		}
+		found = true
+		
+		// Legacy paths reply with the first match itself, JSONPaths with an
+		// array of every match.
+		values := make([]any, len(paths))
+		for j, path := range paths {
+			matches := doc.Get(path)
+			if !legacy {
+				values[j] = cache.JSONList(matches)
+				continue
+			}
+			if len(matches) == 0 {
+				return jsonPathMissing(names[j])
+			}
+			values[j] = matches[0]
+		}
+		if len(paths) == 1 {
+			result = values[0]
+		} else {
+			result = cache.JSONFields(names, values)
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewBulkString(cache.MarshalJSON(result, format))
+}
+
+func (h *CommandHandler) handleJSONDel(args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongArgs("json.del")
+	}
+	
+	name := "$"
+	if len(args) == 2 {
+		name = args[1].Str
+	}
+	path, err := cache.ParseJSONPath(name)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	deleted := 0
+	err = h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		doc, err := asJSON(entry)
+		if err != nil || doc == nil {
+			return entry, err
+		}
+		if path.IsRoot() {
+			deleted = 1
+			return nil, nil
+		}
+		deleted = doc.Delete(path)
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(deleted))
+}
+
+func (h *CommandHandler) handleJSONNumIncrBy(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("json.numincrby")
+	}
+	
+	path, err := cache.ParseJSONPath(args[1].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	incr, err := cache.ParseJSON(args[2].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	switch incr.(type) {
+	case int64, float64:
+	default:
+		return errorReply(errJSONNotNumber)
+	}
+	
+	var results []any
+	err = h.updateJSON(args[0].Str, func(doc *cache.JSON) error {
+		if path.Legacy {
+			matches := doc.Get(path)
+			if len(matches) == 0 {
+				return jsonPathMissing(args[1].Str)
+			}
+			for _, match := range matches {
+				if t := cache.JSONType(match); t != "integer" && t != "number" {
+					return errJSONNumber
+				}
+			}
+		}
+		var err error
+		results, err = doc.IncrBy(path, incr)
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if path.Legacy {
+		return NewBulkString(cache.MarshalJSON(results[len(results)-1], cache.JSONFormat{}))
+	}
+	return NewBulkString(cache.MarshalJSON(cache.JSONList(results), cache.JSONFormat{}))
+}
+
+func (h *CommandHandler) handleJSONArrAppend(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("json.arrappend")
+	}
+	
+	name, rest := "$", args[1:]
+	if len(args) > 2 {
+		name, rest = args[1].Str, args[2:]
+	}
+	path, err := cache.ParseJSONPath(name)
+	if err != nil {
+		return errorReply(err)
+	}
+	values := make([]any, len(rest))
+	for i, arg := range rest {
+		if values[i], err = cache.ParseJSON(arg.Str); err != nil {
+			return errorReply(err)
+		}
+	}
+	
+	var lengths []int
+	err = h.updateJSON(args[0].Str, func(doc *cache.JSON) error {
+		if path.Legacy {
+			matches := doc.Get(path)
+			if len(matches) == 0 {
+				return jsonPathMissing(name)
+			}
+			for _, match := range matches {
+				if cache.JSONType(match) != "array" {
+					return errJSONArray
+				}
+			}
+		}
+		lengths = doc.ArrAppend(path, values)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if path.Legacy {
+		return NewInteger(int64(lengths[len(lengths)-1]))
+	}
+	result := make([]Value, len(lengths))
+	for i, n := range lengths {
+		if n < 0 {
+			result[i] = NewNullBulkString()
+		} else {
+			result[i] = NewInteger(int64(n))
+		}
+	}
+	return NewArray(result...)
+}
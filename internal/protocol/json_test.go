@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func TestJSONSetAndGet(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"JSON.SET", "doc", "$", `{"a":1,"b":[1,2],"c":{"a":"x"}}`}, "+OK"},
+		{[]string{"JSON.GET", "doc"}, `{"a":1,"b":[1,2],"c":{"a":"x"}}`},
+		
+		// Legacy paths reply with the match itself, JSONPaths with an array
+		// of every match, and several paths with an object keyed by path.
+		{[]string{"JSON.GET", "doc", ".a"}, "1"},
+		{[]string{"JSON.GET", "doc", "c.a"}, `"x"`},
+		{[]string{"JSON.GET", "doc", "$.a"}, "[1]"},
+		{[]string{"JSON.GET", "doc", "$..a"}, `[1,"x"]`},
+		{[]string{"JSON.GET", "doc", "$.b[*]"}, "[1,2]"},
+		{[]string{"JSON.GET", "doc", "$['c']['a']"}, `["x"]`},
+		{[]string{"JSON.GET", "doc", "$.missing"}, "[]"},
+		{[]string{"JSON.GET", "doc", ".a", ".b"}, `{".a":1,".b":[1,2]}`},
+		{[]string{"JSON.GET", "doc", "$.a", ".b"}, `{"$.a":[1],".b":[[1,2]]}`},
+		{[]string{"JSON.GET", "doc", "INDENT", "  ", "NEWLINE", "\n", "SPACE", " ", "$.c"}, "[\n  {\n    \"a\": \"x\"\n  }\n]"},
+		{[]string{"JSON.GET", "missing"}, "(nil)"},
+		
+		{[]string{"JSON.SET", "doc", "$.d", "3", "NX"}, "+OK"},
+		{[]string{"JSON.SET", "doc", "$.d", "4", "NX"}, "(nil)"},
+		{[]string{"JSON.SET", "doc", "$.e", "4", "XX"}, "(nil)"},
+		{[]string{"JSON.SET", "doc", "$.d", "4.5", "XX"}, "+OK"},
+		{[]string{"JSON.SET", "doc", "$..a", "null"}, "+OK"},
+		{[]string{"JSON.GET", "doc"}, `{"a":null,"b":[1,2],"c":{"a":null},"d":4.5}`},
+		{[]string{"JSON.SET", "doc", "$.b[5]", "1"}, "(nil)"},
+		{[]string{"JSON.SET", "doc", "$.nope.deeper", "1"}, "(nil)"},
+		{[]string{"JSON.SET", "new", "$", "1", "XX"}, "(nil)"},
+		{[]string{"EXISTS", "new"}, ":0"},
+		
+		{[]string{"JSON.GET", "doc", ".missing"}, "-ERR Path '.missing' does not exist"},
+		{[]string{"JSON.GET", "doc", "$["}, "-ERR invalid JSON path"},
+		{[]string{"JSON.GET", "doc", "$.b[x]"}, "-ERR invalid JSON path"},
+		{[]string{"JSON.GET", "doc", "$['a"}, "-ERR invalid JSON path"},
+		{[]string{"JSON.GET", "doc", "$.a."}, "-ERR invalid JSON path"},
+		{[]string{"JSON.SET", "new", "$.a", "1"}, "-ERR new objects must be created at the root"},
+		{[]string{"JSON.SET", "doc", "$", "{bad"}, "-ERR invalid JSON value"},
+		{[]string{"JSON.SET", "doc", "$", "1 2"}, "-ERR invalid JSON value"},
+		{[]string{"JSON.SET", "doc", "$", "1", "FOO"}, "-ERR syntax error"},
+		{[]string{"JSON.SET", "doc", "$"}, "-ERR wrong number of arguments for 'json.set' command"},
+		{[]string{"JSON.GET"}, "-ERR wrong number of arguments for 'json.get' command"},
+		{[]string{"SET", "s", "v"}, "+OK"},
+		{[]string{"JSON.GET", "s"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{[]string{"JSON.SET", "s", "$", "1"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
+
+func TestJSONNumIncrBy(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"JSON.SET", "doc", "$", `{"a":1,"b":{"a":"x"},"c":{"a":2.5},"big":1e308}`}, "+OK"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$..a", "2"}, "[3,null,4.5]"},
+		{[]string{"JSON.NUMINCRBY", "doc", ".a", "1.5"}, "4.5"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$.a", "-0.5"}, "[4.0]"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$.missing", "1"}, "[]"},
+		{[]string{"JSON.GET", "doc", "$..a"}, `[4.0,"x",4.5]`},
+		
+		// A failed increment leaves every match unchanged.
+		{[]string{"JSON.NUMINCRBY", "doc", "$.big", "1e308"}, "-ERR result is not a finite number"},
+		{[]string{"JSON.GET", "doc", ".big"}, "1e308"},
+		{[]string{"JSON.NUMINCRBY", "doc", ".b", "1"}, "-WRONGTYPE wrong type of path value - expected a number"},
+		{[]string{"JSON.NUMINCRBY", "doc", ".x", "1"}, "-ERR Path '.x' does not exist"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$.a", `"1"`}, "-ERR value is not a number"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$.a", "one"}, "-ERR invalid JSON value"},
+		{[]string{"JSON.NUMINCRBY", "missing", "$.a", "1"}, "-ERR could not perform this operation on a key that doesn't exist"},
+		{[]string{"JSON.NUMINCRBY", "doc", "$.a"}, "-ERR wrong number of arguments for 'json.numincrby' command"},
+	})
+}
+
+func TestJSONArrAppendAndDel(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"JSON.SET", "doc", "$", `{"a":[1],"b":{"a":"x"},"c":[]}`}, "+OK"},
+		{[]string{"JSON.ARRAPPEND", "doc", "$.a", "2", `"three"`}, "[:3]"},
+		{[]string{"JSON.ARRAPPEND", "doc", ".a", "{}"}, ":4"},
+		{[]string{"JSON.ARRAPPEND", "doc", "$..a", "null"}, "[:5 (nil)]"},
+		{[]string{"JSON.ARRAPPEND", "doc", "$.*", "[0]"}, "[:6 (nil) :1]"},
+		{[]string{"JSON.GET", "doc", ".a"}, `[1,2,"three",{},null,[0]]`},
+		{[]string{"JSON.ARRAPPEND", "doc", ".b", "1"}, "-WRONGTYPE wrong type of path value - expected an array"},
+		{[]string{"JSON.ARRAPPEND", "doc", ".x", "1"}, "-ERR Path '.x' does not exist"},
+		{[]string{"JSON.ARRAPPEND", "doc", "$.a", "bad"}, "-ERR invalid JSON value"},
+		{[]string{"JSON.ARRAPPEND", "missing", "$.a", "1"}, "-ERR could not perform this operation on a key that doesn't exist"},
+		{[]string{"JSON.ARRAPPEND", "doc"}, "-ERR wrong number of arguments for 'json.arrappend' command"},
+		
+		{[]string{"JSON.DEL", "doc", "$.a[0]"}, ":1"},
+		{[]string{"JSON.DEL", "doc", "$.a[-1]"}, ":1"},
+		{[]string{"JSON.GET", "doc", ".a"}, `[2,"three",{},null]`},
+		{[]string{"JSON.DEL", "doc", "$..a"}, ":2"},
+		{[]string{"JSON.DEL", "doc", ".missing"}, ":0"},
+		{[]string{"JSON.GET", "doc"}, `{"b":{},"c":[[0]]}`},
+		{[]string{"JSON.DEL", "doc", "$["}, "-ERR invalid JSON path"},
+		{[]string{"JSON.DEL", "doc"}, ":1"},
+		{[]string{"EXISTS", "doc"}, ":0"},
+		{[]string{"JSON.DEL", "doc"}, ":0"},
+		{[]string{"JSON.DEL"}, "-ERR wrong number of arguments for 'json.del' command"},
+	})
+}
@@ -0,0 +1,442 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errNoSuchKey      = errors.New("no such key")
+	errIndexRange     = errors.New("index out of range")
+	errMustBePositive = errors.New("value is out of range, must be positive")
+)
+
+func asList(entry *cache.Entry) (*cache.List, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	list, ok := entry.Object.(*cache.List)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return list, nil
+}
+
+// updateList runs fn against the list at key, creating it if create is set,
+// and deletes the key once the list is left empty.
+func (h *CommandHandler) updateList(key string, create bool, fn func(list *cache.List) error) error {
+	return h.cache.Update(key, func(entry *cache.Entry) (*cache.Entry, error) {
+		list, err := asList(entry)
+		if err != nil {
+			return nil, err
+		}
+		if list == nil {
+			if !create {
+				return nil, nil
+			}
+			list = cache.NewList()
+			entry = &cache.Entry{Object: list}
+		}
+		
+		if err := fn(list); err != nil {
+			return nil, err
+		}
+		if list.Len() == 0 {
+			return nil, nil
+		}
+		return entry, nil
+	})
+}
+
+func (h *CommandHandler) viewList(key string, fn func(list *cache.List)) error {
+	return h.cache.View(key, func(entry *cache.Entry) error {
+		list, err := asList(entry)
+		if err != nil {
+			return err
+		}
+		fn(list)
+		return nil
+	})
+}
+
+// handlePush implements LPUSH, RPUSH, LPUSHX and RPUSHX. The X variants only
+// push onto lists that already exist.
+func (h *CommandHandler) handlePush(name string, args []Value, front, onlyIfExists bool) Value {
+	if len(args) < 2 {
+		return wrongArgs(name)
+	}
+	
+	length := 0
+	err := h.updateList(args[0].Str, !onlyIfExists, func(list *cache.List) error {
+		for _, arg := range args[1:] {
+			if front {
+				list.PushFront([]byte(arg.Str))
+			} else {
+				list.PushBack([]byte(arg.Str))
+			}
+		}
+		length = list.Len()
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if length > 0 {
+		h.blocked.signal(args[0].Str)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+// handlePop implements LPOP and RPOP with their optional count argument.
+func (h *CommandHandler) handlePop(name string, args []Value, front bool) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs(name)
+	}
+	
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil || n < 0 {
+			return errorReply(errMustBePositive)
+		}
+		count = int(n)
+	}
+	
+	var popped []Value
+	found := false
+	err := h.updateList(args[0].Str, false, func(list *cache.List) error {
+		found = true
+		for len(popped) < count && list.Len() > 0 {
+			popped = append(popped, NewBulkString(string(popList(list, front))))
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		if len(args) == 2 {
+			return NewNullArray()
+		}
+		return NewNullBulkString()
+	}
+	if len(args) == 1 {
+		return popped[0]
+	}
+	return NewArray(popped...)
+}
+
+func (h *CommandHandler) handleLLen(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("llen")
+	}
+	
+	length := 0
+	err := h.viewList(args[0].Str, func(list *cache.List) {
+		if list != nil {
+			length = list.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+func (h *CommandHandler) handleLRange(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("lrange")
+	}
+	
+	start, err1 := strconv.ParseInt(args[1].Str, 10, 64)
+	stop, err2 := strconv.ParseInt(args[2].Str, 10, 64)
+	if err1 != nil || err2 != nil {
+		return errorReply(errNotInteger)
+	}
+	
+	var result []Value
+	err := h.viewList(args[0].Str, func(list *cache.List) {
+		if list == nil {
+			return
+		}
+		from, to, ok := clampRange(start, stop, int64(list.Len()))
+		if !ok {
+			return
+		}
+		result = make([]Value, 0, to-from+1)
+		list.Range(int(from), int(to), func(value []byte) bool {
+			result = append(result, NewBulkString(string(value)))
+			return true
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(result...)
+}
+
+func (h *CommandHandler) handleLTrim(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("ltrim")
+	}
+	
+	start, err1 := strconv.ParseInt(args[1].Str, 10, 64)
+	stop, err2 := strconv.ParseInt(args[2].Str, 10, 64)
+	if err1 != nil || err2 != nil {
+		return errorReply(errNotInteger)
+	}
+	
+	err := h.updateList(args[0].Str, false, func(list *cache.List) error {
+		from, to, ok := clampRange(start, stop, int64(list.Len()))
+		if !ok {
+			list.Trim(list.Len(), list.Len()-1)
+			return nil
+		}
+		list.Trim(int(from), int(to))
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewSimpleString("OK")
+}
+
+func (h *CommandHandler) handleLIndex(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("lindex")
+	}
+	
+	index, err := strconv.ParseInt(args[1].Str, 10, 64)
+	if err != nil {
+		return errorReply(errNotInteger)
+	}
+	
+	var value []byte
+	found := false
+	err = h.viewList(args[0].Str, func(list *cache.List) {
+		if list != nil {
+			value, found = list.Index(int(index))
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewBulkString(string(value))
+}
+
+func (h *CommandHandler) handleLSet(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("lset")
+	}
+	
+	index, err := strconv.ParseInt(args[1].Str, 10, 64)
+	if err != nil {
+		return errorReply(errNotInteger)
+	}
+	
+	found := false
+	err = h.updateList(args[0].Str, false, func(list *cache.List) error {
+		found = true
+		if !list.Set(int(index), []byte(args[2].Str)) {
+			return errIndexRange
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		return errorReply(errNoSuchKey)
+	}
+	return NewSimpleString("OK")
+}
+
+func (h *CommandHandler) handleLMove(args []Value) Value {
+	if len(args) != 4 {
+		return wrongArgs("lmove")
+	}
+	
+	fromFront, ok1 := parseListSide(args[2].Str)
+	toFront, ok2 := parseListSide(args[3].Str)
+	if !ok1 || !ok2 {
+		return NewError("ERR syntax error")
+	}
+	
+	return h.move(args[0].Str, args[1].Str, fromFront, toFront)
+}
+
+func (h *CommandHandler) handleRPopLPush(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("rpoplpush")
+	}
+	
+	return h.move(args[0].Str, args[1].Str, false, true)
+}
+
+// move atomically pops an element off one end of src and pushes it onto one
+// end of dst, replying with the element or nil if src does not exist.
+func (h *CommandHandler) move(src, dst string, fromFront, toFront bool) Value {
+	value, found, err := h.lmove(src, dst, fromFront, toFront)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return NewNullBulkString()
+	}
+	h.blocked.signal(dst)
+	return NewBulkString(string(value))
+}
+
+func (h *CommandHandler) lmove(src, dst string, fromFront, toFront bool) ([]byte, bool, error) {
+	var value []byte
+	found := false
+	err := h.cache.UpdateMulti([]string{src, dst}, func(entries []*cache.Entry) ([]*cache.Entry, error) {
+		srcList, err := asList(entries[0])
+		if err != nil {
+			return nil, err
+		}
+		dstList, err := asList(entries[1])
+		if err != nil {
+			return nil, err
+		}
+		if srcList == nil {
+			return entries, nil
+		}
+		
+		value, found = popList(srcList, fromFront), true
+		
+		dstEntry := entries[1]
+		if dstList == nil {
+			dstList = cache.NewList()
+			dstEntry = &cache.Entry{Object: dstList}
+		}
+		if toFront {
+			dstList.PushFront(value)
+		} else {
+			dstList.PushBack(value)
+		}
+		
+		srcEntry := entries[0]
+		if srcList.Len() == 0 {
+			srcEntry = nil
+		}
+		return []*cache.Entry{srcEntry, dstEntry}, nil
+	})
+	return value, found, err
+}
+
+// handleBPop implements BLPOP and BRPOP, replying with the key and element
+// popped from the first non-empty list.
+func (h *CommandHandler) handleBPop(ctx context.Context, name string, args []Value, front bool) Value {
+	if len(args) < 2 {
+		return wrongArgs(name)
+	}
+	
+	timeout, err := parseTimeout(args[len(args)-1].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	reply, ok := h.blocked.block(ctx, valueStrings(args[:len(args)-1]), timeout, true, func(key string) (Value, bool) {
+		var value []byte
+		found := false
+		err := h.updateList(key, false, func(list *cache.List) error {
+			value, found = popList(list, front), true
+			return nil
+		})
+		if err != nil {
+			return errorReply(err), true
+		}
+		if !found {
+			return Value{}, false
+		}
+		return NewArray(NewBulkString(key), NewBulkString(string(value))), true
+	})
+	if !ok {
+		return NewNullArray()
+	}
+	return reply
+}
+
+func (h *CommandHandler) handleBLMove(ctx context.Context, args []Value) Value {
+	if len(args) != 5 {
+		return wrongArgs("blmove")
+	}
+	
+	fromFront, ok1 := parseListSide(args[2].Str)
+	toFront, ok2 := parseListSide(args[3].Str)
+	if !ok1 || !ok2 {
+		return NewError("ERR syntax error")
+	}
+	timeout, err := parseTimeout(args[4].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return h.blockingMove(ctx, args[0].Str, args[1].Str, fromFront, toFront, timeout)
+}
+
+func (h *CommandHandler) handleBRPopLPush(ctx context.Context, args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("brpoplpush")
+	}
+	
+	timeout, err := parseTimeout(args[2].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return h.blockingMove(ctx, args[0].Str, args[1].Str, false, true, timeout)
+}
+
+func (h *CommandHandler) blockingMove(ctx context.Context, src, dst string, fromFront, toFront bool, timeout time.Duration) Value {
+	reply, ok := h.blocked.block(ctx, []string{src}, timeout, true, func(key string) (Value, bool) {
+		value, found, err := h.lmove(src, dst, fromFront, toFront)
+		if err != nil {
+			return errorReply(err), true
+		}
+		if !found {
+			return Value{}, false
+		}
+		h.blocked.markReady(dst)
+		return NewBulkString(string(value)), true
+	})
+	if !ok {
+		return NewNullArray()
+	}
+	return reply
+}
+
+func popList(list *cache.List, front bool) []byte {
+	if front {
+		value, _ := list.PopFront()
+		return value
+	}
+	value, _ := list.PopBack()
+	return value
+}
+
+func parseListSide(side string) (front bool, ok bool) {
+	switch strings.ToUpper(side) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	default:
+		return false, false
+	}
+}
@@ -3,10 +3,12 @@ package protocol
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"slices"
 	"strconv"
-	"strings"
+	"unsafe"
 )
 
 const (
@@ -15,11 +17,26 @@ const (
 	Integer      = ':'
 	BulkString   = '$'
 	Array        = '*'
+	
+	// RESP3 types, sent only to connections that switched with HELLO 3.
+	Null           = '_'
+	Boolean        = '#'
+	Double         = ','
+	BigNumber      = '('
+	BlobError      = '!'
+	VerbatimString = '='
+	Map            = '%'
+	Set            = '~'
+	Attribute      = '|'
+	Push           = '>'
 )
 
 var (
 	ErrInvalidProtocol = errors.New("invalid protocol")
 	ErrInvalidType     = errors.New("invalid type")
+	
+	// errNotRESP reports a first byte that is no RESP type.
+	errNotRESP = errors.New("not a RESP type")
 )
 
 type Value struct {
@@ -27,37 +44,191 @@ type Value struct {
 	Str   string
 	Int   int64
 	Array []Value
+	// Null marks a null bulk string or array, as opposed to an empty one.
+	Null bool
+	
+	Bool  bool
+	Float float64
+	// Format is the three letter format of a verbatim string, such as txt.
+	Format string
+	// Attrs holds the key, value pairs of an attribute sent ahead of v.
+	Attrs []Value
 }
 
+// Marshal encodes v for a RESP2 connection.
 func (v Value) Marshal() []byte {
+	return v.AppendProtocol(nil, 2)
+}
+
+// MarshalProtocol encodes v for a connection speaking the given RESP
+// version.
+func (v Value) MarshalProtocol(proto int) []byte {
+	return v.AppendProtocol(nil, proto)
+}
+
+// AppendProtocol appends the encoding of v for a connection speaking the
+// given RESP version to buf and returns the extended buffer, so a connection
+// can encode every reply into the same buffer. RESP2 lacks the RESP3 types,
+// so they are sent as the RESP2 replies Redis falls back to: nulls as null
+// bulk strings, booleans as integers, maps, sets and pushes as flat arrays,
+// and doubles, big numbers and verbatim strings as bulk strings. Attributes
+// are dropped.
+func (v Value) AppendProtocol(buf []byte, proto int) []byte {
+	if len(v.Attrs) > 0 && proto >= 3 {
+		buf = appendAggregate(buf, Attribute, len(v.Attrs)/2, v.Attrs, proto)
+	}
+	
 	switch v.Type {
-	case SimpleString:
-		return []byte(fmt.Sprintf("+%s\r\n", v.Str))
-	case Error:
-		return []byte(fmt.Sprintf("-%s\r\n", v.Str))
+	case SimpleString, Error:
+		return appendLine(buf, v.Type, v.Str)
 	case Integer:
-		return []byte(fmt.Sprintf(":%d\r\n", v.Int))
+		return appendInt(buf, Integer, v.Int)
 	case BulkString:
-		if v.Str == "" {
-			return []byte("$-1\r\n")
+		if v.Null {
+			return appendNull(buf, BulkString, proto)
 		}
-		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(v.Str), v.Str))
+		return appendBulk(buf, BulkString, v.Str)
 	case Array:
-		if len(v.Array) == 0 {
-			return []byte("*0\r\n")
+		if v.Null {
+			return appendNull(buf, Array, proto)
 		}
-		result := []byte(fmt.Sprintf("*%d\r\n", len(v.Array)))
-		for _, item := range v.Array {
-			result = append(result, item.Marshal()...)
+		return appendAggregate(buf, Array, len(v.Array), v.Array, proto)
+	}
+	
+	if proto < 3 {
+		switch v.Type {
+		case Null:
+			return appendNull(buf, BulkString, proto)
+		case Boolean:
+			if v.Bool {
+				return append(buf, ":1\r\n"...)
+			}
+			return append(buf, ":0\r\n"...)
+		case Double:
+			return appendBulk(buf, BulkString, formatDouble(v.Float))
+		case BigNumber:
+			return appendBulk(buf, BulkString, v.Str)
+		case VerbatimString:
+			return appendVerbatim(buf, BulkString, v.Format, v.Str)
+		case BlobError:
+			return appendLine(buf, Error, v.Str)
+		case Map, Set, Push:
+			return appendAggregate(buf, Array, len(v.Array), v.Array, proto)
 		}
-		return result
-	default:
-		return []byte("-ERR unknown type\r\n")
+		return append(buf, "-ERR unknown type\r\n"...)
 	}
+	
+	switch v.Type {
+	case Null:
+		return append(buf, "_\r\n"...)
+	case Boolean:
+		if v.Bool {
+			return append(buf, "#t\r\n"...)
+		}
+		return append(buf, "#f\r\n"...)
+	case Double:
+		return appendLine(buf, Double, formatDouble(v.Float))
+	case BigNumber:
+		return appendLine(buf, BigNumber, v.Str)
+	case BlobError:
+		return appendBulk(buf, BlobError, v.Str)
+	case VerbatimString:
+		return appendVerbatim(buf, VerbatimString, v.Format, v.Str)
+	case Map:
+		return appendAggregate(buf, Map, len(v.Array)/2, v.Array, proto)
+	case Set, Push:
+		return appendAggregate(buf, v.Type, len(v.Array), v.Array, proto)
+	}
+	return append(buf, "-ERR unknown type\r\n"...)
 }
 
+func appendLine(buf []byte, prefix byte, s string) []byte {
+	buf = append(buf, prefix)
+	buf = append(buf, s...)
+	return append(buf, '\r', '\n')
+}
+
+func appendInt(buf []byte, prefix byte, n int64) []byte {
+	buf = append(buf, prefix)
+	buf = strconv.AppendInt(buf, n, 10)
+	return append(buf, '\r', '\n')
+}
+
+func appendBulk(buf []byte, prefix byte, s string) []byte {
+	buf = appendInt(buf, prefix, int64(len(s)))
+	buf = append(buf, s...)
+	return append(buf, '\r', '\n')
+}
+
+// appendVerbatim writes a verbatim string, whose payload is its format, a
+// colon and the text. RESP2 sends the payload as a bulk string.
+func appendVerbatim(buf []byte, prefix byte, format, s string) []byte {
+	buf = appendInt(buf, prefix, int64(len(format)+1+len(s)))
+	buf = append(buf, format...)
+	buf = append(buf, ':')
+	buf = append(buf, s...)
+	return append(buf, '\r', '\n')
+}
+
+// appendNull writes the null of a RESP2 bulk string or array, or the
+// single RESP3 null.
+func appendNull(buf []byte, kind byte, proto int) []byte {
+	if proto >= 3 {
+		return append(buf, "_\r\n"...)
+	}
+	buf = append(buf, kind)
+	return append(buf, "-1\r\n"...)
+}
+
+func appendAggregate(buf []byte, prefix byte, n int, items []Value, proto int) []byte {
+	buf = appendInt(buf, prefix, int64(n))
+	for _, item := range items {
+		buf = item.AppendProtocol(buf, proto)
+	}
+	return buf
+}
+
+// formatDouble renders a double like a sorted set score, spelling NaN the
+// way RESP3 does.
+func formatDouble(f float64) string {
+	if math.IsNaN(f) {
+		return "nan"
+	}
+	return formatScore(f)
+}
+
+const (
+	// maxReusedPayload is the largest bulk payload read into the parser's
+	// shared buffer; bigger ones get an allocation of their own, which
+	// saves copying the buffer as it grows.
+	maxReusedPayload = 16 * 1024
+	
+	// maxReusedBuffer and maxReusedArgs bound what a parser keeps between
+	// commands, so one huge command does not pin its memory for the rest
+	// of the connection.
+	maxReusedBuffer = 64 * 1024
+	maxReusedArgs   = 1024
+	
+	// maxAggregateLength bounds the count of an array, map or other
+	// aggregate, so doubling a map's count cannot overflow.
+	maxAggregateLength = math.MaxInt32
+	
+	// maxPreallocItems caps the items allocated up front for an aggregate,
+	// whose count is sent by the client before any of the items.
+	maxPreallocItems = 1024
+)
+
+// Parser reads RESP values. To avoid allocating per command, the strings and
+// top-level array of a parsed value share buffers the parser reuses: they
+// are only valid until the next call to Parse, and anything kept longer must
+// be copied.
 type Parser struct {
 	reader *bufio.Reader
+	
+	buf  []byte
+	args []Value
+	// line holds lines too long for the reader's buffer.
+	line []byte
 }
 
 func NewParser(reader io.Reader) *Parser {
@@ -66,12 +237,72 @@ func NewParser(reader io.Reader) *Parser {
 	}
 }
 
+// Parse reads the next value. A line that does not start with a RESP type
+// is taken as an inline command, as typed into telnet or nc, and returned
+// as an array of bulk strings; blank lines are skipped.
 func (p *Parser) Parse() (Value, error) {
+	p.reset()
+	for {
+		typeByte, err := p.reader.ReadByte()
+		if err != nil {
+			return Value{}, err
+		}
+		
+		var value Value
+		if typeByte == Array {
+			value, err = p.parseArray(true)
+		} else {
+			value, err = p.parseValue(typeByte)
+		}
+		if err != errNotRESP {
+			return value, err
+		}
+		
+		p.reader.UnreadByte()
+		args, err := p.parseInline()
+		if err != nil {
+			return Value{}, err
+		}
+		if len(args) > 0 {
+			return NewArray(args...), nil
+		}
+	}
+}
+
+// reset hands the buffers backing the previous value over to the next one,
+// dropping any that grew too large to keep.
+func (p *Parser) reset() {
+	if cap(p.buf) > maxReusedBuffer {
+		p.buf = nil
+	}
+	p.buf = p.buf[:0]
+	// Clearing args lets payloads that got their own allocation go.
+	clear(p.args)
+	if cap(p.args) > maxReusedArgs {
+		p.args = nil
+	}
+	p.args = p.args[:0]
+	if cap(p.line) > maxReusedBuffer {
+		p.line = nil
+	}
+}
+
+// parseNext reads a value nested in another, where inline commands cannot
+// appear.
+func (p *Parser) parseNext() (Value, error) {
 	typeByte, err := p.reader.ReadByte()
 	if err != nil {
 		return Value{}, err
 	}
+	
+	value, err := p.parseValue(typeByte)
+	if err == errNotRESP {
+		return Value{}, ErrInvalidType
+	}
+	return value, err
+}
 
+func (p *Parser) parseValue(typeByte byte) (Value, error) {
 	switch typeByte {
 	case SimpleString:
 		return p.parseSimpleString()
@@ -82,18 +313,47 @@ func (p *Parser) Parse() (Value, error) {
 	case BulkString:
 		return p.parseBulkString()
 	case Array:
-		return p.parseArray()
+		return p.parseArray(false)
+	case Null:
+		return p.parseNull()
+	case Boolean:
+		return p.parseBoolean()
+	case Double:
+		return p.parseDouble()
+	case BigNumber:
+		return p.parseBigNumber()
+	case BlobError:
+		return p.parseBlobError()
+	case VerbatimString:
+		return p.parseVerbatimString()
+	case Map, Set, Push:
+		return p.parseAggregate(typeByte)
+	case Attribute:
+		return p.parseAttribute()
 	default:
-		return Value{}, ErrInvalidType
+		return Value{}, errNotRESP
 	}
 }
 
+// Buffered returns the number of bytes read from the connection but not
+// parsed yet, which is nonzero while a client is pipelining commands.
+func (p *Parser) Buffered() int {
+	return p.reader.Buffered()
+}
+
+// Peek blocks until more input is available or the reader fails, without
+// consuming anything.
+func (p *Parser) Peek() error {
+	_, err := p.reader.Peek(1)
+	return err
+}
+
 func (p *Parser) parseSimpleString() (Value, error) {
 	line, err := p.readLine()
 	if err != nil {
 		return Value{}, err
 	}
-	return Value{Type: SimpleString, Str: line}, nil
+	return Value{Type: SimpleString, Str: p.text(line)}, nil
 }
 
 func (p *Parser) parseError() (Value, error) {
@@ -101,97 +361,288 @@ func (p *Parser) parseError() (Value, error) {
 	if err != nil {
 		return Value{}, err
 	}
-	return Value{Type: Error, Str: line}, nil
+	return Value{Type: Error, Str: p.text(line)}, nil
 }
 
 func (p *Parser) parseInteger() (Value, error) {
-	line, err := p.readLine()
-	if err != nil {
-		return Value{}, err
-	}
-	
-	num, err := strconv.ParseInt(line, 10, 64)
+	num, err := p.readInt()
 	if err != nil {
 		return Value{}, err
 	}
-	
 	return Value{Type: Integer, Int: num}, nil
 }
 
 func (p *Parser) parseBulkString() (Value, error) {
-	line, err := p.readLine()
+	data, null, err := p.readBulk()
 	if err != nil {
 		return Value{}, err
 	}
-	
-	length, err := strconv.Atoi(line)
+	if null {
+		return NewNullBulkString(), nil
+	}
+	return Value{Type: BulkString, Str: data}, nil
+}
+
+// readBulk reads the length-prefixed payload of a bulk string, blob error
+// or verbatim string, reporting a length of -1 as null.
+func (p *Parser) readBulk() (string, bool, error) {
+	length, err := p.readInt()
 	if err != nil {
-		return Value{}, err
+		return "", false, err
 	}
 	
 	if length == -1 {
-		return Value{Type: BulkString, Str: ""}, nil
+		return "", true, nil
 	}
 	
-	if length < 0 {
-		return Value{}, ErrInvalidProtocol
+	if length < 0 || length > maxStringLength {
+		return "", false, ErrInvalidProtocol
 	}
 	
-	data := make([]byte, length+2)
+	data := p.alloc(int(length))
 	_, err = io.ReadFull(p.reader, data)
 	if err != nil {
-		return Value{}, err
+		return "", false, err
 	}
 	
-	if data[length] != '\r' || data[length+1] != '\n' {
-		return Value{}, ErrInvalidProtocol
+	if err := p.readCRLF(); err != nil {
+		return "", false, err
 	}
 	
-	return Value{Type: BulkString, Str: string(data[:length])}, nil
+	return bytesString(data), false, nil
 }
 
-func (p *Parser) parseArray() (Value, error) {
-	line, err := p.readLine()
+// parseArray reads an array. The items of a top-level array, which is how
+// clients send commands, go into the reused args.
+func (p *Parser) parseArray(top bool) (Value, error) {
+	count, err := p.readInt()
 	if err != nil {
 		return Value{}, err
 	}
 	
-	count, err := strconv.Atoi(line)
-	if err != nil {
-		return Value{}, err
+	if count == -1 {
+		return NewNullArray(), nil
 	}
 	
 	if count == 0 {
 		return Value{Type: Array, Array: []Value{}}, nil
 	}
 	
-	if count < 0 {
+	if count < 0 || count > maxAggregateLength {
 		return Value{}, ErrInvalidProtocol
 	}
 	
-	array := make([]Value, count)
-	for i := 0; i < count; i++ {
-		value, err := p.Parse()
+	if !top {
+		array, err := p.parseItems(int(count))
 		if err != nil {
 			return Value{}, err
 		}
-		array[i] = value
+		return Value{Type: Array, Array: array}, nil
+	}
+	
+	p.args, err = p.appendItems(p.args[:0], int(count))
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: Array, Array: p.args}, nil
+}
+
+func (p *Parser) parseItems(count int) ([]Value, error) {
+	return p.appendItems(make([]Value, 0, min(count, maxPreallocItems)), count)
+}
+
+func (p *Parser) appendItems(items []Value, count int) ([]Value, error) {
+	for i := 0; i < count; i++ {
+		value, err := p.parseNext()
+		if err != nil {
+			return items, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+func (p *Parser) parseNull() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	if len(line) != 0 {
+		return Value{}, ErrInvalidProtocol
+	}
+	return NewNull(), nil
+}
+
+func (p *Parser) parseBoolean() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	switch bytesString(line) {
+	case "t":
+		return NewBoolean(true), nil
+	case "f":
+		return NewBoolean(false), nil
+	default:
+		return Value{}, ErrInvalidProtocol
+	}
+}
+
+func (p *Parser) parseDouble() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
 	}
 	
-	return Value{Type: Array, Array: array}, nil
+	f, err := strconv.ParseFloat(bytesString(line), 64)
+	if err != nil {
+		return Value{}, ErrInvalidProtocol
+	}
+	return NewDouble(f), nil
 }
 
-func (p *Parser) readLine() (string, error) {
-	line, err := p.reader.ReadString('\n')
+func (p *Parser) parseBigNumber() (Value, error) {
+	line, err := p.readLine()
 	if err != nil {
-		return "", err
+		return Value{}, err
 	}
 	
-	if len(line) < 2 || line[len(line)-2] != '\r' || line[len(line)-1] != '\n' {
-		return "", ErrInvalidProtocol
+	if _, ok := new(big.Int).SetString(bytesString(line), 10); !ok {
+		return Value{}, ErrInvalidProtocol
+	}
+	return NewBigNumber(p.text(line)), nil
+}
+
+func (p *Parser) parseBlobError() (Value, error) {
+	data, null, err := p.readBulk()
+	if err != nil {
+		return Value{}, err
+	}
+	if null {
+		return Value{}, ErrInvalidProtocol
+	}
+	return Value{Type: BlobError, Str: data}, nil
+}
+
+func (p *Parser) parseVerbatimString() (Value, error) {
+	data, null, err := p.readBulk()
+	if err != nil {
+		return Value{}, err
+	}
+	if null || len(data) < 4 || data[3] != ':' {
+		return Value{}, ErrInvalidProtocol
+	}
+	return NewVerbatimString(data[:3], data[4:]), nil
+}
+
+// parseAggregate reads a map, set or push. Maps hold their keys and values
+// alternately in Array.
+func (p *Parser) parseAggregate(typ byte) (Value, error) {
+	count, err := p.readInt()
+	if err != nil {
+		return Value{}, err
+	}
+	if count < 0 || count > maxAggregateLength {
+		return Value{}, ErrInvalidProtocol
+	}
+	if typ == Map || typ == Attribute {
+		count *= 2
 	}
 	
-	return strings.TrimSuffix(line, "\r\n"), nil
+	items, err := p.parseItems(int(count))
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: typ, Array: items}, nil
+}
+
+// parseAttribute reads an attribute and attaches it to the value following
+// it.
+func (p *Parser) parseAttribute() (Value, error) {
+	attrs, err := p.parseAggregate(Attribute)
+	if err != nil {
+		return Value{}, err
+	}
+	
+	value, err := p.parseNext()
+	if err != nil {
+		return Value{}, err
+	}
+	value.Attrs = attrs.Array
+	return value, nil
+}
+
+// readLine returns the next line without its CRLF. The line points into the
+// reader's buffer, or p.line if it did not fit there, so it is only valid
+// until the next read.
+func (p *Parser) readLine() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		p.line = append(p.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = p.reader.ReadSlice('\n')
+			p.line = append(p.line, line...)
+		}
+		line = p.line
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrInvalidProtocol
+	}
+	
+	return line[:len(line)-2], nil
+}
+
+// readInt reads a line holding an integer, such as the length of a bulk
+// string or the count of an array.
+func (p *Parser) readInt() (int64, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(bytesString(line), 10, 64)
+}
+
+func (p *Parser) readCRLF() error {
+	cr, err := p.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	lf, err := p.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if cr != '\r' || lf != '\n' {
+		return ErrInvalidProtocol
+	}
+	return nil
+}
+
+// alloc returns n bytes for a payload of the value being parsed, taken from
+// the reused buffer unless the payload is too big for it.
+func (p *Parser) alloc(n int) []byte {
+	if n > maxReusedPayload {
+		return make([]byte, n)
+	}
+	start := len(p.buf)
+	p.buf = slices.Grow(p.buf, n)[:start+n]
+	return p.buf[start : start+n : start+n]
+}
+
+// text copies b into the reused buffer and returns it as a string.
+func (p *Parser) text(b []byte) string {
+	data := p.alloc(len(b))
+	copy(data, b)
+	return bytesString(data)
+}
+
+// bytesString returns b as a string without copying it, so b must not be
+// written to while the string is in use.
+func bytesString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
 }
 
 func NewSimpleString(s string) Value {
@@ -210,6 +661,48 @@ func NewBulkString(s string) Value {
 	return Value{Type: BulkString, Str: s}
 }
 
+func NewNullBulkString() Value {
+	return Value{Type: BulkString, Null: true}
+}
+
 func NewArray(values ...Value) Value {
 	return Value{Type: Array, Array: values}
+}
+
+func NewNullArray() Value {
+	return Value{Type: Array, Null: true}
+}
+
+func NewNull() Value {
+	return Value{Type: Null, Null: true}
+}
+
+func NewBoolean(b bool) Value {
+	return Value{Type: Boolean, Bool: b}
+}
+
+func NewDouble(f float64) Value {
+	return Value{Type: Double, Float: f}
+}
+
+// NewBigNumber returns a big number from its decimal digits.
+func NewBigNumber(digits string) Value {
+	return Value{Type: BigNumber, Str: digits}
+}
+
+func NewVerbatimString(format, s string) Value {
+	return Value{Type: VerbatimString, Format: format, Str: s}
+}
+
+// NewMap returns a map from alternating keys and values.
+func NewMap(pairs ...Value) Value {
+	return Value{Type: Map, Array: pairs}
+}
+
+func NewSet(values ...Value) Value {
+	return Value{Type: Set, Array: values}
+}
+
+func NewPush(values ...Value) Value {
+	return Value{Type: Push, Array: values}
 }
\ No newline at end of file
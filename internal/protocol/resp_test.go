@@ -0,0 +1,300 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// loopReader replays data forever, standing in for a client that keeps
+// sending the same command.
+type loopReader struct {
+	data []byte
+	off  int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		m := copy(p[n:], r.data[r.off:])
+		n += m
+		r.off = (r.off + m) % len(r.data)
+	}
+	return n, nil
+}
+
+func command(args ...string) []byte {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		values[i] = NewBulkString(arg)
+	}
+	return NewArray(values...).Marshal()
+}
+
+func TestParserReuse(t *testing.T) {
+	values := []Value{
+		NewArray(NewBulkString("SET"), NewBulkString("key"), NewBulkString(string(make([]byte, maxReusedPayload+1)))),
+		NewArray(NewBulkString("GET"), NewBulkString("")),
+		NewSimpleString("OK"),
+		NewArray(NewInteger(-42), NewNullBulkString(), NewArray(NewBulkString("nested")), NewNullArray()),
+		NewMap(NewBulkString("proto"), NewInteger(3), NewBulkString("pi"), NewDouble(3.5)),
+		NewVerbatimString("txt", "some text"),
+		NewArray(NewBulkString("DEL"), NewBulkString("a"), NewBulkString("b")),
+	}
+	var input []byte
+	for _, v := range values {
+		input = v.AppendProtocol(input, 3)
+	}
+	
+	parser := NewParser(bytes.NewReader(input))
+	for _, want := range values {
+		got, err := parser.Parse()
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if !bytes.Equal(got.MarshalProtocol(3), want.MarshalProtocol(3)) {
+			t.Errorf("got %q, want %q", got.MarshalProtocol(3), want.MarshalProtocol(3))
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	parser := NewParser(&loopReader{data: command("SET", "key:000001", "some value of moderate length")})
+	
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.Parse(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendProtocol(b *testing.B) {
+	reply := NewArray(NewBulkString("field"), NewInteger(42), NewNullBulkString(), NewSimpleString("OK"))
+	var out []byte
+	
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out = reply.AppendProtocol(out[:0], 2)
+	}
+}
+
+// benchmarkCommand runs a command the way a connection does: parse it,
+// execute it and encode the reply into the connection's buffer.
+func benchmarkCommand(b *testing.B, handler *CommandHandler, cmd []byte) {
+	parser := NewParser(&loopReader{data: cmd})
+	session := NewSession()
+	var out []byte
+	
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		value, err := parser.Parse()
+		if err != nil {
+			b.Fatal(err)
+		}
+		response := handler.ExecuteSession(context.Background(), session, value)
+		out = response.AppendProtocol(out[:0], session.Protocol)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	handler.cache.Set("key:000001", []byte("some value of moderate length"), 0)
+	benchmarkCommand(b, handler, command("get", "key:000001"))
+}
+
+func BenchmarkSet(b *testing.B) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	benchmarkCommand(b, handler, command("set", "key:000001", "some value of moderate length"))
+}
+
+// TestPipelineOwnsStoredStrings runs commands through one parser, as a
+// connection does, and checks that what they stored survives the parser
+// reusing its buffer for the commands after them.
+func TestPipelineOwnsStoredStrings(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"JSON.SET", "j", "$", `{"a":1}`}, "+OK\r\n"},
+		{[]string{"JSON.NUMINCRBY", "j", "$.a", "1"}, "$3\r\n[2]\r\n"},
+		{[]string{"HSET", "h", "field", "v"}, ":1\r\n"},
+		{[]string{"SADD", "s", "member"}, ":1\r\n"},
+		{[]string{"ZADD", "z", "1", "member"}, ":1\r\n"},
+		{[]string{"XADD", "x", "1-1", "f", "v"}, "$3\r\n1-1\r\n"},
+		{[]string{"XGROUP", "CREATE", "x", "g", "0"}, "+OK\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", ">"}, "*1\r\n*2\r\n$1\r\nx\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"XCLAIM", "x", "g", "bob", "0", "1-1", "JUSTID"}, "*1\r\n$3\r\n1-1\r\n"},
+		{[]string{"SET", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx", "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy"}, "+OK\r\n"},
+		{[]string{"SET", "yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}, "+OK\r\n"},
+		{[]string{"JSON.NUMINCRBY", "j", ".a", "1"}, "$1\r\n3\r\n"},
+		{[]string{"JSON.GET", "j"}, "$7\r\n{\"a\":3}\r\n"},
+		{[]string{"HGET", "h", "field"}, "$1\r\nv\r\n"},
+		{[]string{"SISMEMBER", "s", "member"}, ":1\r\n"},
+		{[]string{"ZSCORE", "z", "member"}, "$1\r\n1\r\n"},
+		{[]string{"XPENDING", "x", "g"}, "*4\r\n:1\r\n$3\r\n1-1\r\n$3\r\n1-1\r\n*1\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n"},
+	}
+	var input []byte
+	for _, step := range steps {
+		input = append(input, command(step.args...)...)
+	}
+	
+	parser := NewParser(bytes.NewReader(input))
+	session := NewSession()
+	for _, step := range steps {
+		value, err := parser.Parse()
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		got := handler.ExecuteSession(context.Background(), session, value).Marshal()
+		if string(got) != step.want {
+			t.Errorf("%v: got %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+func TestNullVersusEmpty(t *testing.T) {
+	tests := []struct {
+		value Value
+		resp2 string
+		resp3 string
+	}{
+		{NewBulkString(""), "$0\r\n\r\n", "$0\r\n\r\n"},
+		{NewNullBulkString(), "$-1\r\n", "_\r\n"},
+		{NewArray(), "*0\r\n", "*0\r\n"},
+		{NewNullArray(), "*-1\r\n", "_\r\n"},
+		{NewArray(NewBulkString(""), NewNullBulkString(), NewArray(), NewNullArray()), "*4\r\n$0\r\n\r\n$-1\r\n*0\r\n*-1\r\n", "*4\r\n$0\r\n\r\n_\r\n*0\r\n_\r\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.value.MarshalProtocol(2); string(got) != tt.resp2 {
+			t.Errorf("MarshalProtocol(%+v, 2) = %q, want %q", tt.value, got, tt.resp2)
+		}
+		if got := tt.value.MarshalProtocol(3); string(got) != tt.resp3 {
+			t.Errorf("MarshalProtocol(%+v, 3) = %q, want %q", tt.value, got, tt.resp3)
+		}
+		
+		got, err := NewParser(bytes.NewReader([]byte(tt.resp2))).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.resp2, err)
+			continue
+		}
+		if !sameNulls(got, tt.value) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.resp2, got, tt.value)
+		}
+	}
+	
+	handler := NewCommandHandler(cache.New(1024*1024, nil))
+	session := NewSession()
+	execute(handler, session, "SET", "empty", "")
+	for _, step := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "empty"}, "$0\r\n\r\n"},
+		{[]string{"GET", "missing"}, "$-1\r\n"},
+		{[]string{"MGET", "empty", "missing"}, "*2\r\n$0\r\n\r\n$-1\r\n"},
+		{[]string{"LRANGE", "missing", "0", "-1"}, "*0\r\n"},
+		{[]string{"BLPOP", "missing", "0.001"}, "*-1\r\n"},
+	} {
+		if got := execute(handler, session, step.args...).Marshal(); string(got) != step.want {
+			t.Errorf("%v: got %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+// sameNulls reports whether a and b have the same shape, telling null
+// strings and arrays apart from empty ones.
+func sameNulls(a, b Value) bool {
+	if a.Type != b.Type || a.Null != b.Null || a.Str != b.Str || len(a.Array) != len(b.Array) {
+		return false
+	}
+	for i := range a.Array {
+		if !sameNulls(a.Array[i], b.Array[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseRESP3(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Value
+	}{
+		{"_\r\n", NewNull()},
+		{"#t\r\n", NewBoolean(true)},
+		{"#f\r\n", NewBoolean(false)},
+		{",3.25\r\n", NewDouble(3.25)},
+		{",-inf\r\n", NewDouble(math.Inf(-1))},
+		{",nan\r\n", NewDouble(math.NaN())},
+		{"(3492890328409238509324850943850943825024385\r\n", NewBigNumber("3492890328409238509324850943850943825024385")},
+		{"!21\r\nSYNTAX invalid syntax\r\n", Value{Type: BlobError, Str: "SYNTAX invalid syntax"}},
+		{"=15\r\ntxt:Some string\r\n", NewVerbatimString("txt", "Some string")},
+		{"%2\r\n+first\r\n:1\r\n+second\r\n#f\r\n", NewMap(NewSimpleString("first"), NewInteger(1), NewSimpleString("second"), NewBoolean(false))},
+		{"~2\r\n$1\r\na\r\n,1.5\r\n", NewSet(NewBulkString("a"), NewDouble(1.5))},
+		{">2\r\n$7\r\nmessage\r\n_\r\n", NewPush(NewBulkString("message"), NewNull())},
+		{"|1\r\n+ttl\r\n:3600\r\n+value\r\n", Value{Type: SimpleString, Str: "value", Attrs: []Value{NewSimpleString("ttl"), NewInteger(3600)}}},
+	}
+	for _, tt := range tests {
+		got, err := NewParser(bytes.NewReader([]byte(tt.input))).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if got.Type != tt.want.Type {
+			t.Errorf("Parse(%q) returned type %q, want %q", tt.input, got.Type, tt.want.Type)
+		}
+		if encoded := got.MarshalProtocol(3); string(encoded) != tt.input {
+			t.Errorf("Parse(%q) re-encodes as %q", tt.input, encoded)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		"_x\r\n",
+		"#x\r\n",
+		",abc\r\n",
+		"(12a\r\n",
+		"!-1\r\n",
+		"=3\r\ntxt\r\n",
+		"$3\r\nabcd\r\n",
+		"$536870913\r\n",
+		"*-2\r\n",
+		"*4611686018427387904\r\n",
+		"%4611686018427387904\r\n",
+		"|4611686018427387904\r\n",
+	} {
+		if _, err := NewParser(bytes.NewReader([]byte(input))).Parse(); err != ErrInvalidProtocol {
+			t.Errorf("Parse(%q) returned %v, want ErrInvalidProtocol", input, err)
+		}
+	}
+}
+
+func TestMarshalRESP2Fallbacks(t *testing.T) {
+	tests := []struct {
+		value Value
+		want  string
+	}{
+		{NewNull(), "$-1\r\n"},
+		{NewBoolean(true), ":1\r\n"},
+		{NewBoolean(false), ":0\r\n"},
+		{NewDouble(1.5), "$3\r\n1.5\r\n"},
+		{NewBigNumber("12345678901234567890"), "$20\r\n12345678901234567890\r\n"},
+		{Value{Type: BlobError, Str: "ERR oops"}, "-ERR oops\r\n"},
+		{NewVerbatimString("txt", "hi"), "$6\r\ntxt:hi\r\n"},
+		{NewMap(NewBulkString("a"), NewInteger(1)), "*2\r\n$1\r\na\r\n:1\r\n"},
+		{NewSet(NewBulkString("a")), "*1\r\n$1\r\na\r\n"},
+		{NewPush(NewBulkString("a")), "*1\r\n$1\r\na\r\n"},
+		{Value{Type: Integer, Int: 7, Attrs: []Value{NewBulkString("k"), NewBulkString("v")}}, ":7\r\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.value.Marshal(); string(got) != tt.want {
+			t.Errorf("Marshal(%+v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
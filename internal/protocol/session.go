@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	errNoProto        = codedError("NOPROTO unsupported protocol version")
+	errProtoVersion   = errors.New("Protocol version is not an integer or out of range")
+	errWrongPass      = codedError("WRONGPASS invalid username-password pair or user is disabled.")
+	errClientNameChar = errors.New("Client names cannot contain spaces, newlines or special characters.")
+)
+
+var lastSessionID int64
+
+// Session is the state of one client connection.
+type Session struct {
+	ID int64
+	// Protocol is the RESP version replies are encoded with: 2 until the
+	// client switches with HELLO 3.
+	Protocol int
+	Name     string
+}
+
+func NewSession() *Session {
+	return &Session{
+		ID:       atomic.AddInt64(&lastSessionID, 1),
+		Protocol: 2,
+	}
+}
+
+func (h *CommandHandler) handleHello(session *Session, args []Value) Value {
+	proto := session.Protocol
+	if len(args) > 0 {
+		n, err := strconv.ParseInt(args[0].Str, 10, 64)
+		if err != nil {
+			return errorReply(errProtoVersion)
+		}
+		if n != 2 && n != 3 {
+			return errorReply(errNoProto)
+		}
+		proto = int(n)
+	}
+	
+	name, rename := "", false
+	for i := 1; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i].Str, "AUTH") && i+2 < len(args):
+			// Without configured users everybody is the default user,
+			// which accepts any password.
+			if args[i+1].Str != "default" {
+				return errorReply(errWrongPass)
+			}
+			i += 2
+		case strings.EqualFold(args[i].Str, "SETNAME") && i+1 < len(args):
+			name, rename = args[i+1].Str, true
+			for j := 0; j < len(name); j++ {
+				if name[j] < '!' || name[j] > '~' {
+					return errorReply(errClientNameChar)
+				}
+			}
+			i++
+		default:
+			return NewError("ERR Syntax error in HELLO option '" + args[i].Str + "'")
+		}
+	}
+	
+	// The reply already uses the protocol being switched to.
+	session.Protocol = proto
+	if rename {
+		// The name outlives the request, whose strings the parser reuses.
+		session.Name = strings.Clone(name)
+	}
+	return NewMap(
+		NewBulkString("server"), NewBulkString("hpcs"),
+		NewBulkString("version"), NewBulkString("1.0.0"),
+		NewBulkString("proto"), NewInteger(int64(proto)),
+		NewBulkString("id"), NewInteger(session.ID),
+		NewBulkString("mode"), NewBulkString("standalone"),
+		NewBulkString("role"), NewBulkString("master"),
+		NewBulkString("modules"), NewArray(),
+	)
+}
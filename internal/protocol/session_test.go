@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func hello(handler *CommandHandler, session *Session, args ...string) Value {
+	return execute(handler, session, append([]string{"HELLO"}, args...)...)
+}
+
+func TestHello(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024, nil))
+	session := NewSession()
+	
+	reply := hello(handler, session)
+	if reply.Type != Map || session.Protocol != 2 {
+		t.Fatalf("HELLO without a version should keep RESP2, got %+v on protocol %d", reply, session.Protocol)
+	}
+	if got := string(reply.Marshal()); got[:2] != "*1" {
+		t.Errorf("Expected the RESP2 reply to be a flat array, got %q", got)
+	}
+	
+	reply = hello(handler, session, "3", "AUTH", "default", "secret", "SETNAME", "worker-1")
+	if reply.Type != Map || session.Protocol != 3 || session.Name != "worker-1" {
+		t.Fatalf("HELLO 3 should switch protocols and name the session, got %+v", session)
+	}
+	fields := map[string]Value{}
+	for i := 0; i+1 < len(reply.Array); i += 2 {
+		fields[reply.Array[i].Str] = reply.Array[i+1]
+	}
+	if fields["proto"].Int != 3 || fields["id"].Int != session.ID || fields["server"].Str != "hpcs" {
+		t.Errorf("Unexpected HELLO fields %+v", fields)
+	}
+	
+	failures := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"4"}, "NOPROTO unsupported protocol version"},
+		{[]string{"three"}, "ERR Protocol version is not an integer or out of range"},
+		{[]string{"3", "AUTH", "admin", "secret"}, "WRONGPASS invalid username-password pair or user is disabled."},
+		{[]string{"3", "SETNAME", "bad name"}, "ERR Client names cannot contain spaces, newlines or special characters."},
+		{[]string{"3", "BOGUS"}, "ERR Syntax error in HELLO option 'BOGUS'"},
+	}
+	for _, tt := range failures {
+		reply := hello(handler, session, tt.args...)
+		if reply.Type != Error || reply.Str != tt.want {
+			t.Errorf("HELLO %v: got %+v, want error %q", tt.args, reply, tt.want)
+		}
+	}
+	if session.Protocol != 3 || session.Name != "worker-1" {
+		t.Errorf("A failed HELLO must not change the session, got %+v", session)
+	}
+	
+	hello(handler, session, "2")
+	if session.Protocol != 2 {
+		t.Errorf("Expected HELLO 2 to switch back, got protocol %d", session.Protocol)
+	}
+}
@@ -0,0 +1,444 @@
+package protocol
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+type setOp int
+
+const (
+	setUnion setOp = iota
+	setInter
+	setDiff
+)
+
+func asSet(entry *cache.Entry) (*cache.Set, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	set, ok := entry.Object.(*cache.Set)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return set, nil
+}
+
+// updateSet runs fn against the set at key, creating it if create is set,
+// and deletes the key once the set is left empty.
+func (h *CommandHandler) updateSet(key string, create bool, fn func(set *cache.Set) error) error {
+	return h.cache.Update(key, func(entry *cache.Entry) (*cache.Entry, error) {
+		set, err := asSet(entry)
+		if err != nil {
+			return nil, err
+		}
+		if set == nil {
+			if !create {
+				return nil, nil
+			}
+			set = cache.NewSet()
+			entry = &cache.Entry{Object: set}
+		}
+		
+		if err := fn(set); err != nil {
+			return nil, err
+		}
+		if set.Len() == 0 {
+			return nil, nil
+		}
+		return entry, nil
+	})
+}
+
+func (h *CommandHandler) viewSet(key string, fn func(set *cache.Set)) error {
+	return h.cache.View(key, func(entry *cache.Entry) error {
+		set, err := asSet(entry)
+		if err != nil {
+			return err
+		}
+		fn(set)
+		return nil
+	})
+}
+
+func (h *CommandHandler) handleSAdd(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("sadd")
+	}
+	
+	added := 0
+	err := h.updateSet(args[0].Str, true, func(set *cache.Set) error {
+		for _, arg := range args[1:] {
+			if set.Add(arg.Str) {
+				added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(added))
+}
+
+func (h *CommandHandler) handleSRem(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("srem")
+	}
+	
+	removed := 0
+	err := h.updateSet(args[0].Str, false, func(set *cache.Set) error {
+		for _, arg := range args[1:] {
+			if set.Remove(arg.Str) {
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(removed))
+}
+
+func (h *CommandHandler) handleSMembers(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("smembers")
+	}
+	
+	var members []Value
+	err := h.viewSet(args[0].Str, func(set *cache.Set) {
+		if set == nil {
+			return
+		}
+		members = make([]Value, 0, set.Len())
+		set.Range(func(member string) bool {
+			members = append(members, NewBulkString(member))
+			return true
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewSet(members...)
+}
+
+func (h *CommandHandler) handleSIsMember(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("sismember")
+	}
+	
+	found := false
+	err := h.viewSet(args[0].Str, func(set *cache.Set) {
+		found = set != nil && set.Contains(args[1].Str)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if found {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+func (h *CommandHandler) handleSMIsMember(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("smismember")
+	}
+	
+	results := make([]Value, len(args)-1)
+	err := h.viewSet(args[0].Str, func(set *cache.Set) {
+		for i, arg := range args[1:] {
+			if set != nil && set.Contains(arg.Str) {
+				results[i] = NewInteger(1)
+			} else {
+				results[i] = NewInteger(0)
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(results...)
+}
+
+func (h *CommandHandler) handleSCard(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("scard")
+	}
+	
+	length := 0
+	err := h.viewSet(args[0].Str, func(set *cache.Set) {
+		if set != nil {
+			length = set.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+// handleSetOp implements SUNION, SINTER and SDIFF over a consistent snapshot
+// of all the keys involved.
+func (h *CommandHandler) handleSetOp(name string, args []Value, op setOp) Value {
+	if len(args) < 1 {
+		return wrongArgs(name)
+	}
+	
+	var result []string
+	err := h.cache.ViewMulti(valueStrings(args), func(entries []*cache.Entry) error {
+		var err error
+		result, err = combineSets(entries, op)
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	members := make([]Value, len(result))
+	for i, member := range result {
+		members[i] = NewBulkString(member)
+	}
+	return NewArray(members...)
+}
+
+// handleSetOpStore implements SUNIONSTORE, SINTERSTORE and SDIFFSTORE,
+// replacing the destination atomically with the result.
+func (h *CommandHandler) handleSetOpStore(name string, args []Value, op setOp) Value {
+	if len(args) < 2 {
+		return wrongArgs(name)
+	}
+	
+	length := 0
+	err := h.cache.UpdateMulti(valueStrings(args), func(entries []*cache.Entry) ([]*cache.Entry, error) {
+		result, err := combineSets(entries[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		
+		next := make([]*cache.Entry, len(entries))
+		copy(next, entries)
+		next[0] = nil
+		if len(result) > 0 {
+			set := cache.NewSet()
+			for _, member := range result {
+				set.Add(member)
+			}
+			next[0] = &cache.Entry{Object: set}
+		}
+		length = len(result)
+		return next, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+func combineSets(entries []*cache.Entry, op setOp) ([]string, error) {
+	sets := make([]*cache.Set, len(entries))
+	for i, entry := range entries {
+		set, err := asSet(entry)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	
+	var result []string
+	switch op {
+	case setUnion:
+		seen := make(map[string]bool)
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			set.Range(func(member string) bool {
+				if !seen[member] {
+					seen[member] = true
+					result = append(result, member)
+				}
+				return true
+			})
+		}
+	case setInter:
+		smallest := 0
+		for i, set := range sets {
+			if set == nil {
+				return nil, nil
+			}
+			if set.Len() < sets[smallest].Len() {
+				smallest = i
+			}
+		}
+		sets[smallest].Range(func(member string) bool {
+			for i, set := range sets {
+				if i != smallest && !set.Contains(member) {
+					return true
+				}
+			}
+			result = append(result, member)
+			return true
+		})
+	case setDiff:
+		if sets[0] == nil {
+			return nil, nil
+		}
+		sets[0].Range(func(member string) bool {
+			for _, set := range sets[1:] {
+				if set != nil && set.Contains(member) {
+					return true
+				}
+			}
+			result = append(result, member)
+			return true
+		})
+	}
+	return result, nil
+}
+
+func (h *CommandHandler) handleSPop(args []Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs("spop")
+	}
+	
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil || n < 0 {
+			return errorReply(errMustBePositive)
+		}
+		count = int(n)
+	}
+	
+	var popped []Value
+	err := h.updateSet(args[0].Str, false, func(set *cache.Set) error {
+		for len(popped) < count {
+			member, ok := set.Pop()
+			if !ok {
+				break
+			}
+			popped = append(popped, NewBulkString(member))
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if len(args) == 2 {
+		return NewArray(popped...)
+	}
+	if len(popped) == 0 {
+		return NewNullBulkString()
+	}
+	return popped[0]
+}
+
+// handleSRandMember replies with one random member, or with count of them:
+// distinct ones if count is positive, possibly repeated ones if negative.
+func (h *CommandHandler) handleSRandMember(args []Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs("srandmember")
+	}
+	
+	var count int64
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil || n < -math.MaxInt64/2 {
+			return errorReply(errNotInteger)
+		}
+		count = n
+	}
+	
+	var picked []Value
+	err := h.viewSet(args[0].Str, func(set *cache.Set) {
+		if set == nil {
+			return
+		}
+		switch {
+		case len(args) == 1 || count < 0:
+			n := -count
+			if len(args) == 1 {
+				n = 1
+			}
+			for int64(len(picked)) < n {
+				member, _ := set.Random()
+				picked = append(picked, NewBulkString(member))
+			}
+		default:
+			set.Range(func(member string) bool {
+				picked = append(picked, NewBulkString(member))
+				return true
+			})
+			rand.Shuffle(len(picked), func(i, j int) {
+				picked[i], picked[j] = picked[j], picked[i]
+			})
+			if int64(len(picked)) > count {
+				picked = picked[:count]
+			}
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if len(args) == 2 {
+		return NewArray(picked...)
+	}
+	if len(picked) == 0 {
+		return NewNullBulkString()
+	}
+	return picked[0]
+}
+
+// handleSScan is a cursor scan like HSCAN, visiting up to COUNT members per
+// call. A set still encoded as an intset is small and is returned whole in
+// one reply with cursor 0, whatever COUNT asks for.
+func (h *CommandHandler) handleSScan(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("sscan")
+	}
+	
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var members []Value
+	var cursor uint64
+	err = h.viewSet(args[0].Str, func(set *cache.Set) {
+		if set == nil {
+			return
+		}
+		cursor = set.Scan(opts.cursor, opts.count, func(member string) {
+			if opts.pattern == "" || matchPattern(member, opts.pattern) {
+				members = append(members, NewBulkString(member))
+			}
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(NewBulkString(strconv.FormatUint(cursor, 10)), NewArray(members...))
+}
+
+func valueStrings(args []Value) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.Str
+	}
+	return strs
+}
@@ -0,0 +1,1104 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errInvalidStreamID  = errors.New("Invalid stream ID specified as stream command argument")
+	errStreamIDZero     = errors.New("The ID specified in XADD must be greater than 0-0")
+	errStreamIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamExhausted  = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+	errMaxLenNegative   = errors.New("The MAXLEN argument must be >= 0.")
+	errTrimLimit        = errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+	errXGroupNoKey      = errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	errBusyGroup        = codedError("BUSYGROUP Consumer Group name already exists")
+)
+
+// streamTrim is a parsed MAXLEN or MINID trimming clause.
+type streamTrim struct {
+	strategy string
+	maxLen   int64
+	minID    cache.StreamID
+	approx   bool
+	limit    int64
+}
+
+func asStream(entry *cache.Entry) (*cache.Stream, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	stream, ok := entry.Object.(*cache.Stream)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return stream, nil
+}
+
+// updateStream runs fn against the stream at key, creating it if create is
+// set. Unlike other types, streams are kept even once empty.
+func (h *CommandHandler) updateStream(key string, create bool, fn func(stream *cache.Stream) error) error {
+	return h.cache.Update(key, func(entry *cache.Entry) (*cache.Entry, error) {
+		stream, err := asStream(entry)
+		if err != nil {
+			return nil, err
+		}
+		if stream == nil {
+			if !create {
+				return nil, nil
+			}
+			stream = cache.NewStream()
+			entry = &cache.Entry{Object: stream}
+		}
+		
+		if err := fn(stream); err != nil {
+			return nil, err
+		}
+		return entry, nil
+	})
+}
+
+func (h *CommandHandler) viewStream(key string, fn func(stream *cache.Stream)) error {
+	return h.cache.View(key, func(entry *cache.Entry) error {
+		stream, err := asStream(entry)
+		if err != nil {
+			return err
+		}
+		fn(stream)
+		return nil
+	})
+}
+
+func noGroupError(key, group string) error {
+	return codedError("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+func (h *CommandHandler) handleXAdd(args []Value) Value {
+	if len(args) < 4 {
+		return wrongArgs("xadd")
+	}
+	
+	key := args[0].Str
+	noMkStream := false
+	var trim streamTrim
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(args[i].Str) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			var err error
+			trim, i, err = parseStreamTrim(args, i)
+			if err != nil {
+				return errorReply(err)
+			}
+		default:
+			break options
+		}
+	}
+	if len(args)-i < 3 || (len(args)-i-1)%2 != 0 {
+		return wrongArgs("xadd")
+	}
+	
+	idArg := args[i].Str
+	fields := valueStrings(args[i+1:])
+	var ms, seq uint64
+	autoMs, autoSeq := idArg == "*", false
+	if !autoMs {
+		msPart, seqPart, _ := strings.Cut(idArg, "-")
+		autoSeq = seqPart == "*"
+		if autoSeq {
+			idArg = msPart
+		}
+		id, ok := cache.ParseStreamID(idArg, 0)
+		if !ok {
+			return errorReply(errInvalidStreamID)
+		}
+		ms, seq = id.Ms, id.Seq
+	}
+	
+	var id cache.StreamID
+	added := false
+	err := h.updateStream(key, !noMkStream, func(stream *cache.Stream) error {
+		last := stream.LastID()
+		if last == cache.MaxStreamID {
+			return errStreamExhausted
+		}
+		switch {
+		case autoMs:
+			id = stream.NextID(uint64(time.Now().UnixMilli()))
+		case autoSeq && ms == last.Ms:
+			if last.Seq == math.MaxUint64 {
+				return errStreamIDTooSmall
+			}
+			id = cache.StreamID{Ms: ms, Seq: last.Seq + 1}
+		case autoSeq && ms == 0:
+			id = cache.StreamID{Seq: 1}
+		case autoSeq:
+			id = cache.StreamID{Ms: ms}
+		default:
+			id = cache.StreamID{Ms: ms, Seq: seq}
+		}
+		
+		if id == (cache.StreamID{}) {
+			return errStreamIDZero
+		}
+		if !stream.Add(id, fields) {
+			return errStreamIDTooSmall
+		}
+		trim.apply(stream)
+		added = true
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !added {
+		return NewNullBulkString()
+	}
+	h.blocked.signal(key)
+	return NewBulkString(id.String())
+}
+
+func (h *CommandHandler) handleXTrim(args []Value) Value {
+	if len(args) < 3 {
+		return wrongArgs("xtrim")
+	}
+	
+	trim, next, err := parseStreamTrim(args, 1)
+	if err != nil {
+		return errorReply(err)
+	}
+	if next != len(args) {
+		return errorReply(errSyntax)
+	}
+	
+	removed := 0
+	err = h.updateStream(args[0].Str, false, func(stream *cache.Stream) error {
+		removed = trim.apply(stream)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(removed))
+}
+
+func (h *CommandHandler) handleXLen(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("xlen")
+	}
+	
+	length := 0
+	err := h.viewStream(args[0].Str, func(stream *cache.Stream) {
+		if stream != nil {
+			length = stream.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+// handleXRange implements XRANGE and XREVRANGE, the latter taking the end
+// of the range first.
+func (h *CommandHandler) handleXRange(name string, args []Value, reverse bool) Value {
+	if len(args) != 3 && len(args) != 5 {
+		return wrongArgs(name)
+	}
+	
+	startArg, endArg := args[1].Str, args[2].Str
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, err1 := parseRangeID(startArg, false)
+	end, err2 := parseRangeID(endArg, true)
+	if err1 != nil || err2 != nil {
+		return errorReply(errInvalidStreamID)
+	}
+	
+	count := int64(-1)
+	if len(args) == 5 {
+		if strings.ToUpper(args[3].Str) != "COUNT" {
+			return errorReply(errSyntax)
+		}
+		n, err := strconv.ParseInt(args[4].Str, 10, 64)
+		if err != nil {
+			return errorReply(errNotInteger)
+		}
+		if n < 0 {
+			n = 0
+		}
+		count = n
+	}
+	
+	entries := []Value{}
+	err := h.viewStream(args[0].Str, func(stream *cache.Stream) {
+		if stream == nil || count == 0 {
+			return
+		}
+		stream.Range(start, end, reverse, func(entry cache.StreamEntry) bool {
+			entries = append(entries, streamEntryValue(entry))
+			return count < 0 || int64(len(entries)) < count
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(entries...)
+}
+
+func (h *CommandHandler) handleXRead(ctx context.Context, args []Value) Value {
+	var count int64
+	block := time.Duration(-1)
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Str)
+		if option == "STREAMS" {
+			i++
+			break
+		}
+		if i+1 >= len(args) {
+			return errorReply(errSyntax)
+		}
+		switch option {
+		case "COUNT":
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return errorReply(errNotInteger)
+			}
+			count = n
+		case "BLOCK":
+			timeout, err := parseBlockTimeout(args[i+1].Str)
+			if err != nil {
+				return errorReply(err)
+			}
+			block = timeout
+		default:
+			return errorReply(errSyntax)
+		}
+		i++
+	}
+	
+	streams := args[i:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		return NewError("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	keys := valueStrings(streams[:len(streams)/2])
+	ids := make([]cache.StreamID, len(keys))
+	for j, arg := range streams[len(streams)/2:] {
+		if arg.Str == "$" {
+			err := h.viewStream(keys[j], func(stream *cache.Stream) {
+				if stream != nil {
+					ids[j] = stream.LastID()
+				}
+			})
+			if err != nil {
+				return errorReply(err)
+			}
+			continue
+		}
+		id, ok := cache.ParseStreamID(arg.Str, 0)
+		if !ok {
+			return errorReply(errInvalidStreamID)
+		}
+		ids[j] = id
+	}
+	
+	read := func(string) (Value, bool) {
+		var results []Value
+		for j, key := range keys {
+			var entries []Value
+			err := h.viewStream(key, func(stream *cache.Stream) {
+				if stream == nil {
+					return
+				}
+				stream.Range(ids[j].Next(), cache.MaxStreamID, false, func(entry cache.StreamEntry) bool {
+					entries = append(entries, streamEntryValue(entry))
+					return count <= 0 || int64(len(entries)) < count
+				})
+			})
+			if err != nil {
+				return errorReply(err), true
+			}
+			if len(entries) > 0 {
+				results = append(results, NewArray(NewBulkString(key), NewArray(entries...)))
+			}
+		}
+		return NewArray(results...), len(results) > 0
+	}
+	
+	var reply Value
+	var ok bool
+	if block < 0 {
+		reply, ok = read("")
+	} else {
+		reply, ok = h.blocked.block(ctx, keys, block, false, read)
+	}
+	if !ok {
+		return NewNullArray()
+	}
+	return reply
+}
+
+func (h *CommandHandler) handleXGroup(args []Value) Value {
+	if len(args) < 1 {
+		return wrongArgs("xgroup")
+	}
+	
+	subcommand := strings.ToUpper(args[0].Str)
+	switch {
+	case subcommand == "CREATE" && len(args) >= 4:
+		return h.xgroupCreate(args[1:])
+	case subcommand == "SETID" && len(args) >= 4:
+		return h.xgroupSetID(args[1:])
+	case subcommand == "DESTROY" && len(args) == 3:
+		destroyed := false
+		err := h.updateGroupStream(args[1].Str, func(stream *cache.Stream) error {
+			destroyed = stream.DestroyGroup(args[2].Str)
+			return nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		if destroyed {
+			return NewInteger(1)
+		}
+		return NewInteger(0)
+	case subcommand == "CREATECONSUMER" && len(args) == 4:
+		created := false
+		err := h.updateGroup(args[1].Str, args[2].Str, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+			created = group.CreateConsumer(args[3].Str, time.Now().UnixMilli())
+			return nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		if created {
+			return NewInteger(1)
+		}
+		return NewInteger(0)
+	case subcommand == "DELCONSUMER" && len(args) == 4:
+		pending := 0
+		err := h.updateGroup(args[1].Str, args[2].Str, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+			pending = group.DeleteConsumer(args[3].Str)
+			return nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		return NewInteger(int64(pending))
+	case subcommand == "CREATE" || subcommand == "SETID" || subcommand == "DESTROY" ||
+		subcommand == "CREATECONSUMER" || subcommand == "DELCONSUMER":
+		return wrongArgs("xgroup|" + strings.ToLower(subcommand))
+	default:
+		return NewError("ERR unknown subcommand '" + args[0].Str + "'. Try XGROUP HELP.")
+	}
+}
+
+func (h *CommandHandler) xgroupCreate(args []Value) Value {
+	key, name := args[0].Str, args[1].Str
+	mkStream := false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg.Str) {
+		case "MKSTREAM":
+			mkStream = true
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+	
+	found := false
+	err := h.updateStream(key, mkStream, func(stream *cache.Stream) error {
+		found = true
+		lastID, err := groupStartID(stream, args[2].Str)
+		if err != nil {
+			return err
+		}
+		if !stream.CreateGroup(name, lastID) {
+			return errBusyGroup
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		return errorReply(errXGroupNoKey)
+	}
+	return NewSimpleString("OK")
+}
+
+func (h *CommandHandler) xgroupSetID(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("xgroup|setid")
+	}
+	
+	err := h.updateGroup(args[0].Str, args[1].Str, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+		lastID, err := groupStartID(stream, args[2].Str)
+		if err != nil {
+			return err
+		}
+		group.LastID = lastID
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewSimpleString("OK")
+}
+
+// updateGroupStream is updateStream for XGROUP subcommands, which fail if
+// the stream does not exist.
+func (h *CommandHandler) updateGroupStream(key string, fn func(stream *cache.Stream) error) error {
+	found := false
+	err := h.updateStream(key, false, func(stream *cache.Stream) error {
+		found = true
+		return fn(stream)
+	})
+	if err == nil && !found {
+		return errXGroupNoKey
+	}
+	return err
+}
+
+// updateGroup runs fn against the consumer group name of the stream at key,
+// failing with NOGROUP if either does not exist.
+func (h *CommandHandler) updateGroup(key, name string, fn func(stream *cache.Stream, group *cache.ConsumerGroup) error) error {
+	found := false
+	err := h.updateStream(key, false, func(stream *cache.Stream) error {
+		group := stream.Group(name)
+		if group == nil {
+			return nil
+		}
+		found = true
+		return fn(stream, group)
+	})
+	if err == nil && !found {
+		return noGroupError(key, name)
+	}
+	return err
+}
+
+func (h *CommandHandler) handleXReadGroup(ctx context.Context, args []Value) Value {
+	if len(args) < 6 || strings.ToUpper(args[0].Str) != "GROUP" {
+		return errorReply(errSyntax)
+	}
+	
+	groupName, consumer := args[1].Str, args[2].Str
+	var count int64
+	block := time.Duration(-1)
+	noAck := false
+	i := 3
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Str)
+		if option == "STREAMS" {
+			i++
+			break
+		}
+		if option == "NOACK" {
+			noAck = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return errorReply(errSyntax)
+		}
+		switch option {
+		case "COUNT":
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return errorReply(errNotInteger)
+			}
+			count = n
+		case "BLOCK":
+			timeout, err := parseBlockTimeout(args[i+1].Str)
+			if err != nil {
+				return errorReply(err)
+			}
+			block = timeout
+		default:
+			return errorReply(errSyntax)
+		}
+		i++
+	}
+	
+	streams := args[i:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		return NewError("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+	}
+	keys := valueStrings(streams[:len(streams)/2])
+	ids := make([]cache.StreamID, len(keys))
+	history := make([]bool, len(keys))
+	for j, arg := range streams[len(streams)/2:] {
+		if arg.Str == ">" {
+			continue
+		}
+		id, ok := cache.ParseStreamID(arg.Str, 0)
+		if !ok {
+			return errorReply(errInvalidStreamID)
+		}
+		ids[j], history[j] = id, true
+	}
+	
+	read := func(string) (Value, bool) {
+		now := time.Now().UnixMilli()
+		var results []Value
+		for j, key := range keys {
+			entries := []Value{}
+			full := func() bool {
+				return count > 0 && int64(len(entries)) >= count
+			}
+			err := h.updateGroup(key, groupName, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+				group.Consumer(consumer, now)
+				if history[j] {
+					group.RangePending(ids[j].Next(), cache.MaxStreamID, consumer, func(pending *cache.PendingEntry) bool {
+						if full() {
+							return false
+						}
+						if entry, ok := stream.Get(pending.ID); ok {
+							entries = append(entries, streamEntryValue(entry))
+						} else {
+							entries = append(entries, NewArray(NewBulkString(pending.ID.String()), NewNullArray()))
+						}
+						return true
+					})
+					return nil
+				}
+				
+				stream.Range(group.LastID.Next(), cache.MaxStreamID, false, func(entry cache.StreamEntry) bool {
+					if full() {
+						return false
+					}
+					entries = append(entries, streamEntryValue(entry))
+					group.LastID = entry.ID
+					if !noAck {
+						group.Deliver(entry.ID, consumer, now)
+					}
+					return true
+				})
+				return nil
+			})
+			if err != nil {
+				return errorReply(err), true
+			}
+			if history[j] || len(entries) > 0 {
+				results = append(results, NewArray(NewBulkString(key), NewArray(entries...)))
+			}
+		}
+		return NewArray(results...), len(results) > 0
+	}
+	
+	var reply Value
+	var ok bool
+	if block < 0 {
+		reply, ok = read("")
+	} else {
+		reply, ok = h.blocked.block(ctx, keys, block, false, read)
+	}
+	if !ok {
+		return NewNullArray()
+	}
+	return reply
+}
+
+func (h *CommandHandler) handleXAck(args []Value) Value {
+	if len(args) < 3 {
+		return wrongArgs("xack")
+	}
+	
+	ids, err := parseStreamIDs(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	acked := 0
+	err = h.updateGroup(args[0].Str, args[1].Str, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+		for _, id := range ids {
+			if group.Ack(id) {
+				acked++
+			}
+		}
+		return nil
+	})
+	if err != nil && !isNoGroup(err) {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(acked))
+}
+
+// handleXPending replies with a summary of the group's pending entries, or
+// with the entries themselves when given a range.
+func (h *CommandHandler) handleXPending(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("xpending")
+	}
+	
+	if len(args) == 2 {
+		var summary Value
+		err := h.updateGroup(args[0].Str, args[1].Str, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+			summary = pendingSummary(group)
+			return nil
+		})
+		if err != nil {
+			return errorReply(err)
+		}
+		return summary
+	}
+	
+	rest := args[2:]
+	var minIdle int64
+	if strings.ToUpper(rest[0].Str) == "IDLE" {
+		if len(rest) < 2 {
+			return errorReply(errSyntax)
+		}
+		n, err := strconv.ParseInt(rest[1].Str, 10, 64)
+		if err != nil {
+			return errorReply(errNotInteger)
+		}
+		minIdle = n
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return errorReply(errSyntax)
+	}
+	
+	start, err1 := parseRangeID(rest[0].Str, false)
+	end, err2 := parseRangeID(rest[1].Str, true)
+	if err1 != nil || err2 != nil {
+		return errorReply(errInvalidStreamID)
+	}
+	count, err := strconv.ParseInt(rest[2].Str, 10, 64)
+	if err != nil {
+		return errorReply(errNotInteger)
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3].Str
+	}
+	
+	entries := []Value{}
+	err = h.updateGroup(args[0].Str, args[1].Str, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+		now := time.Now().UnixMilli()
+		group.RangePending(start, end, consumer, func(pending *cache.PendingEntry) bool {
+			if int64(len(entries)) >= count {
+				return false
+			}
+			idle := now - pending.DeliveredAt
+			if idle < minIdle {
+				return true
+			}
+			entries = append(entries, NewArray(
+				NewBulkString(pending.ID.String()),
+				NewBulkString(pending.Consumer),
+				NewInteger(idle),
+				NewInteger(pending.Deliveries),
+			))
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(entries...)
+}
+
+func pendingSummary(group *cache.ConsumerGroup) Value {
+	if group.PendingLen() == 0 {
+		return NewArray(NewInteger(0), NewNullBulkString(), NewNullBulkString(), NewNullArray())
+	}
+	
+	var first, last cache.StreamID
+	counts := make(map[string]int64)
+	group.RangePending(cache.StreamID{}, cache.MaxStreamID, "", func(pending *cache.PendingEntry) bool {
+		if len(counts) == 0 {
+			first = pending.ID
+		}
+		last = pending.ID
+		counts[pending.Consumer]++
+		return true
+	})
+	
+	var consumers []Value
+	for _, consumer := range group.Consumers() {
+		if n := counts[consumer.Name]; n > 0 {
+			consumers = append(consumers, NewArray(NewBulkString(consumer.Name), NewBulkString(strconv.FormatInt(n, 10))))
+		}
+	}
+	return NewArray(
+		NewInteger(int64(group.PendingLen())),
+		NewBulkString(first.String()),
+		NewBulkString(last.String()),
+		NewArray(consumers...),
+	)
+}
+
+// handleXClaim transfers pending entries idle for at least min-idle-time to
+// another consumer.
+func (h *CommandHandler) handleXClaim(args []Value) Value {
+	if len(args) < 5 {
+		return wrongArgs("xclaim")
+	}
+	
+	key, groupName, consumer := args[0].Str, args[1].Str, args[2].Str
+	minIdle, err := strconv.ParseInt(args[3].Str, 10, 64)
+	if err != nil {
+		return NewError("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	
+	var ids []cache.StreamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, ok := cache.ParseStreamID(args[i].Str, 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errorReply(errInvalidStreamID)
+	}
+	
+	now := time.Now().UnixMilli()
+	deliveredAt := now
+	retryCount := int64(-1)
+	var force, justID bool
+	var lastID cache.StreamID
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Str)
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case (option == "IDLE" || option == "TIME" || option == "RETRYCOUNT") && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return errorReply(errNotInteger)
+			}
+			switch option {
+			case "IDLE":
+				deliveredAt = now - n
+			case "TIME":
+				deliveredAt = n
+			default:
+				retryCount = n
+			}
+			i++
+		case option == "LASTID" && i+1 < len(args):
+			id, ok := cache.ParseStreamID(args[i+1].Str, 0)
+			if !ok {
+				return errorReply(errInvalidStreamID)
+			}
+			lastID = id
+			i++
+		default:
+			return NewError("ERR Unrecognized XCLAIM option '" + args[i].Str + "'")
+		}
+	}
+	
+	claimed := []Value{}
+	err = h.updateGroup(key, groupName, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+		if group.LastID.Less(lastID) {
+			group.LastID = lastID
+		}
+		owner := group.Consumer(consumer, now)
+		
+		for _, id := range ids {
+			entry, exists := stream.Get(id)
+			pending := group.Pending(id)
+			if pending == nil {
+				if !force || !exists {
+					continue
+				}
+				// Like Redis, a forced claim counts as one delivery before
+				// the claim itself is counted.
+				pending = group.Deliver(id, consumer, now)
+			}
+			if !exists {
+				group.Ack(id)
+				continue
+			}
+			if minIdle > 0 && now-pending.DeliveredAt < minIdle {
+				continue
+			}
+			
+			pending.Consumer = owner.Name
+			pending.DeliveredAt = deliveredAt
+			if retryCount >= 0 {
+				pending.Deliveries = retryCount
+			} else if !justID {
+				pending.Deliveries++
+			}
+			
+			if justID {
+				claimed = append(claimed, NewBulkString(id.String()))
+			} else {
+				claimed = append(claimed, streamEntryValue(entry))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(claimed...)
+}
+
+// handleXAutoClaim is XCLAIM for whichever pending entries are idle enough,
+// scanning the pending entries list from start and examining at most ten
+// times COUNT of them. It replies with the ID to resume the scan from, 0-0
+// once it reached the end, the entries claimed and the IDs of pending entries
+// no longer in the stream, which it acknowledges.
+func (h *CommandHandler) handleXAutoClaim(args []Value) Value {
+	if len(args) < 5 {
+		return wrongArgs("xautoclaim")
+	}
+	
+	key, groupName, consumer := args[0].Str, args[1].Str, args[2].Str
+	minIdle, err := strconv.ParseInt(args[3].Str, 10, 64)
+	if err != nil {
+		return NewError("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, err := parseRangeID(args[4].Str, false)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	count := int64(100)
+	justID := false
+	for i := 5; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Str)
+		switch {
+		case option == "JUSTID":
+			justID = true
+		case option == "COUNT" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return errorReply(errNotInteger)
+			}
+			if n < 1 || n > math.MaxInt64/10 {
+				return NewError("ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+	
+	var next cache.StreamID
+	claimed, deleted := []Value{}, []Value{}
+	err = h.updateGroup(key, groupName, func(stream *cache.Stream, group *cache.ConsumerGroup) error {
+		now := time.Now().UnixMilli()
+		owner := group.Consumer(consumer, now)
+		attempts := count * 10
+		group.RangePending(start, cache.MaxStreamID, "", func(pending *cache.PendingEntry) bool {
+			if count == 0 || attempts == 0 {
+				next = pending.ID
+				return false
+			}
+			attempts--
+			
+			entry, exists := stream.Get(pending.ID)
+			if !exists {
+				deleted = append(deleted, NewBulkString(pending.ID.String()))
+				group.Ack(pending.ID)
+				count--
+				return true
+			}
+			if now-pending.DeliveredAt < minIdle {
+				return true
+			}
+			
+			pending.Consumer = owner.Name
+			pending.DeliveredAt = now
+			if justID {
+				claimed = append(claimed, NewBulkString(pending.ID.String()))
+			} else {
+				pending.Deliveries++
+				claimed = append(claimed, streamEntryValue(entry))
+			}
+			count--
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(NewBulkString(next.String()), NewArray(claimed...), NewArray(deleted...))
+}
+
+func streamEntryValue(entry cache.StreamEntry) Value {
+	fields := make([]Value, len(entry.Fields))
+	for i, field := range entry.Fields {
+		fields[i] = NewBulkString(field)
+	}
+	return NewArray(NewBulkString(entry.ID.String()), NewArray(fields...))
+}
+
+// parseStreamTrim parses a MAXLEN or MINID clause starting at args[i] and
+// returns the index just past it.
+func parseStreamTrim(args []Value, i int) (streamTrim, int, error) {
+	trim := streamTrim{strategy: strings.ToUpper(args[i].Str)}
+	i++
+	if i < len(args) && (args[i].Str == "=" || args[i].Str == "~") {
+		trim.approx = args[i].Str == "~"
+		i++
+	}
+	if i >= len(args) {
+		return trim, i, errSyntax
+	}
+	
+	if trim.strategy == "MAXLEN" {
+		n, err := strconv.ParseInt(args[i].Str, 10, 64)
+		if err != nil {
+			return trim, i, errNotInteger
+		}
+		if n < 0 {
+			return trim, i, errMaxLenNegative
+		}
+		trim.maxLen = n
+	} else {
+		id, ok := cache.ParseStreamID(args[i].Str, 0)
+		if !ok {
+			return trim, i, errInvalidStreamID
+		}
+		trim.minID = id
+	}
+	i++
+	
+	if i+1 < len(args) && strings.ToUpper(args[i].Str) == "LIMIT" {
+		if !trim.approx {
+			return trim, i, errTrimLimit
+		}
+		n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+		if err != nil || n < 0 {
+			return trim, i, errNotInteger
+		}
+		trim.limit = n
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// apply trims stream and returns the number of entries removed. Approximate
+// trimming is done exactly, except that it honors LIMIT.
+func (t streamTrim) apply(stream *cache.Stream) int {
+	limit := 0
+	if t.approx {
+		limit = int(t.limit)
+	}
+	
+	switch t.strategy {
+	case "MAXLEN":
+		return stream.TrimMaxLen(int(t.maxLen), limit)
+	case "MINID":
+		return stream.TrimMinID(t.minID, limit)
+	default:
+		return 0
+	}
+}
+
+// parseRangeID parses an XRANGE bound: "-" or "+", an ID, or an ID prefixed
+// with '(' to exclude it. An end bound without a sequence number covers the
+// whole millisecond.
+func parseRangeID(s string, end bool) (cache.StreamID, error) {
+	switch s {
+	case "-":
+		return cache.StreamID{}, nil
+	case "+":
+		return cache.MaxStreamID, nil
+	}
+	
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	defaultSeq := uint64(0)
+	if end {
+		defaultSeq = math.MaxUint64
+	}
+	id, ok := cache.ParseStreamID(s, defaultSeq)
+	if !ok {
+		return id, errInvalidStreamID
+	}
+	
+	switch {
+	case exclusive && end:
+		if id == (cache.StreamID{}) {
+			return id, errInvalidStreamID
+		}
+		return id.Prev(), nil
+	case exclusive:
+		if id == cache.MaxStreamID {
+			return id, errInvalidStreamID
+		}
+		return id.Next(), nil
+	default:
+		return id, nil
+	}
+}
+
+func parseStreamIDs(args []Value) ([]cache.StreamID, error) {
+	ids := make([]cache.StreamID, len(args))
+	for i, arg := range args {
+		id, ok := cache.ParseStreamID(arg.Str, 0)
+		if !ok {
+			return nil, errInvalidStreamID
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// groupStartID resolves the ID a group starts delivering after, where "$"
+// means the stream's last entry.
+func groupStartID(stream *cache.Stream, s string) (cache.StreamID, error) {
+	if s == "$" {
+		return stream.LastID(), nil
+	}
+	id, ok := cache.ParseStreamID(s, 0)
+	if !ok {
+		return id, errInvalidStreamID
+	}
+	return id, nil
+}
+
+// parseBlockTimeout parses a BLOCK argument in milliseconds.
+func parseBlockTimeout(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errTimeoutNotFloat
+	}
+	if ms < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func isNoGroup(err error) bool {
+	var coded codedError
+	return errors.As(err, &coded) && strings.HasPrefix(string(coded), "NOGROUP")
+}
@@ -0,0 +1,360 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func TestXAdd(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-1", "a", "1"}, "1-1"},
+		{[]string{"XADD", "s", "1-1", "a", "2"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+		{[]string{"XADD", "s", "0-0", "a", "2"}, "-ERR The ID specified in XADD must be greater than 0-0"},
+		{[]string{"XADD", "s", "1-*", "a", "2"}, "1-2"},
+		{[]string{"XADD", "s", "5-*", "a", "3"}, "5-0"},
+		{[]string{"XADD", "s", "5", "a", "4"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+		{[]string{"XADD", "s", "6", "a", "4"}, "6-0"},
+		{[]string{"XADD", "s", "abc", "a", "1"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XADD", "s", "7-1", "a"}, "-ERR wrong number of arguments for 'xadd' command"},
+		{[]string{"XADD", "s", "7-1", "a", "1", "b"}, "-ERR wrong number of arguments for 'xadd' command"},
+		{[]string{"XADD", "s", "MAXLEN", "3", "7-1", "a", "5"}, "7-1"},
+		{[]string{"XRANGE", "s", "-", "+"}, "[[5-0 [a 3]] [6-0 [a 4]] [7-1 [a 5]]]"},
+		{[]string{"XADD", "s", "MAXLEN", "~", "1", "LIMIT", "1", "8-1", "a", "6"}, "8-1"},
+		{[]string{"XLEN", "s"}, ":3"},
+		{[]string{"XADD", "s", "MAXLEN", "2", "LIMIT", "1", "9-1", "a", "7"}, "-ERR syntax error, LIMIT cannot be used without the special ~ option"},
+		{[]string{"XADD", "s", "MAXLEN", "-1", "9-1", "a", "7"}, "-ERR The MAXLEN argument must be >= 0."},
+		{[]string{"XADD", "s", "MAXLEN", "many", "9-1", "a", "7"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XADD", "s", "MINID", "=", "8", "9-1", "a", "7"}, "9-1"},
+		{[]string{"XRANGE", "s", "-", "+"}, "[[8-1 [a 6]] [9-1 [a 7]]]"},
+		{[]string{"XADD", "s", "MINID", "bad", "9-2", "a", "1"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XTRIM", "s", "MAXLEN", "1"}, ":1"},
+		{[]string{"XTRIM", "s", "MAXLEN", "1", "extra"}, "-ERR syntax error"},
+		{[]string{"XTRIM", "missing", "MAXLEN", "0"}, ":0"},
+		{[]string{"XADD", "missing", "NOMKSTREAM", "*", "a", "1"}, "(nil)"},
+		{[]string{"EXISTS", "missing"}, ":0"},
+		{[]string{"XADD", "s", "NOMKSTREAM", "10-1", "a", "1"}, "10-1"},
+		{[]string{"SET", "str", "x"}, "+OK"},
+		{[]string{"XADD", "str", "*", "a", "1"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+		
+		// Once the last ID is the largest possible one, nothing more fits.
+		{[]string{"XADD", "max", "18446744073709551615-18446744073709551614", "a", "1"}, "18446744073709551615-18446744073709551614"},
+		{[]string{"XADD", "max", "18446744073709551615-*", "a", "1"}, "18446744073709551615-18446744073709551615"},
+		{[]string{"XADD", "max", "*", "a", "1"}, "-ERR The stream has exhausted the last possible ID, unable to add more items"},
+		{[]string{"XADD", "max", "18446744073709551615-*", "a", "1"}, "-ERR The stream has exhausted the last possible ID, unable to add more items"},
+		{[]string{"XADD", "max", "1-1", "a", "1"}, "-ERR The stream has exhausted the last possible ID, unable to add more items"},
+		{[]string{"XADD", "seq", "5-18446744073709551615", "a", "1"}, "5-18446744073709551615"},
+		{[]string{"XADD", "seq", "5-*", "a", "1"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+	})
+}
+
+func TestXAddGeneratesIncreasingIDs(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	session := NewSession()
+	
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	if reply := execute(handler, session, "XADD", "s", future+"-5", "a", "1"); reply.Str != future+"-5" {
+		t.Fatalf("Expected %s-5, got %+v", future, reply)
+	}
+	// A clock behind the last ID only bumps its sequence number.
+	if reply := execute(handler, session, "XADD", "s", "*", "a", "2"); reply.Str != future+"-6" {
+		t.Errorf("Expected %s-6, got %+v", future, reply)
+	}
+}
+
+func TestXRange(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-0", "f", "a"}, "1-0"},
+		{[]string{"XADD", "s", "1-1", "f", "b"}, "1-1"},
+		{[]string{"XADD", "s", "2-0", "f", "c"}, "2-0"},
+		{[]string{"XADD", "s", "3-5", "f", "d"}, "3-5"},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "2"}, "[[1-0 [f a]] [1-1 [f b]]]"},
+		{[]string{"XRANGE", "s", "(1-0", "+", "COUNT", "1"}, "[[1-1 [f b]]]"},
+		{[]string{"XRANGE", "s", "1", "1"}, "[[1-0 [f a]] [1-1 [f b]]]"},
+		{[]string{"XRANGE", "s", "2", "(3-5"}, "[[2-0 [f c]]]"},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "0"}, "[]"},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "-3"}, "[]"},
+		{[]string{"XREVRANGE", "s", "+", "-", "COUNT", "1"}, "[[3-5 [f d]]]"},
+		{[]string{"XREVRANGE", "s", "(3-5", "(1-0"}, "[[2-0 [f c]] [1-1 [f b]]]"},
+		{[]string{"XRANGE", "missing", "-", "+"}, "[]"},
+		{[]string{"XRANGE", "s", "x", "+"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XRANGE", "s", "-", "(0-0"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XRANGE", "s", "-", "+", "LIMIT", "1"}, "-ERR syntax error"},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XRANGE", "s", "-"}, "-ERR wrong number of arguments for 'xrange' command"},
+	})
+}
+
+func TestXRead(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-0", "f", "a"}, "1-0"},
+		{[]string{"XADD", "s", "2-0", "f", "b"}, "2-0"},
+		{[]string{"XREAD", "STREAMS", "s", "1-0"}, "[[s [[2-0 [f b]]]]]"},
+		{[]string{"XREAD", "COUNT", "1", "STREAMS", "s", "missing", "0", "0"}, "[[s [[1-0 [f a]]]]]"},
+		{[]string{"XREAD", "STREAMS", "s", "$"}, "(nil)"},
+		{[]string{"XREAD", "BLOCK", "10", "STREAMS", "s", "$"}, "(nil)"},
+		{[]string{"XREAD", "STREAMS", "s"}, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."},
+		{[]string{"XREAD", "STREAMS", "s", "bad"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XREAD", "COUNT", "x", "STREAMS", "s", "0"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XREAD", "BLOCK", "-1", "STREAMS", "s", "0"}, "-ERR timeout is negative"},
+		{[]string{"XREAD", "BLOCK", "soon", "STREAMS", "s", "0"}, "-ERR timeout is not a float or out of range"},
+		{[]string{"XREAD", "FOO", "1", "STREAMS", "s", "0"}, "-ERR syntax error"},
+		{[]string{"XREAD", "COUNT"}, "-ERR syntax error"},
+	})
+	
+	// $ means entries added after the call, so a blocked reader sees only
+	// the next one.
+	reply := make(chan Value, 1)
+	go func() {
+		reply <- executeContext(context.Background(), handler, "XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	}()
+	waitBlocked(t, handler, "s", 1)
+	execute(handler, NewSession(), "XADD", "s", "3-0", "f", "c")
+	if got := show(<-reply); got != "[[s [[3-0 [f c]]]]]" {
+		t.Errorf("Expected the blocked XREAD to get 3-0, got %s", got)
+	}
+}
+
+func TestXGroup(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	noKey := "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+	runSteps(t, handler, []commandStep{
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, noKey},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"}, "+OK"},
+		{[]string{"TYPE", "s"}, "+stream"},
+		{[]string{"XLEN", "s"}, ":0"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, "-BUSYGROUP Consumer Group name already exists"},
+		{[]string{"XGROUP", "CREATE", "s", "g2", "0", "BOGUS"}, "-ERR syntax error"},
+		{[]string{"XGROUP", "CREATE", "s", "g2", "bad"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XGROUP", "CREATE", "s", "g2"}, "-ERR wrong number of arguments for 'xgroup|create' command"},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "g", "alice"}, ":1"},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "g", "alice"}, ":0"},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "nogroup", "alice"}, "-NOGROUP No such key 's' or consumer group 'nogroup'"},
+		{[]string{"XGROUP", "SETID", "s", "g", "0"}, "+OK"},
+		{[]string{"XGROUP", "SETID", "s", "g"}, "-ERR wrong number of arguments for 'xgroup|setid' command"},
+		{[]string{"XGROUP", "SETID", "s", "g", "0", "extra"}, "-ERR wrong number of arguments for 'xgroup|setid' command"},
+		{[]string{"XGROUP", "SETID", "missing", "g", "0"}, "-NOGROUP No such key 'missing' or consumer group 'g'"},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "alice"}, ":0"},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "nobody"}, ":0"},
+		{[]string{"XGROUP", "DESTROY", "s", "g"}, ":1"},
+		{[]string{"XGROUP", "DESTROY", "s", "g"}, ":0"},
+		{[]string{"XGROUP", "DESTROY", "missing", "g"}, noKey},
+		{[]string{"XGROUP", "FOO"}, "-ERR unknown subcommand 'FOO'. Try XGROUP HELP."},
+	})
+	
+	// SETID moves where the group resumes delivering.
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-0", "f", "a"}, "1-0"},
+		{[]string{"XADD", "s", "2-0", "f", "b"}, "2-0"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, "+OK"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, "(nil)"},
+		{[]string{"XGROUP", "SETID", "s", "g", "1-0"}, "+OK"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, "[[s [[2-0 [f b]]]]]"},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "alice"}, ":1"},
+		{[]string{"XPENDING", "s", "g"}, "[:0 (nil) (nil) (nil)]"},
+	})
+}
+
+func TestXReadGroup(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-0", "f", "a"}, "1-0"},
+		{[]string{"XADD", "s", "2-0", "f", "b"}, "2-0"},
+		{[]string{"XADD", "s", "3-0", "f", "c"}, "3-0"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "0"}, "+OK"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"}, "[[s [[1-0 [f a]] [2-0 [f b]]]]]"},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"}, "[[s [[3-0 [f c]]]]]"},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"}, "(nil)"},
+		
+		// An ID reads back the consumer's own pending entries after it.
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"}, "[[s [[1-0 [f a]] [2-0 [f b]]]]]"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "1-0"}, "[[s [[2-0 [f b]]]]]"},
+		{[]string{"XREADGROUP", "GROUP", "g", "carol", "STREAMS", "s", "0"}, "[[s []]]"},
+		{[]string{"XACK", "s", "g", "1-0", "9-9"}, ":1"},
+		{[]string{"XACK", "s", "nogroup", "2-0"}, ":0"},
+		{[]string{"XACK", "s", "g", "bad"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"}, "[[s [[2-0 [f b]]]]]"},
+		{[]string{"XPENDING", "s", "g"}, "[:2 2-0 3-0 [[alice 1] [bob 1]]]"},
+		
+		// NOACK delivers without adding to the pending entries list.
+		{[]string{"XADD", "s", "4-0", "f", "d"}, "4-0"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "NOACK", "STREAMS", "s", ">"}, "[[s [[4-0 [f d]]]]]"},
+		{[]string{"XPENDING", "s", "g"}, "[:2 2-0 3-0 [[alice 1] [bob 1]]]"},
+		
+		// Pending entries deleted from the stream read back as nil.
+		{[]string{"XTRIM", "s", "MAXLEN", "0"}, ":4"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"}, "[[s [[2-0 (nil)]]]]"},
+		
+		{[]string{"XREADGROUP", "GROUP", "nogroup", "alice", "STREAMS", "s", ">"}, "-NOGROUP No such key 's' or consumer group 'nogroup'"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "missing", ">"}, "-NOGROUP No such key 'missing' or consumer group 'g'"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "t", ">"}, "-ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified."},
+		{[]string{"XREADGROUP", "GRP", "g", "alice", "STREAMS", "s", ">"}, "-ERR syntax error"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "x", "STREAMS", "s", ">"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "bad"}, "-ERR Invalid stream ID specified as stream command argument"},
+	})
+	
+	reply := make(chan Value, 1)
+	go func() {
+		reply <- executeContext(context.Background(), handler, "XREADGROUP", "GROUP", "g", "bob", "BLOCK", "0", "STREAMS", "s", ">")
+	}()
+	waitBlocked(t, handler, "s", 1)
+	execute(handler, NewSession(), "XADD", "s", "5-0", "f", "e")
+	if got := show(<-reply); got != "[[s [[5-0 [f e]]]]]" {
+		t.Errorf("Expected the blocked XREADGROUP to get 5-0, got %s", got)
+	}
+}
+
+// pendingEntries returns XPENDING's extended form with idle times checked
+// against minIdle and then left out, since they depend on the clock.
+func pendingEntries(t *testing.T, handler *CommandHandler, minIdle int64, args ...string) string {
+	t.Helper()
+	reply := execute(handler, NewSession(), append([]string{"XPENDING"}, args...)...)
+	if reply.Type != Array {
+		return show(reply)
+	}
+	for i, entry := range reply.Array {
+		if len(entry.Array) != 4 || entry.Array[2].Int < minIdle {
+			t.Errorf("XPENDING %v: entry %s is not idle for %dms", args, show(entry), minIdle)
+			continue
+		}
+		reply.Array[i].Array = append(entry.Array[:2:2], entry.Array[3])
+	}
+	return show(reply)
+}
+
+func TestXPendingAndXClaim(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-0", "f", "a"}, "1-0"},
+		{[]string{"XADD", "s", "2-0", "f", "b"}, "2-0"},
+		{[]string{"XADD", "s", "3-0", "f", "c"}, "3-0"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "0"}, "+OK"},
+		{[]string{"XPENDING", "s", "g"}, "[:0 (nil) (nil) (nil)]"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, "[[s [[1-0 [f a]] [2-0 [f b]] [3-0 [f c]]]]]"},
+		
+		// IDLE backdates the delivery; JUSTID leaves the count alone.
+		{[]string{"XCLAIM", "s", "g", "alice", "0", "1-0", "IDLE", "100000", "JUSTID"}, "[1-0]"},
+		{[]string{"XCLAIM", "s", "g", "bob", "50000", "1-0", "2-0"}, "[[1-0 [f a]]]"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "2-0", "JUSTID", "RETRYCOUNT", "7"}, "[2-0]"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "9-0", "FORCE"}, "[]"},
+		{[]string{"XADD", "s", "4-0", "f", "d"}, "4-0"},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "4-0"}, "[]"},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "4-0", "FORCE", "JUSTID"}, "[4-0]"},
+		{[]string{"XPENDING", "s", "g"}, "[:4 1-0 4-0 [[alice 1] [bob 2] [carol 1]]]"},
+		
+		// LASTID moves the group past entries nobody read yet.
+		{[]string{"XADD", "s", "5-0", "f", "e"}, "5-0"},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "4-0", "LASTID", "5-0", "JUSTID"}, "[4-0]"},
+		{[]string{"XREADGROUP", "GROUP", "g", "dave", "STREAMS", "s", ">"}, "(nil)"},
+		
+		// Claiming an entry deleted from the stream acknowledges it.
+		{[]string{"XADD", "s", "MINID", "2", "6-0", "f", "f"}, "6-0"},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "1-0"}, "[]"},
+		{[]string{"XPENDING", "s", "g"}, "[:3 2-0 4-0 [[alice 1] [bob 1] [carol 1]]]"},
+		
+		{[]string{"XCLAIM", "s", "g", "bob", "soon", "1-0"}, "-ERR Invalid min-idle-time argument for XCLAIM"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "bad"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "BOGUS"}, "-ERR Unrecognized XCLAIM option 'BOGUS'"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "IDLE", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "LASTID", "x"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XCLAIM", "s", "nogroup", "bob", "0", "1-0"}, "-NOGROUP No such key 's' or consumer group 'nogroup'"},
+		{[]string{"XCLAIM", "s", "g", "bob", "0"}, "-ERR wrong number of arguments for 'xclaim' command"},
+		
+		{[]string{"XPENDING", "s", "g", "-", "+"}, "-ERR syntax error"},
+		{[]string{"XPENDING", "s", "g", "IDLE", "x", "-", "+", "10"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XPENDING", "s", "g", "-", "+", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XPENDING", "s", "g", "bad", "+", "10"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XPENDING", "s", "nogroup"}, "-NOGROUP No such key 's' or consumer group 'nogroup'"},
+		{[]string{"XPENDING", "s"}, "-ERR wrong number of arguments for 'xpending' command"},
+	})
+	
+	// The extended form lists ID, owner, idle time and delivery count, the
+	// idle times being checked rather than compared.
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"s", "g", "-", "+", "10"}, "[[2-0 bob :7] [3-0 alice :1] [4-0 carol :1]]"},
+		{[]string{"s", "g", "-", "+", "1"}, "[[2-0 bob :7]]"},
+		{[]string{"s", "g", "(2-0", "+", "10"}, "[[3-0 alice :1] [4-0 carol :1]]"},
+		{[]string{"s", "g", "-", "+", "10", "carol"}, "[[4-0 carol :1]]"},
+		{[]string{"s", "g", "-", "+", "10", "nobody"}, "[]"},
+		{[]string{"s", "g", "-", "+", "0"}, "[]"},
+	}
+	for _, tt := range tests {
+		if got := pendingEntries(t, handler, 0, tt.args...); got != tt.want {
+			t.Errorf("XPENDING %v: got %s, want %s", tt.args, got, tt.want)
+		}
+	}
+	
+	// TIME sets the delivery time outright, which IDLE filters on.
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+	execute(handler, NewSession(), "XCLAIM", "s", "g", "bob", "0", "2-0", "TIME", past, "JUSTID")
+	if got := pendingEntries(t, handler, 60000, "s", "g", "IDLE", "60000", "-", "+", "10"); got != "[[2-0 bob :7]]" {
+		t.Errorf("Expected only the entry delivered a minute ago, got %s", got)
+	}
+	
+	// Without JUSTID a forced claim counts a delivery on top of the one it
+	// creates, as in Redis.
+	execute(handler, NewSession(), "XADD", "s", "7-0", "f", "g")
+	if got := show(execute(handler, NewSession(), "XCLAIM", "s", "g", "erin", "0", "7-0", "FORCE")); got != "[[7-0 [f g]]]" {
+		t.Errorf("Expected the forced claim to return 7-0, got %s", got)
+	}
+	if got := pendingEntries(t, handler, 0, "s", "g", "-", "+", "10", "erin"); got != "[[7-0 erin :2]]" {
+		t.Errorf("Expected a forced claim to count two deliveries, got %s", got)
+	}
+}
+
+func TestXAutoClaim(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"XADD", "s", "1-0", "f", "a"}, "1-0"},
+		{[]string{"XADD", "s", "2-0", "f", "b"}, "2-0"},
+		{[]string{"XADD", "s", "3-0", "f", "c"}, "3-0"},
+		{[]string{"XADD", "s", "4-0", "f", "d"}, "4-0"},
+		{[]string{"XADD", "s", "5-0", "f", "e"}, "5-0"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "0"}, "+OK"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "0", "STREAMS", "s", ">"}, "[[s [[1-0 [f a]] [2-0 [f b]] [3-0 [f c]] [4-0 [f d]] [5-0 [f e]]]]]"},
+		{[]string{"XCLAIM", "s", "g", "alice", "0", "2-0", "4-0", "IDLE", "100000", "JUSTID"}, "[2-0 4-0]"},
+		{[]string{"XTRIM", "s", "MINID", "2"}, ":1"},
+		
+		// A deleted entry is reported and counts towards COUNT.
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "50000", "0", "COUNT", "2"}, "[3-0 [[2-0 [f b]]] [1-0]]"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "50000", "3-0", "COUNT", "1"}, "[5-0 [[4-0 [f d]]] []]"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "50000", "5-0"}, "[0-0 [] []]"},
+		{[]string{"XAUTOCLAIM", "s", "g", "carol", "0", "(2-0", "JUSTID"}, "[0-0 [3-0 4-0 5-0] []]"},
+		{[]string{"XAUTOCLAIM", "s", "g", "carol", "0", "-", "JUSTID"}, "[0-0 [2-0 3-0 4-0 5-0] []]"},
+		{[]string{"XPENDING", "s", "g"}, "[:4 2-0 5-0 [[carol 4]]]"},
+		
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "soon", "0"}, "-ERR Invalid min-idle-time argument for XAUTOCLAIM"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "bad"}, "-ERR Invalid stream ID specified as stream command argument"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT", "0"}, "-ERR COUNT must be > 0"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT"}, "-ERR syntax error"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "0", "BOGUS"}, "-ERR syntax error"},
+		{[]string{"XAUTOCLAIM", "s", "nogroup", "bob", "0", "0"}, "-NOGROUP No such key 's' or consumer group 'nogroup'"},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0"}, "-ERR wrong number of arguments for 'xautoclaim' command"},
+	})
+	
+	// Claiming with JUSTID leaves the delivery counts alone.
+	if got := pendingEntries(t, handler, 0, "s", "g", "-", "+", "10"); got != "[[2-0 carol :2] [3-0 carol :1] [4-0 carol :2] [5-0 carol :1]]" {
+		t.Errorf("Unexpected pending entries %s", got)
+	}
+	
+	// A scan examines at most ten times COUNT entries per call.
+	session := NewSession()
+	for i := 1; i <= 15; i++ {
+		execute(handler, session, "XADD", "t", strconv.Itoa(i)+"-0", "f", "v")
+	}
+	execute(handler, session, "XGROUP", "CREATE", "t", "g", "0")
+	execute(handler, session, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "t", ">")
+	if got := show(execute(handler, session, "XAUTOCLAIM", "t", "g", "bob", "60000", "-", "COUNT", "1")); got != "[11-0 [] []]" {
+		t.Errorf("Expected the scan to stop after 10 entries, got %s", got)
+	}
+}
@@ -0,0 +1,459 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errNotInteger = errors.New("value is not an integer or out of range")
+	errNotFloat   = errors.New("value is not a valid float")
+	errOverflow   = errors.New("increment or decrement would overflow")
+	errNaN        = errors.New("increment would produce NaN or Infinity")
+	
+	errStringTooLong = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+func checkString(entry *cache.Entry) error {
+	if entry != nil && entry.Object != nil {
+		return cache.ErrWrongType
+	}
+	return nil
+}
+
+// getString reads a string key, failing with cache.ErrWrongType if the key
+// holds another type. The value is copied under the lock, since bit
+// commands write to string values in place.
+func (h *CommandHandler) getString(key string) (string, bool, error) {
+	var value string
+	found := false
+	err := h.cache.View(key, func(entry *cache.Entry) error {
+		if err := checkString(entry); err != nil {
+			return err
+		}
+		if entry != nil {
+			value, found = string(entry.Value), true
+		}
+		return nil
+	})
+	return value, found, err
+}
+
+func (h *CommandHandler) handleIncr(name string, args []Value, sign int64, explicit bool) Value {
+	if (explicit && len(args) != 2) || (!explicit && len(args) != 1) {
+		return wrongArgs(name)
+	}
+	
+	delta := sign
+	if explicit {
+		n, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil {
+			return errorReply(errNotInteger)
+		}
+		if sign < 0 {
+			if n == math.MinInt64 {
+				return NewError("ERR decrement would overflow")
+			}
+			n = -n
+		}
+		delta = n
+	}
+	
+	var result int64
+	err := h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		var current int64
+		if entry != nil {
+			n, err := strconv.ParseInt(string(entry.Value), 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			current = n
+		}
+		
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return nil, errOverflow
+		}
+		result = current + delta
+		
+		value := strconv.AppendInt(nil, result, 10)
+		if entry == nil {
+			return &cache.Entry{Value: value}, nil
+		}
+		entry.Value = value
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(result)
+}
+
+func (h *CommandHandler) handleIncrByFloat(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("incrbyfloat")
+	}
+	
+	delta, err := parseFloat(args[1].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var result []byte
+	err = h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		var current float64
+		if entry != nil {
+			n, err := parseFloat(string(entry.Value))
+			if err != nil {
+				return nil, err
+			}
+			current = n
+		}
+		
+		sum := current + delta
+		if math.IsNaN(sum) || math.IsInf(sum, 0) {
+			return nil, errNaN
+		}
+		result = strconv.AppendFloat(nil, sum, 'f', -1, 64)
+		
+		if entry == nil {
+			return &cache.Entry{Value: result}, nil
+		}
+		entry.Value = result
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewBulkString(string(result))
+}
+
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+// maxStringLength mirrors Redis' proto-max-bulk-len default of 512MB.
+const maxStringLength = 512 * 1024 * 1024
+
+func (h *CommandHandler) handleMGet(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("mget")
+	}
+	
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Str
+	}
+	
+	values, found := h.cache.GetMulti(keys)
+	result := make([]Value, len(keys))
+	for i := range keys {
+		if found[i] {
+			result[i] = NewBulkString(string(values[i]))
+		} else {
+			result[i] = NewNullBulkString()
+		}
+	}
+	return NewArray(result...)
+}
+
+func (h *CommandHandler) handleMSet(name string, args []Value, onlyIfNoneExist bool) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return wrongArgs(name)
+	}
+	
+	keys := make([]string, 0, len(args)/2)
+	values := make([][]byte, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i].Str)
+		values = append(values, []byte(args[i+1].Str))
+	}
+	
+	ok := h.cache.SetMulti(keys, values, onlyIfNoneExist)
+	if !onlyIfNoneExist {
+		return NewSimpleString("OK")
+	}
+	if ok {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+func (h *CommandHandler) handleGetSet(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("getset")
+	}
+	
+	prev, existed, err := h.cache.SetWithOptions(args[0].Str, []byte(args[1].Str), cache.SetOptions{Get: true})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !existed {
+		return NewNullBulkString()
+	}
+	return NewBulkString(string(prev))
+}
+
+func (h *CommandHandler) handleGetDel(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("getdel")
+	}
+	
+	var value []byte
+	found := false
+	err := h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			value, found = entry.Value, true
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewBulkString(string(value))
+}
+
+func (h *CommandHandler) handleGetEx(args []Value) Value {
+	if len(args) == 0 {
+		return wrongArgs("getex")
+	}
+	
+	persist := false
+	var expiresAt int64
+	for i := 1; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].Str)
+		switch arg {
+		case "PERSIST":
+			if persist || expiresAt != 0 {
+				return NewError("ERR syntax error")
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if persist || expiresAt != 0 || i+1 >= len(args) {
+				return NewError("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return errorReply(errNotInteger)
+			}
+			i++
+			if n <= 0 {
+				return NewError("ERR invalid expire time in 'getex' command")
+			}
+			unit := time.Second
+			if arg == "PX" || arg == "PXAT" {
+				unit = time.Millisecond
+			}
+			at, ok := expiryTime(n, unit, arg == "EXAT" || arg == "PXAT")
+			if !ok {
+				return NewError("ERR invalid expire time in 'getex' command")
+			}
+			expiresAt = at
+		default:
+			return NewError("ERR syntax error")
+		}
+	}
+	
+	if !persist && expiresAt == 0 {
+		value, exists, err := h.getString(args[0].Str)
+		if err != nil {
+			return errorReply(err)
+		}
+		if !exists {
+			return NewNullBulkString()
+		}
+		return NewBulkString(value)
+	}
+	
+	var value string
+	found := false
+	err := h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, nil
+		}
+		value, found = string(entry.Value), true
+		if !persist && expiresAt <= time.Now().UnixNano() {
+			return nil, nil
+		}
+		entry.ExpiresAt = expiresAt
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewBulkString(value)
+}
+
+func (h *CommandHandler) handleAppend(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("append")
+	}
+	
+	var length int
+	err := h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			length = len(args[1].Str)
+			return &cache.Entry{Value: []byte(args[1].Str)}, nil
+		}
+		if len(entry.Value)+len(args[1].Str) > maxStringLength {
+			return nil, errStringTooLong
+		}
+		// Appending never touches bytes below the old length, so slices of
+		// the previous value already returned by Get stay valid.
+		entry.Value = append(entry.Value, args[1].Str...)
+		length = len(entry.Value)
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(length))
+}
+
+func (h *CommandHandler) handleStrLen(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("strlen")
+	}
+	
+	var length int
+	err := h.cache.View(args[0].Str, func(entry *cache.Entry) error {
+		if err := checkString(entry); err != nil {
+			return err
+		}
+		if entry != nil {
+			length = len(entry.Value)
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(length))
+}
+
+func (h *CommandHandler) handleSetRange(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("setrange")
+	}
+	
+	offset, err := strconv.ParseInt(args[1].Str, 10, 64)
+	if err != nil {
+		return errorReply(errNotInteger)
+	}
+	if offset < 0 {
+		return NewError("ERR offset is out of range")
+	}
+	patch := args[2].Str
+	if offset+int64(len(patch)) > maxStringLength {
+		return errorReply(errStringTooLong)
+	}
+	
+	var length int
+	err = h.cache.Update(args[0].Str, func(entry *cache.Entry) (*cache.Entry, error) {
+		if err := checkString(entry); err != nil {
+			return nil, err
+		}
+		var current []byte
+		if entry != nil {
+			current = entry.Value
+		}
+		if len(patch) == 0 {
+			length = len(current)
+			return entry, nil
+		}
+		
+		size := len(current)
+		if end := int(offset) + len(patch); end > size {
+			size = end
+		}
+		// Values may be shared with readers, so the patch goes into a copy.
+		value := make([]byte, size)
+		copy(value, current)
+		copy(value[offset:], patch)
+		length = size
+		
+		if entry == nil {
+			return &cache.Entry{Value: value}, nil
+		}
+		entry.Value = value
+		return entry, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	return NewInteger(int64(length))
+}
+
+func (h *CommandHandler) handleGetRange(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("getrange")
+	}
+	
+	start, err1 := strconv.ParseInt(args[1].Str, 10, 64)
+	end, err2 := strconv.ParseInt(args[2].Str, 10, 64)
+	if err1 != nil || err2 != nil {
+		return errorReply(errNotInteger)
+	}
+	
+	value, _, err := h.getString(args[0].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	from, to, ok := clampRange(start, end, int64(len(value)))
+	if !ok {
+		return NewBulkString("")
+	}
+	return NewBulkString(value[from : to+1])
+}
+
+// clampRange resolves Redis-style inclusive start/end indexes, where negative
+// values count from the end, against a sequence of the given length.
+func clampRange(start, end, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+// handleExpire implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. unit is
+// the duration of one unit of the argument and absolute tells whether it is
+// a Unix timestamp rather than a relative TTL.
+func (h *CommandHandler) handleExpire(name string, args []Value, unit time.Duration, absolute bool) Value {
+	if len(args) < 2 {
+		return wrongArgs(name)
+	}
+	
+	n, err := strconv.ParseInt(args[1].Str, 10, 64)
+	if err != nil {
+		return NewError("ERR value is not an integer or out of range")
+	}
+	
+	var cond cache.ExpireCondition
+	for _, arg := range args[2:] {
+		switch strings.ToUpper(arg.Str) {
+		case "NX":
+			cond |= cache.ExpireNX
+		case "XX":
+			cond |= cache.ExpireXX
+		case "GT":
+			cond |= cache.ExpireGT
+		case "LT":
+			cond |= cache.ExpireLT
+		default:
+			return NewError("ERR Unsupported option " + arg.Str)
+		}
+	}
+	if cond&cache.ExpireNX != 0 && cond != cache.ExpireNX {
+		return NewError("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if cond&cache.ExpireGT != 0 && cond&cache.ExpireLT != 0 {
+		return NewError("ERR GT and LT options at the same time are not compatible")
+	}
+	
+	expiresAt, ok := expiryTime(n, unit, absolute)
+	if !ok {
+		return NewError("ERR invalid expire time in '" + name + "' command")
+	}
+	
+	if h.cache.Expire(args[0].Str, expiresAt, cond) {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+func (h *CommandHandler) handleTTL(name string, args []Value, unit time.Duration) Value {
+	if len(args) != 1 {
+		return wrongArgs(name)
+	}
+	
+	expiresAt, exists := h.cache.ExpiresAt(args[0].Str)
+	if !exists {
+		return NewInteger(-2)
+	}
+	if expiresAt == 0 {
+		return NewInteger(-1)
+	}
+	
+	remaining := expiresAt - time.Now().UnixNano()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return NewInteger((remaining + int64(unit)/2) / int64(unit))
+}
+
+func (h *CommandHandler) handlePersist(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("persist")
+	}
+	
+	if h.cache.Persist(args[0].Str) {
+		return NewInteger(1)
+	}
+	return NewInteger(0)
+}
+
+// expiryTime converts a command argument in the given unit into an absolute
+// expiry in Unix nanoseconds, reporting false on overflow.
+func expiryTime(n int64, unit time.Duration, absolute bool) (int64, bool) {
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, false
+	}
+	at := n * int64(unit)
+	if absolute {
+		return at, true
+	}
+	
+	now := time.Now().UnixNano()
+	if at > 0 && now > math.MaxInt64-at {
+		return 0, false
+	}
+	return now + at, true
+}
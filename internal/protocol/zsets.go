@@ -0,0 +1,548 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+var (
+	errScoreNaN     = errors.New("resulting score is not a number (NaN)")
+	errScoreRange   = errors.New("min or max is not a float")
+	errLexRange     = errors.New("min or max not valid string range item")
+	errZAddNXXX     = errors.New("XX and NX options at the same time are not compatible")
+	errZAddGTLTNX   = errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	errZAddIncrPair = errors.New("INCR option supports a single increment-element pair")
+)
+
+type zrangeBy int
+
+const (
+	zrangeByRank zrangeBy = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+type zrangeOptions struct {
+	by         zrangeBy
+	rev        bool
+	withScores bool
+	offset     int64
+	// count is the LIMIT count, negative for no limit.
+	count int64
+}
+
+func asZSet(entry *cache.Entry) (*cache.SortedSet, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	zset, ok := entry.Object.(*cache.SortedSet)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return zset, nil
+}
+
+// updateZSet runs fn against the sorted set at key, creating it if create is
+// set, and deletes the key once the set is left empty.
+func (h *CommandHandler) updateZSet(key string, create bool, fn func(zset *cache.SortedSet) error) error {
+	return h.cache.Update(key, func(entry *cache.Entry) (*cache.Entry, error) {
+		zset, err := asZSet(entry)
+		if err != nil {
+			return nil, err
+		}
+		if zset == nil {
+			if !create {
+				return nil, nil
+			}
+			zset = cache.NewSortedSet()
+			entry = &cache.Entry{Object: zset}
+		}
+		
+		if err := fn(zset); err != nil {
+			return nil, err
+		}
+		if zset.Len() == 0 {
+			return nil, nil
+		}
+		return entry, nil
+	})
+}
+
+func (h *CommandHandler) viewZSet(key string, fn func(zset *cache.SortedSet)) error {
+	return h.cache.View(key, func(entry *cache.Entry) error {
+		zset, err := asZSet(entry)
+		if err != nil {
+			return err
+		}
+		fn(zset)
+		return nil
+	})
+}
+
+func (h *CommandHandler) handleZAdd(args []Value) Value {
+	if len(args) < 3 {
+		return wrongArgs("zadd")
+	}
+	
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errorReply(errSyntax)
+	}
+	if nx && xx {
+		return errorReply(errZAddNXXX)
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return errorReply(errZAddGTLTNX)
+	}
+	if incr && len(pairs) > 2 {
+		return errorReply(errZAddIncrPair)
+	}
+	
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, err := parseScore(pairs[2*j].Str)
+		if err != nil {
+			return errorReply(err)
+		}
+		scores[j] = score
+	}
+	
+	added, changed := 0, 0
+	var result float64
+	applied := false
+	err := h.updateZSet(args[0].Str, !xx, func(zset *cache.SortedSet) error {
+		for j, score := range scores {
+			member := pairs[2*j+1].Str
+			old, exists := zset.Score(member)
+			if (exists && nx) || (!exists && xx) {
+				continue
+			}
+			
+			if incr && exists {
+				score += old
+				if math.IsNaN(score) {
+					return errScoreNaN
+				}
+			}
+			if exists && ((gt && score <= old) || (lt && score >= old)) {
+				continue
+			}
+			
+			if zset.Add(member, score) {
+				added++
+			} else if score != old {
+				changed++
+			}
+			result, applied = score, true
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if incr {
+		if !applied {
+			return NewNullBulkString()
+		}
+		return NewBulkString(formatScore(result))
+	}
+	if ch {
+		return NewInteger(int64(added + changed))
+	}
+	return NewInteger(int64(added))
+}
+
+func (h *CommandHandler) handleZIncrBy(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("zincrby")
+	}
+	
+	delta, err := parseScore(args[1].Str)
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	var score float64
+	err = h.updateZSet(args[0].Str, true, func(zset *cache.SortedSet) error {
+		old, _ := zset.Score(args[2].Str)
+		score = old + delta
+		if math.IsNaN(score) {
+			return errScoreNaN
+		}
+		zset.Add(args[2].Str, score)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewBulkString(formatScore(score))
+}
+
+func (h *CommandHandler) handleZRem(args []Value) Value {
+	if len(args) < 2 {
+		return wrongArgs("zrem")
+	}
+	
+	removed := 0
+	err := h.updateZSet(args[0].Str, false, func(zset *cache.SortedSet) error {
+		for _, arg := range args[1:] {
+			if zset.Remove(arg.Str) {
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(removed))
+}
+
+func (h *CommandHandler) handleZScore(args []Value) Value {
+	if len(args) != 2 {
+		return wrongArgs("zscore")
+	}
+	
+	var score float64
+	found := false
+	err := h.viewZSet(args[0].Str, func(zset *cache.SortedSet) {
+		if zset != nil {
+			score, found = zset.Score(args[1].Str)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewBulkString(formatScore(score))
+}
+
+func (h *CommandHandler) handleZCard(args []Value) Value {
+	if len(args) != 1 {
+		return wrongArgs("zcard")
+	}
+	
+	length := 0
+	err := h.viewZSet(args[0].Str, func(zset *cache.SortedSet) {
+		if zset != nil {
+			length = zset.Len()
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(length))
+}
+
+func (h *CommandHandler) handleZCount(args []Value) Value {
+	if len(args) != 3 {
+		return wrongArgs("zcount")
+	}
+	
+	min, err1 := parseScoreBound(args[1].Str)
+	max, err2 := parseScoreBound(args[2].Str)
+	if err1 != nil || err2 != nil {
+		return errorReply(errScoreRange)
+	}
+	
+	count := 0
+	err := h.viewZSet(args[0].Str, func(zset *cache.SortedSet) {
+		if zset == nil {
+			return
+		}
+		zset.RangeByScore(min, max, false, func(string, float64) bool {
+			count++
+			return true
+		})
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewInteger(int64(count))
+}
+
+// handleZRank implements ZRANK and ZREVRANK.
+func (h *CommandHandler) handleZRank(name string, args []Value, rev bool) Value {
+	if len(args) != 2 {
+		return wrongArgs(name)
+	}
+	
+	rank := 0
+	found := false
+	err := h.viewZSet(args[0].Str, func(zset *cache.SortedSet) {
+		if zset == nil {
+			return
+		}
+		rank, found = zset.Rank(args[1].Str)
+		if rev {
+			rank = zset.Len() - 1 - rank
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	if !found {
+		return NewNullBulkString()
+	}
+	return NewInteger(int64(rank))
+}
+
+// handleZRange implements ZRANGE and the older range commands it
+// supersedes; opts carries what the command name implies, and only ZRANGE
+// itself accepts BYSCORE, BYLEX and REV.
+func (h *CommandHandler) handleZRange(name string, args []Value, opts zrangeOptions, extended bool) Value {
+	if len(args) < 3 {
+		return wrongArgs(name)
+	}
+	
+	opts.count = -1
+	limit := false
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].Str); {
+		case option == "WITHSCORES":
+			opts.withScores = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.ParseInt(args[i+1].Str, 10, 64)
+			count, err2 := strconv.ParseInt(args[i+2].Str, 10, 64)
+			if err1 != nil || err2 != nil {
+				return errorReply(errNotInteger)
+			}
+			opts.offset, opts.count = offset, count
+			limit = true
+			i += 2
+		case option == "BYSCORE" && extended:
+			opts.by = zrangeByScore
+		case option == "BYLEX" && extended:
+			opts.by = zrangeByLex
+		case option == "REV" && extended:
+			opts.rev = true
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+	
+	if limit && opts.by == zrangeByRank {
+		return NewError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if opts.withScores && opts.by == zrangeByLex {
+		return NewError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	
+	return h.zrange(args[0].Str, args[1].Str, args[2].Str, opts)
+}
+
+// zrange replies with the members between start and stop, which are ranks,
+// scores or lex bounds depending on opts.by. Reversed score and lex ranges
+// take the upper bound first, as in Redis.
+func (h *CommandHandler) zrange(key, start, stop string, opts zrangeOptions) Value {
+	var startRank, stopRank int64
+	var minScore, maxScore cache.ScoreBound
+	var minLex, maxLex cache.LexBound
+	if opts.rev && opts.by != zrangeByRank {
+		start, stop = stop, start
+	}
+	
+	switch opts.by {
+	case zrangeByRank:
+		var err1, err2 error
+		startRank, err1 = strconv.ParseInt(start, 10, 64)
+		stopRank, err2 = strconv.ParseInt(stop, 10, 64)
+		if err1 != nil || err2 != nil {
+			return errorReply(errNotInteger)
+		}
+	case zrangeByScore:
+		var err1, err2 error
+		minScore, err1 = parseScoreBound(start)
+		maxScore, err2 = parseScoreBound(stop)
+		if err1 != nil || err2 != nil {
+			return errorReply(errScoreRange)
+		}
+	case zrangeByLex:
+		var err1, err2 error
+		minLex, err1 = parseLexBound(start)
+		maxLex, err2 = parseLexBound(stop)
+		if err1 != nil || err2 != nil {
+			return errorReply(errLexRange)
+		}
+	}
+	
+	items := []Value{}
+	if opts.offset < 0 {
+		return NewArray(items...)
+	}
+	emit := func(member string, score float64) bool {
+		if opts.offset > 0 {
+			opts.offset--
+			return true
+		}
+		if opts.count == 0 {
+			return false
+		}
+		items = append(items, NewBulkString(member))
+		if opts.withScores {
+			items = append(items, NewBulkString(formatScore(score)))
+		}
+		if opts.count > 0 {
+			opts.count--
+		}
+		return true
+	}
+	
+	err := h.viewZSet(key, func(zset *cache.SortedSet) {
+		if zset == nil {
+			return
+		}
+		switch opts.by {
+		case zrangeByRank:
+			from, to, ok := clampRange(startRank, stopRank, int64(zset.Len()))
+			if ok {
+				zset.RangeByRank(int(from), int(to), opts.rev, emit)
+			}
+		case zrangeByScore:
+			zset.RangeByScore(minScore, maxScore, opts.rev, emit)
+		case zrangeByLex:
+			zset.RangeByLex(minLex, maxLex, opts.rev, emit)
+		}
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(items...)
+}
+
+// handleZPop implements ZPOPMIN and ZPOPMAX, replying with member and score
+// pairs.
+func (h *CommandHandler) handleZPop(name string, args []Value, max bool) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgs(name)
+	}
+	
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil || n < 0 {
+			return errorReply(errMustBePositive)
+		}
+		count = int(n)
+	}
+	
+	items := []Value{}
+	err := h.updateZSet(args[0].Str, false, func(zset *cache.SortedSet) error {
+		n := count
+		if n > zset.Len() {
+			n = zset.Len()
+		}
+		if n == 0 {
+			return nil
+		}
+		
+		var members []string
+		zset.RangeByRank(0, n-1, max, func(member string, score float64) bool {
+			members = append(members, member)
+			items = append(items, NewBulkString(member), NewBulkString(formatScore(score)))
+			return true
+		})
+		for _, member := range members {
+			zset.Remove(member)
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	
+	return NewArray(items...)
+}
+
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// parseScoreBound parses a ZRANGE score bound, where a leading '(' makes it
+// exclusive.
+func parseScoreBound(s string) (cache.ScoreBound, error) {
+	var bound cache.ScoreBound
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+	
+	score, err := parseScore(s)
+	if err != nil {
+		return bound, err
+	}
+	bound.Score = score
+	return bound, nil
+}
+
+// parseLexBound parses a ZRANGE lex bound: "-", "+", or a member prefixed
+// with '[' if inclusive or '(' if exclusive.
+func parseLexBound(s string) (cache.LexBound, error) {
+	switch {
+	case s == "-":
+		return cache.LexBound{Inf: -1}, nil
+	case s == "+":
+		return cache.LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return cache.LexBound{Member: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return cache.LexBound{Member: s[1:], Exclusive: true}, nil
+	default:
+		return cache.LexBound{}, errLexRange
+	}
+}
+
+// formatScore renders a score the way Redis does: integral scores without
+// an exponent, others in their shortest round-tripping form.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case score == math.Trunc(score) && math.Abs(score) < 1e17:
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+}
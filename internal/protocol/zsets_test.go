@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/tectix/hpcs/internal/cache"
+)
+
+func TestZAddFlags(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"ZADD", "z", "1", "a", "2", "b"}, ":2"},
+		{[]string{"ZADD", "z", "5", "a", "3", "c"}, ":1"},
+		{[]string{"ZSCORE", "z", "a"}, "5"},
+		
+		{[]string{"ZADD", "z", "NX", "9", "a", "4", "d"}, ":1"},
+		{[]string{"ZSCORE", "z", "a"}, "5"},
+		{[]string{"ZADD", "z", "XX", "6", "a", "7", "e"}, ":0"},
+		{[]string{"ZSCORE", "z", "a"}, "6"},
+		{[]string{"ZSCORE", "z", "e"}, "(nil)"},
+		{[]string{"ZADD", "missing", "XX", "1", "a"}, ":0"},
+		{[]string{"EXISTS", "missing"}, ":0"},
+		
+		// GT and LT only restrict updates; new members are still added.
+		{[]string{"ZADD", "z", "GT", "1", "a", "8", "b", "1", "f"}, ":1"},
+		{[]string{"ZRANGE", "z", "0", "-1", "WITHSCORES"}, "[f 1 c 3 d 4 a 6 b 8]"},
+		{[]string{"ZADD", "z", "LT", "CH", "7", "a", "2", "b", "0", "g"}, ":2"},
+		{[]string{"ZRANGE", "z", "0", "-1", "WITHSCORES"}, "[g 0 f 1 b 2 c 3 d 4 a 6]"},
+		
+		// CH counts changed scores, but not members set to the same score.
+		{[]string{"ZADD", "z", "CH", "6", "a", "10", "c", "11", "h"}, ":2"},
+		{[]string{"ZADD", "z", "XX", "CH", "10", "c"}, ":0"},
+		
+		{[]string{"ZADD", "z", "INCR", "2.5", "a"}, "8.5"},
+		{[]string{"ZADD", "z", "INCR", "1", "new"}, "1"},
+		{[]string{"ZADD", "z", "NX", "INCR", "1", "a"}, "(nil)"},
+		{[]string{"ZADD", "z", "XX", "INCR", "1", "absent"}, "(nil)"},
+		{[]string{"ZADD", "z", "GT", "INCR", "-1", "a"}, "(nil)"},
+		{[]string{"ZADD", "z", "LT", "INCR", "-1", "a"}, "7.5"},
+		{[]string{"ZADD", "inf", "1", "a"}, ":1"},
+		{[]string{"ZADD", "inf", "INCR", "+inf", "a"}, "inf"},
+		{[]string{"ZADD", "inf", "INCR", "-inf", "a"}, "-ERR resulting score is not a number (NaN)"},
+		
+		{[]string{"ZADD", "z", "NX", "XX", "1", "a"}, "-ERR XX and NX options at the same time are not compatible"},
+		{[]string{"ZADD", "z", "GT", "LT", "1", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible"},
+		{[]string{"ZADD", "z", "NX", "GT", "1", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible"},
+		{[]string{"ZADD", "z", "INCR", "1", "a", "2", "b"}, "-ERR INCR option supports a single increment-element pair"},
+		{[]string{"ZADD", "z", "1", "a", "2"}, "-ERR syntax error"},
+		{[]string{"ZADD", "z", "NX", "CH"}, "-ERR syntax error"},
+		{[]string{"ZADD", "z", "XX", "CH", "1"}, "-ERR syntax error"},
+		{[]string{"ZADD", "z", "one", "a"}, "-ERR value is not a valid float"},
+		{[]string{"ZADD", "z", "nan", "a"}, "-ERR value is not a valid float"},
+		{[]string{"ZADD", "z", "1"}, "-ERR wrong number of arguments for 'zadd' command"},
+		{[]string{"SET", "s", "v"}, "+OK"},
+		{[]string{"ZADD", "s", "1", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
+
+func TestZRange(t *testing.T) {
+	handler := NewCommandHandler(cache.New(1024*1024*1024, nil))
+	runSteps(t, handler, []commandStep{
+		{[]string{"ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"}, ":5"},
+		{[]string{"ZRANGE", "z", "0", "-1"}, "[a b c d e]"},
+		{[]string{"ZRANGE", "z", "1", "2", "WITHSCORES"}, "[b 2 c 3]"},
+		{[]string{"ZRANGE", "z", "-2", "100"}, "[d e]"},
+		{[]string{"ZRANGE", "z", "3", "1"}, "[]"},
+		{[]string{"ZRANGE", "z", "0", "1", "REV"}, "[e d]"},
+		{[]string{"ZREVRANGE", "z", "0", "1", "WITHSCORES"}, "[e 5 d 4]"},
+		{[]string{"ZRANGE", "missing", "0", "-1"}, "[]"},
+		
+		{[]string{"ZRANGE", "z", "2", "4", "BYSCORE"}, "[b c d]"},
+		{[]string{"ZRANGE", "z", "(2", "4", "BYSCORE"}, "[c d]"},
+		{[]string{"ZRANGE", "z", "-inf", "+inf", "BYSCORE", "LIMIT", "1", "2"}, "[b c]"},
+		{[]string{"ZRANGE", "z", "-inf", "+inf", "BYSCORE", "LIMIT", "3", "-1"}, "[d e]"},
+		{[]string{"ZRANGE", "z", "-inf", "+inf", "BYSCORE", "LIMIT", "-1", "2"}, "[]"},
+		{[]string{"ZRANGE", "z", "-inf", "+inf", "BYSCORE", "LIMIT", "0", "0"}, "[]"},
+		{[]string{"ZRANGE", "z", "4", "(2", "BYSCORE", "REV", "WITHSCORES"}, "[d 4 c 3]"},
+		{[]string{"ZRANGE", "z", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "1"}, "[d]"},
+		{[]string{"ZRANGEBYSCORE", "z", "(1", "(5", "LIMIT", "1", "5"}, "[c d]"},
+		{[]string{"ZREVRANGEBYSCORE", "z", "5", "4", "WITHSCORES"}, "[e 5 d 4]"},
+		
+		{[]string{"ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d"}, ":4"},
+		{[]string{"ZRANGE", "lex", "[b", "+", "BYLEX"}, "[b c d]"},
+		{[]string{"ZRANGE", "lex", "(a", "[c", "BYLEX"}, "[b c]"},
+		{[]string{"ZRANGE", "lex", "-", "+", "BYLEX", "LIMIT", "1", "2"}, "[b c]"},
+		{[]string{"ZRANGE", "lex", "[c", "-", "BYLEX", "REV"}, "[c b a]"},
+		{[]string{"ZRANGE", "lex", "+", "(a", "BYLEX", "REV", "LIMIT", "0", "2"}, "[d c]"},
+		{[]string{"ZRANGEBYLEX", "lex", "[aa", "(d"}, "[b c]"},
+		{[]string{"ZREVRANGEBYLEX", "lex", "(d", "-"}, "[c b a]"},
+		
+		{[]string{"ZRANGE", "z", "0", "-1", "LIMIT", "0", "1"}, "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"},
+		{[]string{"ZRANGE", "lex", "-", "+", "BYLEX", "WITHSCORES"}, "-ERR syntax error, WITHSCORES not supported in combination with BYLEX"},
+		{[]string{"ZRANGE", "z", "a", "b", "BYSCORE"}, "-ERR min or max is not a float"},
+		{[]string{"ZRANGE", "lex", "a", "+", "BYLEX"}, "-ERR min or max not valid string range item"},
+		{[]string{"ZRANGE", "z", "a", "1"}, "-ERR value is not an integer or out of range"},
+		{[]string{"ZRANGE", "z", "0", "1", "BYSCORE", "LIMIT", "x", "1"}, "-ERR value is not an integer or out of range"},
+		{[]string{"ZRANGE", "z", "0", "1", "BYSCORE", "LIMIT", "1"}, "-ERR syntax error"},
+		{[]string{"ZRANGE", "z", "0", "1", "BYRANK"}, "-ERR syntax error"},
+		{[]string{"ZREVRANGE", "z", "0", "1", "REV"}, "-ERR syntax error"},
+		{[]string{"ZRANGEBYSCORE", "z", "0", "1", "BYLEX"}, "-ERR syntax error"},
+		{[]string{"ZRANGE", "z", "0"}, "-ERR wrong number of arguments for 'zrange' command"},
+		{[]string{"SET", "s", "v"}, "+OK"},
+		{[]string{"ZRANGE", "s", "0", "-1"}, "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+	})
+}
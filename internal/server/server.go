@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -14,10 +16,34 @@ import (
 	"github.com/tectix/hpcs/internal/cache"
 	"github.com/tectix/hpcs/internal/cluster"
 	"github.com/tectix/hpcs/internal/config"
+	"github.com/tectix/hpcs/internal/eviction"
 	"github.com/tectix/hpcs/internal/metrics"
 	"github.com/tectix/hpcs/internal/protocol"
 )
 
+const (
+	// expireBudget caps how long a single active expire cycle may run.
+	expireBudget = 25 * time.Millisecond
+	
+	// maxReplyBatch is how many bytes of replies to pipelined commands are
+	// gathered before writing them out even if more commands are waiting.
+	maxReplyBatch = 16 * 1024
+	
+	// maxReplyBuffer bounds the reply buffer a connection keeps between
+	// batches, so one huge reply does not pin its memory.
+	maxReplyBuffer = 64 * 1024
+)
+
+// operations interns the lower-cased command names used as metric labels.
+// Prometheus keeps label values, which must not point into a request the
+// parser is going to reuse, so each name is copied once here rather than on
+// every request. Only commands the handler knows are added, which bounds
+// both the map and the label set.
+var operations = struct {
+	sync.RWMutex
+	names map[string]string
+}{names: make(map[string]string)}
+
 type Server struct {
 	cfg      *config.Config
 	logger   *zap.Logger
@@ -29,9 +55,13 @@ type Server struct {
 	wg       sync.WaitGroup
 }
 
-func New(cfg *config.Config, logger *zap.Logger) *Server {
+func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 	maxSize := parseMemorySize(cfg.Cache.MaxMemory)
-	cacheInstance := cache.New(maxSize)
+	newPolicy, err := eviction.New(cfg.Cache.EvictionPolicy)
+	if err != nil {
+		return nil, err
+	}
+	cacheInstance := cache.NewSharded(maxSize, cfg.Cache.Shards, newPolicy)
 	handler := protocol.NewCommandHandler(cacheInstance)
 	
 	selfAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -46,7 +76,7 @@ func New(cfg *config.Config, logger *zap.Logger) *Server {
 		handler:  handler,
 		cluster:  clusterInstance,
 		shutdown: make(chan struct{}),
-	}
+	}, nil
 }
 
 func (s *Server) Start() error {
@@ -76,6 +106,11 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to start cluster: %w", err)
 	}
 	
+	if s.cfg.Cache.CleanupInterval > 0 {
+		s.wg.Add(1)
+		go s.expireLoop()
+	}
+	
 	s.wg.Add(1)
 	go s.acceptConnections()
 	
@@ -112,6 +147,38 @@ func (s *Server) acceptConnections() {
 	}
 }
 
+func (s *Server) expireLoop() {
+	defer s.wg.Done()
+	
+	ticker := time.NewTicker(s.cfg.Cache.CleanupInterval)
+	defer ticker.Stop()
+	
+	var reported int64
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			expired := s.cache.DeleteExpired(expireBudget)
+			if expired > 0 {
+				s.logger.Debug("Expired keys removed", zap.Int("count", expired))
+			}
+			reported = s.recordExpiredKeys(reported)
+		}
+	}
+}
+
+// recordExpiredKeys adds the keys expired since the cache's count stood at
+// reported to the metric, and returns the new count. The count covers keys
+// found expired on access as well as those DeleteExpired removed.
+func (s *Server) recordExpiredKeys(reported int64) int64 {
+	total := s.cache.ExpiredKeys()
+	if total > reported {
+		metrics.RecordExpiredKeys(int(total - reported))
+	}
+	return total
+}
+
 func (s *Server) handleConnection(conn net.Conn) {
 	defer s.wg.Done()
 	defer conn.Close()
@@ -123,6 +190,8 @@ func (s *Server) handleConnection(conn net.Conn) {
 	s.logger.Debug("New connection", zap.String("remote", conn.RemoteAddr().String()))
 	
 	parser := protocol.NewParser(conn)
+	session := protocol.NewSession()
+	var out []byte
 	
 	for {
 		if s.cfg.Server.ReadTimeout > 0 {
@@ -132,31 +201,137 @@ func (s *Server) handleConnection(conn net.Conn) {
 		start := time.Now()
 		value, err := parser.Parse()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				s.logger.Debug("Parse error", zap.Error(err))
 			}
-			s.logger.Debug("Parse error", zap.Error(err))
+			if isProtocolError(err) {
+				out = protocol.NewError("ERR Protocol error: " + err.Error()).AppendProtocol(out, session.Protocol)
+			}
+			s.writeReplies(conn, out)
 			break
 		}
 		
-		response := s.handler.Execute(value)
-		
-		if s.cfg.Server.WriteTimeout > 0 {
-			conn.SetWriteDeadline(time.Now().Add(s.cfg.Server.WriteTimeout))
+		var response protocol.Value
+		if protocol.IsBlocking(value) {
+			// Replies to the commands pipelined ahead of this one must not
+			// wait for it to unblock.
+			if err := s.writeReplies(conn, out); err != nil {
+				s.logger.Debug("Write error", zap.Error(err))
+				break
+			}
+			out = out[:0]
+			response = s.executeBlocking(conn, parser, session, value)
+		} else {
+			response = s.handler.ExecuteSession(context.Background(), session, value)
 		}
 		
-		_, err = conn.Write(response.Marshal())
-		if err != nil {
-			s.logger.Debug("Write error", zap.Error(err))
-			break
+		// Replies are gathered while the client has more commands waiting
+		// and written together once it has none, so a pipeline costs one
+		// write per batch rather than one per command.
+		out = response.AppendProtocol(out, session.Protocol)
+		if parser.Buffered() == 0 || len(out) >= maxReplyBatch {
+			if err := s.writeReplies(conn, out); err != nil {
+				s.logger.Debug("Write error", zap.Error(err))
+				break
+			}
+			out = out[:0]
+			if cap(out) > maxReplyBuffer {
+				out = nil
+			}
 		}
 		
 		duration := time.Since(start)
 		if len(value.Array) > 0 {
-			operation := strings.ToLower(value.Array[0].Str)
-			metrics.RecordRequestDuration(operation, duration)
+			metrics.RecordRequestDuration(operationName(value.Array[0].Str, response), duration)
+		}
+	}
+}
+
+// writeReplies writes the replies gathered in out, if any.
+func (s *Server) writeReplies(conn net.Conn, out []byte) error {
+	if len(out) == 0 {
+		return nil
+	}
+	if s.cfg.Server.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.cfg.Server.WriteTimeout))
+	}
+	_, err := conn.Write(out)
+	return err
+}
+
+// executeBlocking runs a command that may park the connection, watching the
+// socket meanwhile so a client that disconnects stops waiting.
+func (s *Server) executeBlocking(conn net.Conn, parser *protocol.Parser, session *protocol.Session, cmd protocol.Value) protocol.Value {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	
+	conn.SetReadDeadline(time.Time{})
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		var netErr net.Error
+		if err := parser.Peek(); err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			cancel()
+		}
+	}()
+	
+	go func() {
+		select {
+		case <-s.shutdown:
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+	
+	response := s.handler.ExecuteSession(ctx, session, cmd)
+	
+	conn.SetReadDeadline(time.Now())
+	<-watching
+	conn.SetReadDeadline(time.Time{})
+	
+	return response
+}
+
+// operationName returns the metric label for the command called name,
+// which got response. Commands the handler does not know share one label.
+func operationName(name string, response protocol.Value) string {
+	if protocol.IsUnknownCommand(response) {
+		return "unknown"
+	}
+	
+	var buf [32]byte
+	lower := buf[:0]
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower = append(lower, c)
+	}
+	
+	operations.RLock()
+	operation, ok := operations.names[string(lower)]
+	operations.RUnlock()
+	if ok {
+		return operation
+	}
+	
+	operation = string(lower)
+	operations.Lock()
+	operations.names[operation] = operation
+	operations.Unlock()
+	return operation
+}
+
+// isProtocolError reports whether a parse error was caused by malformed
+// input, which the client is told about before being disconnected, rather
+// than by the connection itself.
+func isProtocolError(err error) bool {
+	switch err {
+	case protocol.ErrInvalidProtocol, protocol.ErrInvalidType, protocol.ErrUnbalancedQuotes, protocol.ErrInlineTooBig:
+		return true
 	}
+	return false
 }
 
 func parseMemorySize(sizeStr string) int64 {
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+
+	"github.com/tectix/hpcs/internal/cache"
+	"github.com/tectix/hpcs/internal/config"
+	"github.com/tectix/hpcs/internal/metrics"
+	"github.com/tectix/hpcs/internal/protocol"
+)
+
+func TestOperationName(t *testing.T) {
+	ok := protocol.NewSimpleString("OK")
+	if got := operationName("GeT", ok); got != "get" {
+		t.Errorf("Expected the label get, got %q", got)
+	}
+	operationName("get", ok)
+	operationName("GET", ok)
+	
+	before := len(operations.names)
+	for _, name := range []string{"FOO", "bar", "x1", "GeTx"} {
+		unknown := protocol.NewError("ERR unknown command '" + name + "'")
+		if got := operationName(name, unknown); got != "unknown" {
+			t.Errorf("Expected unknown commands to share a label, got %q", got)
+		}
+	}
+	if len(operations.names) != before {
+		t.Errorf("Unknown commands must not be interned, %d names before and %d after", before, len(operations.names))
+	}
+}
+
+// pipeConn serves one connection over an in-memory pipe. The pipe hands
+// each of the server's writes to a single read on the client side, so
+// tests can see how replies were batched.
+func pipeConn(t *testing.T) (*Server, net.Conn, *sync.WaitGroup) {
+	t.Helper()
+	c := cache.New(1024*1024*1024, nil)
+	s := &Server{
+		cfg:      &config.Config{},
+		logger:   zap.NewNop(),
+		cache:    c,
+		handler:  protocol.NewCommandHandler(c),
+		shutdown: make(chan struct{}),
+	}
+	client, conn := net.Pipe()
+	s.wg.Add(1)
+	go s.handleConnection(conn)
+	t.Cleanup(func() { client.Close() })
+	return s, client, &s.wg
+}
+
+// send writes commands to the server as one pipeline.
+func send(client net.Conn, commands ...[]string) {
+	var input []byte
+	for _, args := range commands {
+		values := make([]protocol.Value, len(args))
+		for i, arg := range args {
+			values[i] = protocol.NewBulkString(arg)
+		}
+		input = append(input, protocol.NewArray(values...).Marshal()...)
+	}
+	go client.Write(input)
+}
+
+// readWrite returns what the server wrote in one write.
+func readWrite(t *testing.T, client net.Conn) string {
+	t.Helper()
+	buf := make([]byte, 1024*1024)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestPipelineRepliesInOrder(t *testing.T) {
+	_, client, _ := pipeConn(t)
+	
+	send(client, []string{"SET", "a", "1"}, []string{"INCR", "a"}, []string{"GET", "a"}, []string{"NOSUCH"}, []string{"PING"})
+	want := "+OK\r\n:2\r\n$1\r\n2\r\n-ERR unknown command 'NOSUCH'\r\n+PONG\r\n"
+	if got := readWrite(t, client); got != want {
+		t.Errorf("Expected the pipeline answered in order in one write, got %q, want %q", got, want)
+	}
+}
+
+func TestPipelineFlushesBeforeBlocking(t *testing.T) {
+	s, client, wg := pipeConn(t)
+	
+	send(client, []string{"SET", "a", "1"}, []string{"BLPOP", "list", "0"}, []string{"GET", "a"})
+	if got := readWrite(t, client); got != "+OK\r\n" {
+		t.Fatalf("Expected the reply ahead of BLPOP while it blocks, got %q", got)
+	}
+	
+	s.handler.Execute(protocol.NewArray(protocol.NewBulkString("RPUSH"), protocol.NewBulkString("list"), protocol.NewBulkString("v")))
+	if got, want := readWrite(t, client), "*2\r\n$4\r\nlist\r\n$1\r\nv\r\n$1\r\n1\r\n"; got != want {
+		t.Errorf("Expected the BLPOP reply batched with the one after it, got %q, want %q", got, want)
+	}
+	
+	// A client that disconnects while blocked stops waiting.
+	// The pipe returns from the write once the server has read the command.
+	client.Write(protocol.NewArray(protocol.NewBulkString("BLPOP"), protocol.NewBulkString("list"), protocol.NewBulkString("0")).Marshal())
+	client.Close()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The connection kept blocking after the client disconnected")
+	}
+	s.handler.Execute(protocol.NewArray(protocol.NewBulkString("RPUSH"), protocol.NewBulkString("list"), protocol.NewBulkString("w")))
+	if got := s.handler.Execute(protocol.NewArray(protocol.NewBulkString("LLEN"), protocol.NewBulkString("list"))); got.Int != 1 {
+		t.Errorf("Expected the disconnected client not to pop, list has length %d", got.Int)
+	}
+}
+
+func TestPipelineReplyBatchLimit(t *testing.T) {
+	s, client, _ := pipeConn(t)
+	
+	// Three replies stay under maxReplyBatch and the fourth crosses it.
+	value := strings.Repeat("x", 5000)
+	reply := "$5000\r\n" + value + "\r\n"
+	if 3*len(reply) >= maxReplyBatch || 4*len(reply) < maxReplyBatch {
+		t.Fatalf("Reply size %d does not straddle maxReplyBatch", len(reply))
+	}
+	s.cache.Set("big", []byte(value), 0)
+	
+	commands := make([][]string, 10)
+	for i := range commands {
+		commands[i] = []string{"GET", "big"}
+	}
+	send(client, commands...)
+	for _, replies := range []int{4, 4, 2} {
+		if got := readWrite(t, client); got != strings.Repeat(reply, replies) {
+			t.Errorf("Expected a write of %d replies, got %d bytes", replies, len(got))
+		}
+	}
+}
+
+func expiredKeysMetric(t *testing.T) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.ExpiredKeys.Write(&m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestRecordExpiredKeysCountsLazyExpiry(t *testing.T) {
+	c := cache.New(1024*1024*1024, nil)
+	s := &Server{cache: c}
+	c.Set("lazy", []byte("v"), time.Millisecond)
+	c.Set("active", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	
+	before := expiredKeysMetric(t)
+	if _, ok := c.Get("lazy"); ok {
+		t.Fatal("Expected the key to have expired")
+	}
+	c.DeleteExpired(time.Second)
+	if reported := s.recordExpiredKeys(0); reported != 2 {
+		t.Errorf("Expected 2 expired keys reported, got %d", reported)
+	}
+	if got := expiredKeysMetric(t) - before; got != 2 {
+		t.Errorf("Expected the metric to count both expired keys, it grew by %v", got)
+	}
+	
+	s.recordExpiredKeys(2)
+	if got := expiredKeysMetric(t) - before; got != 2 {
+		t.Errorf("Expected keys already reported not to be counted again, the metric grew by %v", got)
+	}
+}